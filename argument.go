@@ -1,5 +1,10 @@
 package deepbot
 
+import (
+	"slices"
+	"strings"
+)
+
 // textToArgN splits a text into individual argument strings,
 // following the Windows conventions documented at
 // http://daviddeley.com/autohotkey/parameters/parameters.htm#WINARGV
@@ -24,6 +29,32 @@ func textToArgN(text string, n int) []string {
 	return args
 }
 
+// textToFlags splits the leading flags like "--name value" or "--name=value"
+// from the text, the remaining text is returned without any change, so it
+// can be used as the prompt that contain spaces. The flag in switches has
+// no value, and it will be set to "true" if it is appeared.
+func textToFlags(text string, switches ...string) (map[string]string, string) {
+	flags := make(map[string]string)
+	for {
+		text = strings.TrimLeft(text, " \t")
+		if !strings.HasPrefix(text, "--") {
+			return flags, text
+		}
+		var arg []byte
+		arg, text = readNextArg(text[2:])
+		name, value, ok := strings.Cut(string(arg), "=")
+		if !ok && slices.Contains(switches, strings.ToLower(name)) {
+			value, ok = "true", true
+		}
+		if !ok {
+			text = strings.TrimLeft(text, " \t")
+			arg, text = readNextArg(text)
+			value = string(arg)
+		}
+		flags[strings.ToLower(name)] = value
+	}
+}
+
 // readNextArg splits command line string cmd into next
 // argument and command line remainder.
 func readNextArg(cmd string) (arg []byte, rest string) {
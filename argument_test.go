@@ -27,3 +27,33 @@ func TestTextToArgN(t *testing.T) {
 		require.Equal(t, "角色", args[1])
 	})
 }
+
+func TestTextToFlags(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		flags, rest := textToFlags("--seed 123 --neg \"bad hands, blur\" --CFG=7.5 girl, blue --n 2")
+		require.Equal(t, map[string]string{
+			"seed": "123",
+			"neg":  "bad hands, blur",
+			"cfg":  "7.5",
+		}, flags)
+		require.Equal(t, "girl, blue --n 2", rest)
+	})
+
+	t.Run("no flags", func(t *testing.T) {
+		flags, rest := textToFlags("  1024x1024 30 girl")
+		require.Empty(t, flags)
+		require.Equal(t, "1024x1024 30 girl", rest)
+	})
+
+	t.Run("switches", func(t *testing.T) {
+		flags, rest := textToFlags("--raw --seed=1 猫", "raw")
+		require.Equal(t, map[string]string{"raw": "true", "seed": "1"}, flags)
+		require.Equal(t, "猫", rest)
+	})
+
+	t.Run("missing value", func(t *testing.T) {
+		flags, rest := textToFlags("--seed")
+		require.Equal(t, map[string]string{"seed": ""}, flags)
+		require.Empty(t, rest)
+	})
+}
@@ -0,0 +1,167 @@
+package deepbot
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/chromedp/chromedp"
+)
+
+const defaultBrowserTabs = 4
+
+// browser is a shared headless browser, it keeps a warm chromedp allocator
+// and hands out tabs from a bounded pool. If the browser process is crashed,
+// it will be recycled and started again at the next time.
+type browser struct {
+	options []chromedp.ExecAllocatorOption
+
+	// limit the number of the opened tabs
+	tabs chan struct{}
+
+	ctx         context.Context
+	cancel      context.CancelFunc
+	allocCancel context.CancelFunc
+	closed      bool
+	mu          sync.Mutex
+}
+
+func newBrowser(opts []chromedp.ExecAllocatorOption, size int) *browser {
+	if size < 1 {
+		size = defaultBrowserTabs
+	}
+	options := []chromedp.ExecAllocatorOption{
+		chromedp.NoFirstRun,
+		chromedp.NoDefaultBrowserCheck,
+		chromedp.Headless,
+
+		// After Puppeteer's default behavior.
+		chromedp.Flag("disable-background-networking", true),
+		chromedp.Flag("enable-features", "NetworkService,NetworkServiceInProcess"),
+		chromedp.Flag("disable-background-timer-throttling", true),
+		chromedp.Flag("disable-backgrounding-occluded-windows", true),
+		chromedp.Flag("disable-breakpad", true),
+		chromedp.Flag("disable-client-side-phishing-detection", true),
+		chromedp.Flag("disable-default-apps", true),
+		chromedp.Flag("disable-dev-shm-usage", true),
+		chromedp.Flag("disable-features", "site-per-process,Translate,BlinkGenPropertyTrees"),
+		chromedp.Flag("disable-hang-monitor", true),
+		chromedp.Flag("disable-ipc-flooding-protection", true),
+		chromedp.Flag("disable-popup-blocking", true),
+		chromedp.Flag("disable-prompt-on-repost", true),
+		chromedp.Flag("disable-renderer-backgrounding", true),
+		chromedp.Flag("disable-sync", true),
+		chromedp.Flag("force-color-profile", "srgb"),
+		chromedp.Flag("metrics-recording-only", true),
+		chromedp.Flag("safebrowsing-disable-auto-update", true),
+		chromedp.Flag("enable-automation", true),
+		chromedp.Flag("password-store", "basic"),
+		chromedp.Flag("use-mock-keychain", true),
+	}
+	options = append(options, opts...)
+	return &browser{
+		options: options,
+		tabs:    make(chan struct{}, size),
+	}
+}
+
+// start is used to launch the browser process if it is not running.
+func (b *browser) start() (context.Context, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, errors.New("browser is closed")
+	}
+	if b.ctx != nil && b.ctx.Err() == nil {
+		return b.ctx, nil
+	}
+	// clean the crashed browser
+	b.stop()
+	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), b.options...)
+	ctx, cancel := chromedp.NewContext(allocCtx)
+	err := chromedp.Run(ctx)
+	if err != nil {
+		cancel()
+		allocCancel()
+		return nil, err
+	}
+	b.ctx = ctx
+	b.cancel = cancel
+	b.allocCancel = allocCancel
+	return ctx, nil
+}
+
+func (b *browser) stop() {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	if b.allocCancel != nil {
+		b.allocCancel()
+	}
+	b.ctx = nil
+	b.cancel = nil
+	b.allocCancel = nil
+}
+
+// recycle is used to stop the browser that maybe crashed.
+func (b *browser) recycle(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ctx != ctx {
+		return
+	}
+	b.stop()
+}
+
+// NewTab is used to get a new tab from pool, the tab will be closed when
+// the parent context is done or call the release function.
+func (b *browser) NewTab(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	select {
+	case b.tabs <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	var err error
+	for i := 0; i < 2; i++ {
+		var browserCtx context.Context
+		browserCtx, err = b.start()
+		if err != nil {
+			break
+		}
+		tabCtx, tabCancel := chromedp.NewContext(browserCtx)
+		stop := context.AfterFunc(ctx, tabCancel)
+		// create the target for check the browser is alive
+		err = chromedp.Run(tabCtx)
+		if err == nil {
+			release := func() {
+				stop()
+				tabCancel()
+				<-b.tabs
+			}
+			return tabCtx, release, nil
+		}
+		stop()
+		tabCancel()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
+		b.recycle(browserCtx)
+	}
+	<-b.tabs
+	return nil, nil, err
+}
+
+// WarmUp is used to launch the browser before the first call.
+func (b *browser) WarmUp() error {
+	_, err := b.start()
+	return err
+}
+
+// Close is used to stop the browser process and release resource.
+func (b *browser) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.stop()
+}
@@ -0,0 +1,253 @@
+package deepbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	actionOpen       = "open"
+	actionClick      = "click"
+	actionNextPage   = "next_page"
+	actionScroll     = "scroll"
+	actionScreenshot = "screenshot"
+	actionSelect     = "select"
+)
+
+const defaultBrowserActions = 8
+
+// browserSession is a tab that keep opened in one round of tool calls,
+// so that the model can do more actions on the same page.
+type browserSession struct {
+	ctx     context.Context
+	timeout time.Duration
+	policy  *urlPolicy
+	release context.CancelFunc
+}
+
+func (bs *browserSession) Close() {
+	bs.release()
+}
+
+const scriptClickText = `
+(function(text) {
+  const selector = 'a, button, [role=button], [role=link], input[type=submit], input[type=button], summary';
+  const items = Array.from(document.querySelectorAll(selector));
+  const visible = (e) => !!(e.offsetWidth || e.offsetHeight || e.getClientRects().length);
+  const label = (e) => (e.innerText || e.value || e.title || e.getAttribute('aria-label') || '').trim();
+  let target = items.find(e => visible(e) && label(e) === text);
+  if (!target) {
+    target = items.find(e => visible(e) && label(e).includes(text));
+  }
+  if (!target) {
+    return false;
+  }
+  target.removeAttribute('target');
+  target.scrollIntoView();
+  target.click();
+  return true;
+})(%s)
+`
+
+const scriptNextPage = `
+(function() {
+  let target = document.querySelector('a[rel=next], link[rel=next]');
+  if (target && target.tagName === 'LINK') {
+    location.href = target.href;
+    return true;
+  }
+  if (!target) {
+    const pattern = /^(下一页|下页|后一页|next( page)?|older( posts)?|[>›»]+)$/i;
+    const items = Array.from(document.querySelectorAll('a, button, [role=button]'));
+    target = items.find(e => pattern.test((e.innerText || e.title || e.getAttribute('aria-label') || '').trim()));
+  }
+  if (!target) {
+    return false;
+  }
+  target.removeAttribute('target');
+  target.scrollIntoView();
+  target.click();
+  return true;
+})()
+`
+
+const scriptScroll = `
+(function() {
+  window.scrollBy(0, window.innerHeight);
+  return window.scrollY + window.innerHeight >= document.body.scrollHeight;
+})()
+`
+
+const scriptSelect = `
+(function(selector) {
+  return Array.from(document.querySelectorAll(selector)).slice(0, 50).map(e => e.outerHTML).join('\n');
+})(%s)
+`
+
+// browserAction contains the arguments about BrowserAction tool.
+type browserAction struct {
+	Action   string `json:"action"`
+	URL      string `json:"url,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Selector string `json:"selector,omitempty"`
+	Times    int    `json:"times,omitempty"`
+}
+
+// doBrowserAction is used to do an action in the session, if the action is
+// screenshot, the image will be returned and the output is a tip for model.
+func doBrowserAction(bs *browserSession, act *browserAction) (string, []byte, error) {
+	fmt.Println("=============Browser Action=============")
+	fmt.Println(act.Action, act.URL, act.Text, act.Selector, act.Times)
+	fmt.Println("========================================")
+
+	ctx, cancel := context.WithTimeout(bs.ctx, bs.timeout)
+	defer cancel()
+	switch act.Action {
+	case actionOpen:
+		if act.URL == "" {
+			return "", nil, errors.New("url is empty")
+		}
+		err := bs.policy.Check(ctx, act.URL)
+		if err != nil {
+			return "", nil, err
+		}
+		err = chromedp.Run(ctx,
+			chromedp.Navigate(act.URL),
+			chromedp.Sleep(time.Second),
+		)
+		if err != nil {
+			return "", nil, err
+		}
+	case actionClick:
+		if act.Text == "" {
+			return "", nil, errors.New("text is empty")
+		}
+		err := runClickScript(ctx, fmt.Sprintf(scriptClickText, jsString(act.Text)))
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to click \"%s\": %s", act.Text, err)
+		}
+	case actionNextPage:
+		err := runClickScript(ctx, scriptNextPage)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to go to next page: %s", err)
+		}
+	case actionScroll:
+		times := act.Times
+		if times < 1 {
+			times = 1
+		}
+		if times > 10 {
+			times = 10
+		}
+		for i := 0; i < times; i++ {
+			var bottom bool
+			err := chromedp.Run(ctx,
+				chromedp.Evaluate(scriptScroll, &bottom),
+				chromedp.Sleep(800*time.Millisecond),
+			)
+			if err != nil {
+				return "", nil, err
+			}
+			if bottom {
+				break
+			}
+		}
+	case actionScreenshot:
+		var image []byte
+		err := chromedp.Run(ctx, chromedp.CaptureScreenshot(&image))
+		if err != nil {
+			return "", nil, err
+		}
+		return "已截取当前页面可见区域的截图，截图将会在你回复时一起发送给用户。", image, nil
+	case actionSelect:
+		if act.Selector == "" {
+			return "", nil, errors.New("selector is empty")
+		}
+		var (
+			fragment string
+			location string
+		)
+		err := chromedp.Run(ctx,
+			chromedp.Location(&location),
+			chromedp.Evaluate(fmt.Sprintf(scriptSelect, jsString(act.Selector)), &fragment),
+		)
+		if err != nil {
+			return "", nil, err
+		}
+		if fragment == "" {
+			return "没有找到与选择器匹配的元素: " + act.Selector, nil, nil
+		}
+		output, err := extractFragment(fragment, location)
+		if err != nil {
+			return "", nil, err
+		}
+		return "URL: " + location + "\n\n" + output, nil, nil
+	default:
+		return "", nil, fmt.Errorf("unknown action: %s", act.Action)
+	}
+	output, err := readPageContent(ctx, bs.policy.maxSize)
+	if err != nil {
+		return "", nil, err
+	}
+	return output, nil, nil
+}
+
+func runClickScript(ctx context.Context, script string) error {
+	var clicked bool
+	err := chromedp.Run(ctx, chromedp.Evaluate(script, &clicked))
+	if err != nil {
+		return err
+	}
+	if !clicked {
+		return errors.New("target element is not found")
+	}
+	// wait the navigation or the dynamic content
+	return chromedp.Run(ctx,
+		chromedp.Sleep(1500*time.Millisecond),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+	)
+}
+
+// readPageContent is used to extract the main content of current page,
+// the document that larger than the max size will be truncated.
+func readPageContent(ctx context.Context, maxSize int64) (string, error) {
+	var (
+		document string
+		location string
+	)
+	err := chromedp.Run(ctx,
+		chromedp.Location(&location),
+		chromedp.OuterHTML("html", &document, chromedp.ByQuery),
+	)
+	if err != nil {
+		return "", err
+	}
+	if int64(len(document)) > maxSize {
+		document = document[:maxSize]
+	}
+	content, err := extractContent(document, location)
+	if err != nil {
+		return "", err
+	}
+	// fallback to the visible text if extract nothing
+	if content.Content == "" {
+		var text string
+		err = chromedp.Run(ctx, chromedp.Text("/html/body", &text, chromedp.BySearch))
+		if err != nil {
+			return "", err
+		}
+		content.Content = strings.TrimSpace(text)
+	}
+	return content.String(), nil
+}
+
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
@@ -0,0 +1,76 @@
+package deepbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoBrowserAction(t *testing.T) {
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><body>
+<h1>Index</h1>
+<p id="info">This is the first page about browser action test.</p>
+<a href="/page2">下一页</a>
+</body></html>`))
+	})
+	serveMux.HandleFunc("/page2", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><body>
+<h1>Page 2</h1>
+<p>This is the second page about browser action test.</p>
+</body></html>`))
+	})
+	server := httptest.NewServer(serveMux)
+	defer server.Close()
+
+	opts := []chromedp.ExecAllocatorOption{
+		chromedp.ExecPath(chromePath),
+	}
+	browser := newBrowser(opts, 1)
+	defer browser.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx, release, err := browser.NewTab(ctx)
+	require.NoError(t, err)
+	// the test server is listening on loopback
+	config := new(Config)
+	config.URLPolicy.AllowPrivate = true
+	policy := newURLPolicy(config)
+	err = policy.Guard(ctx)
+	require.NoError(t, err)
+	session := &browserSession{
+		ctx:     ctx,
+		timeout: 15 * time.Second,
+		policy:  policy,
+		release: release,
+	}
+	defer session.Close()
+
+	output, _, err := doBrowserAction(session, &browserAction{Action: actionOpen, URL: server.URL})
+	require.NoError(t, err)
+	require.Contains(t, output, "first page")
+
+	output, _, err = doBrowserAction(session, &browserAction{Action: actionSelect, Selector: "#info"})
+	require.NoError(t, err)
+	require.Contains(t, output, "first page")
+
+	output, _, err = doBrowserAction(session, &browserAction{Action: actionNextPage})
+	require.NoError(t, err)
+	require.Contains(t, output, "second page")
+
+	_, image, err := doBrowserAction(session, &browserAction{Action: actionScreenshot})
+	require.NoError(t, err)
+	require.NotEmpty(t, image)
+
+	_, _, err = doBrowserAction(session, &browserAction{Action: actionClick, Text: "not exist"})
+	require.Error(t, err)
+	fmt.Println(err)
+}
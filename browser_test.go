@@ -0,0 +1,52 @@
+package deepbot
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrowser(t *testing.T) {
+	opts := []chromedp.ExecAllocatorOption{
+		chromedp.ExecPath(chromePath),
+	}
+	browser := newBrowser(opts, 2)
+	defer browser.Close()
+
+	// require can not be used in other goroutines, so collect the errors
+	errs := make([]error, 4)
+	wg := sync.WaitGroup{}
+	for i := 0; i < len(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+			ctx, release, err := browser.NewTab(ctx)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer release()
+
+			var title string
+			errs[i] = chromedp.Run(ctx,
+				chromedp.Navigate("about:blank"),
+				chromedp.Title(&title),
+			)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	browser.Close()
+	_, _, err := browser.NewTab(context.Background())
+	require.Error(t, err)
+}
@@ -0,0 +1,527 @@
+package deepbot
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// dimension index about quantity
+const (
+	dimLength = iota
+	dimMass
+	dimTime
+	dimData
+	dimTemp
+	numDims
+)
+
+var dimNames = [numDims]string{"m", "kg", "s", "B", "K"}
+
+type dims [numDims]int
+
+type calcUnit struct {
+	dim    int
+	factor float64 // multiply to base unit
+	offset float64 // only used by temperature
+}
+
+var calcUnits = map[string]calcUnit{
+	// length
+	"mm": {dimLength, 1e-3, 0},
+	"cm": {dimLength, 1e-2, 0},
+	"m":  {dimLength, 1, 0},
+	"km": {dimLength, 1e3, 0},
+	"in": {dimLength, 0.0254, 0},
+	"ft": {dimLength, 0.3048, 0},
+	"yd": {dimLength, 0.9144, 0},
+	"mi": {dimLength, 1609.344, 0},
+	"nm": {dimLength, 1e-9, 0},
+	"um": {dimLength, 1e-6, 0},
+	// mass
+	"mg": {dimMass, 1e-6, 0},
+	"g":  {dimMass, 1e-3, 0},
+	"kg": {dimMass, 1, 0},
+	"t":  {dimMass, 1e3, 0},
+	"lb": {dimMass, 0.45359237, 0},
+	"oz": {dimMass, 0.028349523125, 0},
+	// time
+	"ms":  {dimTime, 1e-3, 0},
+	"s":   {dimTime, 1, 0},
+	"min": {dimTime, 60, 0},
+	"h":   {dimTime, 3600, 0},
+	"d":   {dimTime, 86400, 0},
+	"wk":  {dimTime, 604800, 0},
+	// data
+	"bit": {dimData, 0.125, 0},
+	"B":   {dimData, 1, 0},
+	"KB":  {dimData, 1e3, 0},
+	"MB":  {dimData, 1e6, 0},
+	"GB":  {dimData, 1e9, 0},
+	"TB":  {dimData, 1e12, 0},
+	"KiB": {dimData, 1 << 10, 0},
+	"MiB": {dimData, 1 << 20, 0},
+	"GiB": {dimData, 1 << 30, 0},
+	"TiB": {dimData, 1 << 40, 0},
+	// temperature
+	"K": {dimTemp, 1, 0},
+	"C": {dimTemp, 1, 273.15},
+	"F": {dimTemp, 5.0 / 9, 459.67 * 5 / 9},
+}
+
+var calcConsts = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+type quantity struct {
+	val  float64
+	dims dims
+}
+
+func (q quantity) isScalar() bool {
+	return q.dims == dims{}
+}
+
+// calculate is a safe arithmetic evaluator with basic unit conversion,
+// it supports expression like "sqrt(2) * 3", "5 km + 300 m to mi" and "30 C to F".
+func calculate(expr string) (string, error) {
+	tokens, err := tokenizeCalc(expr)
+	if err != nil {
+		return "", err
+	}
+	p := calcParser{tokens: tokens}
+	q, err := p.parseExpr()
+	if err != nil {
+		return "", err
+	}
+	// process unit conversion
+	var target string
+	if tok := p.peek(); tok == "to" || tok == "in" || tok == "->" {
+		p.next()
+		target = strings.Join(p.tokens[p.pos:], "")
+		p.pos = len(p.tokens)
+	}
+	if p.pos != len(p.tokens) {
+		return "", fmt.Errorf("unexpected token \"%s\"", p.peek())
+	}
+	if target == "" {
+		return formatQuantity(q), nil
+	}
+	return convertQuantity(q, target)
+}
+
+func convertQuantity(q quantity, target string) (string, error) {
+	unit, ok := calcUnits[target]
+	if ok {
+		var d dims
+		d[unit.dim] = 1
+		if q.dims != d {
+			return "", fmt.Errorf("cannot convert %s to %s", dimsString(q.dims), target)
+		}
+		val := (q.val - unit.offset) / unit.factor
+		return formatFloat(val) + " " + target, nil
+	}
+	tokens, err := tokenizeCalc(target)
+	if err != nil {
+		return "", err
+	}
+	p := calcParser{tokens: tokens, noOffset: true}
+	t, err := p.parseExpr()
+	if err != nil {
+		return "", err
+	}
+	if p.pos != len(p.tokens) {
+		return "", fmt.Errorf("invalid target unit \"%s\"", target)
+	}
+	if q.dims != t.dims {
+		return "", fmt.Errorf("cannot convert %s to %s", dimsString(q.dims), target)
+	}
+	return formatFloat(q.val/t.val) + " " + target, nil
+}
+
+func formatQuantity(q quantity) string {
+	if q.isScalar() {
+		return formatFloat(q.val)
+	}
+	return formatFloat(q.val) + " " + dimsString(q.dims)
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(val, 'g', 12, 64)
+}
+
+func dimsString(d dims) string {
+	var num, den []string
+	for i := 0; i < numDims; i++ {
+		n := d[i]
+		switch {
+		case n == 1:
+			num = append(num, dimNames[i])
+		case n > 1:
+			num = append(num, fmt.Sprintf("%s^%d", dimNames[i], n))
+		case n == -1:
+			den = append(den, dimNames[i])
+		case n < -1:
+			den = append(den, fmt.Sprintf("%s^%d", dimNames[i], -n))
+		}
+	}
+	s := strings.Join(num, "*")
+	if s == "" {
+		s = "1"
+	}
+	if len(den) > 0 {
+		s += "/" + strings.Join(den, "/")
+	}
+	return s
+}
+
+func tokenizeCalc(expr string) ([]string, error) {
+	var tokens []string
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' || runes[j] == '_') {
+				j++
+			}
+			// scientific notation like 1e-3
+			if j < len(runes) && (runes[j] == 'e' || runes[j] == 'E') {
+				k := j + 1
+				if k < len(runes) && (runes[k] == '+' || runes[k] == '-') {
+					k++
+				}
+				if k < len(runes) && unicode.IsDigit(runes[k]) {
+					for k < len(runes) && unicode.IsDigit(runes[k]) {
+						k++
+					}
+					j = k
+				}
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case unicode.IsLetter(r):
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case r == '*' && i+1 < len(runes) && runes[i+1] == '*':
+			tokens = append(tokens, "^")
+			i += 2
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '>':
+			tokens = append(tokens, "->")
+			i += 2
+		case strings.ContainsRune("+-*/%^(),", r):
+			tokens = append(tokens, string(r))
+			i++
+		default:
+			return nil, fmt.Errorf("invalid character '%c'", r)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	return tokens, nil
+}
+
+type calcParser struct {
+	tokens []string
+	pos    int
+
+	// ignore the offset of temperature unit when parse target unit
+	noOffset bool
+}
+
+func (p *calcParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *calcParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *calcParser) parseExpr() (quantity, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return quantity{}, err
+	}
+	for {
+		op := p.peek()
+		if op != "+" && op != "-" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return quantity{}, err
+		}
+		if left.dims != right.dims {
+			return quantity{}, fmt.Errorf(
+				"cannot %s %s and %s", op, dimsString(left.dims), dimsString(right.dims),
+			)
+		}
+		if op == "+" {
+			left.val += right.val
+		} else {
+			left.val -= right.val
+		}
+	}
+}
+
+func (p *calcParser) parseTerm() (quantity, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return quantity{}, err
+	}
+	for {
+		op := p.peek()
+		if op != "*" && op != "/" && op != "%" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return quantity{}, err
+		}
+		switch op {
+		case "*":
+			left.val *= right.val
+			for i := 0; i < numDims; i++ {
+				left.dims[i] += right.dims[i]
+			}
+		case "/":
+			if right.val == 0 {
+				return quantity{}, errors.New("division by zero")
+			}
+			left.val /= right.val
+			for i := 0; i < numDims; i++ {
+				left.dims[i] -= right.dims[i]
+			}
+		case "%":
+			if left.dims != right.dims {
+				return quantity{}, errors.New("modulo with different units")
+			}
+			if right.val == 0 {
+				return quantity{}, errors.New("division by zero")
+			}
+			left.val = math.Mod(left.val, right.val)
+		}
+	}
+}
+
+func (p *calcParser) parseUnary() (quantity, error) {
+	switch p.peek() {
+	case "-":
+		p.next()
+		q, err := p.parseUnary()
+		q.val = -q.val
+		return q, err
+	case "+":
+		p.next()
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+func (p *calcParser) parsePower() (quantity, error) {
+	base, err := p.parsePostfix()
+	if err != nil {
+		return quantity{}, err
+	}
+	if p.peek() != "^" {
+		return base, nil
+	}
+	p.next()
+	exp, err := p.parseUnary()
+	if err != nil {
+		return quantity{}, err
+	}
+	if !exp.isScalar() {
+		return quantity{}, errors.New("exponent must be a scalar")
+	}
+	if !base.isScalar() {
+		if exp.val != math.Trunc(exp.val) {
+			return quantity{}, errors.New("exponent about unit must be an integer")
+		}
+		for i := 0; i < numDims; i++ {
+			base.dims[i] *= int(exp.val)
+		}
+	}
+	base.val = math.Pow(base.val, exp.val)
+	return base, nil
+}
+
+func (p *calcParser) parsePostfix() (quantity, error) {
+	literal := isCalcNumber(p.peek())
+	q, err := p.parsePrimary()
+	if err != nil {
+		return quantity{}, err
+	}
+	// number followed by a unit like "5 km"
+	if !literal {
+		return q, nil
+	}
+	tok := p.peek()
+	unit, ok := calcUnits[tok]
+	if !ok || p.pos+1 < len(p.tokens) && p.tokens[p.pos+1] == "(" {
+		return q, nil
+	}
+	p.next()
+	q.val *= unit.factor
+	if !p.noOffset {
+		q.val += unit.offset
+	}
+	q.dims[unit.dim]++
+	return q, nil
+}
+
+func (p *calcParser) parsePrimary() (quantity, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return quantity{}, errors.New("unexpected end of expression")
+	case tok == "(":
+		q, err := p.parseExpr()
+		if err != nil {
+			return quantity{}, err
+		}
+		if p.next() != ")" {
+			return quantity{}, errors.New("missing \")\"")
+		}
+		return q, nil
+	case isCalcNumber(tok):
+		val, err := strconv.ParseFloat(strings.ReplaceAll(tok, "_", ""), 64)
+		if err != nil {
+			return quantity{}, fmt.Errorf("invalid number \"%s\"", tok)
+		}
+		return quantity{val: val}, nil
+	}
+	if p.peek() == "(" {
+		return p.parseCall(tok)
+	}
+	if val, ok := calcConsts[tok]; ok {
+		return quantity{val: val}, nil
+	}
+	// single unit like "km/h" means 1 unit
+	if unit, ok := calcUnits[tok]; ok {
+		q := quantity{val: unit.factor}
+		q.dims[unit.dim] = 1
+		return q, nil
+	}
+	return quantity{}, fmt.Errorf("unknown identifier \"%s\"", tok)
+}
+
+func isCalcNumber(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	return unicode.IsDigit(rune(tok[0])) || tok[0] == '.'
+}
+
+func (p *calcParser) parseCall(name string) (quantity, error) {
+	p.next() // skip "("
+	var args []quantity
+	for p.peek() != ")" {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return quantity{}, err
+		}
+		args = append(args, arg)
+		if p.peek() == "," {
+			p.next()
+			continue
+		}
+		if p.peek() != ")" {
+			return quantity{}, errors.New("missing \")\"")
+		}
+	}
+	p.next() // skip ")"
+	return callCalcFunc(name, args)
+}
+
+var calcFuncs = map[string]func(float64) float64{
+	"sin": math.Sin, "cos": math.Cos, "tan": math.Tan,
+	"asin": math.Asin, "acos": math.Acos, "atan": math.Atan,
+	"ln": math.Log, "log": math.Log10, "log2": math.Log2, "exp": math.Exp,
+}
+
+func callCalcFunc(name string, args []quantity) (quantity, error) {
+	switch name {
+	case "min", "max", "hypot":
+		if len(args) < 2 {
+			return quantity{}, fmt.Errorf("%s need at least 2 arguments", name)
+		}
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.dims != result.dims {
+				return quantity{}, fmt.Errorf("%s with different units", name)
+			}
+			switch name {
+			case "min":
+				result.val = math.Min(result.val, arg.val)
+			case "max":
+				result.val = math.Max(result.val, arg.val)
+			case "hypot":
+				result.val = math.Hypot(result.val, arg.val)
+			}
+		}
+		return result, nil
+	case "pow":
+		if len(args) != 2 {
+			return quantity{}, errors.New("pow need 2 arguments")
+		}
+		if !args[0].isScalar() || !args[1].isScalar() {
+			return quantity{}, errors.New("pow only accept scalar")
+		}
+		return quantity{val: math.Pow(args[0].val, args[1].val)}, nil
+	}
+	if len(args) != 1 {
+		return quantity{}, fmt.Errorf("%s need 1 argument", name)
+	}
+	arg := args[0]
+	switch name {
+	case "abs":
+		arg.val = math.Abs(arg.val)
+		return arg, nil
+	case "floor":
+		arg.val = math.Floor(arg.val)
+		return arg, nil
+	case "ceil":
+		arg.val = math.Ceil(arg.val)
+		return arg, nil
+	case "round":
+		arg.val = math.Round(arg.val)
+		return arg, nil
+	case "sqrt":
+		for i := 0; i < numDims; i++ {
+			if arg.dims[i]%2 != 0 {
+				return quantity{}, errors.New("sqrt about unit must have even exponent")
+			}
+			arg.dims[i] /= 2
+		}
+		arg.val = math.Sqrt(arg.val)
+		return arg, nil
+	}
+	fn, ok := calcFuncs[name]
+	if !ok {
+		return quantity{}, fmt.Errorf("unknown function \"%s\"", name)
+	}
+	if !arg.isScalar() {
+		return quantity{}, fmt.Errorf("%s only accept scalar", name)
+	}
+	return quantity{val: fn(arg.val)}, nil
+}
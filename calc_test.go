@@ -0,0 +1,49 @@
+package deepbot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculate(t *testing.T) {
+	for _, item := range []struct {
+		expr   string
+		result string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"(1 + 2) * 3", "9"},
+		{"2 ^ 10", "1024"},
+		{"2 ** 3 ** 2", "512"},
+		{"-3 + 5", "2"},
+		{"10 % 4", "2"},
+		{"sqrt(16) + abs(-2)", "6"},
+		{"max(1, 5, 3)", "5"},
+		{"1e3 / 4", "250"},
+		{"5 km + 300 m", "5300 m"},
+		{"5 km + 300 m to km", "5.3 km"},
+		{"1 mi in ft", "5280 ft"},
+		{"30 C to F", "86 F"},
+		{"1 GiB to MB", "1073.741824 MB"},
+		{"100 km / 2 h to km/h", "50 km/h"},
+		{"2 m * 3 m", "6 m^2"},
+	} {
+		result, err := calculate(item.expr)
+		require.NoError(t, err, item.expr)
+		require.Equal(t, item.result, result, item.expr)
+	}
+
+	for _, expr := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 / 0",
+		"5 km + 3 kg",
+		"5 km to kg",
+		"unknown(1)",
+		"os.exit(1)",
+	} {
+		_, err := calculate(expr)
+		require.Error(t, err, expr)
+	}
+}
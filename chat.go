@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/chromedp/chromedp"
 	"github.com/cohesion-org/deepseek-go"
 	"github.com/wdvxdr1123/ZeroBot"
 )
@@ -20,10 +21,26 @@ const maxToolCallLen = 128 * 1024
 const promptToolCall = `
 [外部函数调用指南]
    你可以使用浏览器来访问原先你访问不到的外部资源，具体请使用BrowseURL工具函数。
-   你可以生成并且执行Go语言代码，来访问原先你访问不到的外部资源，具体请使用EvalGo工具函数。
-   请注意如果你只是需要浏览网页，请优先使用BrowseURL，而不是生成相关代码使用EvalGo来访问。
+   你可以生成并且执行Go语言代码，具体请使用EvalGo工具函数，但是程序无法访问网络与启动其他进程。
+   如果你需要浏览网页，请使用BrowseURL，不要生成相关代码使用EvalGo来访问。
+   如果只是需要进行数值计算或者单位换算，请优先使用Calc工具函数。
+   如果需要进行快速计算或者数据转换，可以根据需要选择EvalJS、EvalLua或者EvalGo工具函数。
    不要重复地访问同一个URL，以及不要递归访问网站内容中的出现URL。
    仅当你需要访问实时信息时才应该使用BrowseURL工具函数。
+   如果需要点击链接、翻页、滚动加载或者截图给用户看，请使用BrowserAction工具函数。
+   如果需要把SearchImage搜索到的图片展示给用户，请在回复中使用Markdown图片语法引用图片的link，图片将会被下载并发送给用户。
+   搜索结果会被缓存一段时间，如果查询的是时效性强的内容，请在调用搜索工具函数时设置no_cache参数。
+`
+
+const promptDiagram = `
+[图表输出指南]
+   如果需要展示柱状图或折线图，可以使用chart代码块，内容为JSON格式，例如:
+   {"type":"bar","title":"标题","labels":["A","B"],"datasets":[{"label":"数据","data":[1,2]}]}
+   其中type可选bar或line，每个dataset中data的数量必须与labels一致。
+`
+
+// promptMermaid is only used when the mermaid.min.js is placed at asset.
+const promptMermaid = `   如果需要展示流程图、时序图等图表，可以使用mermaid代码块，它会被渲染为图片。
 `
 
 type chatResp struct {
@@ -38,6 +55,7 @@ func (cr *chatResp) String() string {
 func (bot *DeepBot) onChat(ctx *zero.Ctx) {
 	msg := ctx.MessageString()
 	msg = strings.Replace(msg, "chat ", "", 1)
+	msg = bot.describeImages(ctx, msg)
 	fmt.Println("chat", ctx.Event.GroupID, msg)
 	user := bot.getUser(ctx.Event.UserID)
 
@@ -53,12 +71,13 @@ func (bot *DeepBot) onChat(ctx *zero.Ctx) {
 		return
 	}
 
-	bot.reply(ctx, user, resp.Answer)
+	bot.reply(ctx, user, resp.Answer, false)
 }
 
 func (bot *DeepBot) onChatX(ctx *zero.Ctx) {
 	msg := ctx.MessageString()
 	msg = strings.Replace(msg, "chatx ", "", 1)
+	msg = bot.describeImages(ctx, msg)
 	fmt.Println("chatx", ctx.Event.GroupID, msg)
 	user := bot.getUser(ctx.Event.UserID)
 
@@ -75,12 +94,13 @@ func (bot *DeepBot) onChatX(ctx *zero.Ctx) {
 		return
 	}
 
-	bot.reply(ctx, user, resp.Answer)
+	bot.reply(ctx, user, resp.Answer, false)
 }
 
 func (bot *DeepBot) onReasoner(ctx *zero.Ctx) {
 	msg := ctx.MessageString()
 	msg = strings.Replace(msg, "ai ", "", 1)
+	msg = bot.describeImages(ctx, msg)
 	fmt.Println("ai", ctx.Event.GroupID, msg)
 	user := bot.getUser(ctx.Event.UserID)
 
@@ -94,12 +114,13 @@ func (bot *DeepBot) onReasoner(ctx *zero.Ctx) {
 		return
 	}
 
-	bot.reply(ctx, user, resp.Answer)
+	bot.reply(ctx, user, resp.Answer, false)
 }
 
 func (bot *DeepBot) onReasonerX(ctx *zero.Ctx) {
 	msg := ctx.MessageString()
 	msg = strings.Replace(msg, "aix ", "", 1)
+	msg = bot.describeImages(ctx, msg)
 	fmt.Println("aix", ctx.Event.GroupID, msg)
 	user := bot.getUser(ctx.Event.UserID)
 
@@ -120,27 +141,43 @@ func (bot *DeepBot) onReasonerX(ctx *zero.Ctx) {
 <h3>回复内容</h3>
 <div>%s</div>
 `
-	reasoning := resp.Reasoning
-	if isMarkdown(reasoning) {
+	opts := bot.renderOptions(user)
+	// the reasoning and answer are moderated independently,
+	// only the part that not passed will be dropped
+	reasoning, reasoningOK := bot.moderateText(ctx, resp.Reasoning)
+	answer, answerOK := bot.moderateText(ctx, resp.Answer)
+	if !reasoningOK && !answerOK {
+		return
+	}
+	if !reasoningOK {
+		reasoning = "(已隐藏)"
+	} else if opts.isMarkdown(reasoning) {
 		reasoning = markdownToHTML(reasoning)
 	}
-	answer := resp.Answer
-	if isMarkdown(answer) {
-		answer = markdownToHTML(answer)
+	content := "(已隐藏)"
+	if answerOK {
+		content = answer
+		if opts.isMarkdown(content) {
+			content = markdownToHTML(content)
+		}
 	}
-	output := fmt.Sprintf(tpl, reasoning, answer)
+	output := fmt.Sprintf(tpl, reasoning, content)
 
-	img, err := bot.htmlToImage(output)
+	images, err := bot.htmlToImages(output, opts)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	sendImage(ctx, img)
+	sendImages(ctx, images)
+	if answerOK {
+		bot.sendCodeBlocks(ctx, answer)
+	}
 }
 
 func (bot *DeepBot) onCoder(ctx *zero.Ctx) {
 	msg := ctx.MessageString()
 	msg = strings.Replace(msg, "coder ", "", 1)
+	msg = bot.describeImages(ctx, msg)
 	fmt.Println("coder", ctx.Event.GroupID, msg)
 	user := bot.getUser(ctx.Event.UserID)
 
@@ -156,12 +193,13 @@ func (bot *DeepBot) onCoder(ctx *zero.Ctx) {
 		return
 	}
 
-	bot.reply(ctx, user, resp.Answer)
+	bot.reply(ctx, user, resp.Answer, false)
 }
 
 func (bot *DeepBot) onCoderX(ctx *zero.Ctx) {
 	msg := ctx.MessageString()
 	msg = strings.Replace(msg, "coderx ", "", 1)
+	msg = bot.describeImages(ctx, msg)
 	fmt.Println("coderx", ctx.Event.GroupID, msg)
 	user := bot.getUser(ctx.Event.UserID)
 
@@ -178,7 +216,7 @@ func (bot *DeepBot) onCoderX(ctx *zero.Ctx) {
 		return
 	}
 
-	bot.reply(ctx, user, resp.Answer)
+	bot.reply(ctx, user, resp.Answer, false)
 }
 
 func (bot *DeepBot) onMessage(ctx *zero.Ctx) {
@@ -186,8 +224,14 @@ func (bot *DeepBot) onMessage(ctx *zero.Ctx) {
 		return
 	}
 
-	msg := ctx.MessageString()
 	user := bot.getUser(ctx.Event.UserID)
+	msg := ctx.MessageString()
+	msg, fromVoice := bot.transcribeVoice(ctx, msg)
+	if msg == "" {
+		bot.sendText(ctx, "无法识别语音内容")
+		return
+	}
+	msg = bot.describeImages(ctx, msg)
 	model := user.getModel()
 
 	req := &ChatRequest{
@@ -210,7 +254,7 @@ func (bot *DeepBot) onMessage(ctx *zero.Ctx) {
 		return
 	}
 
-	bot.reply(ctx, user, resp.Answer)
+	bot.reply(ctx, user, resp.Answer, fromVoice)
 }
 
 func (bot *DeepBot) onGetModel(ctx *zero.Ctx) {
@@ -325,6 +369,8 @@ func (bot *DeepBot) chat(req *ChatRequest, user *user, msg string) (*chatResp, e
 		}
 		break
 	}
+	// drop the images from tool call, otherwise they will be sent with the next answer
+	user.takeImages()
 	return nil, err
 }
 
@@ -350,6 +396,12 @@ func (bot *DeepBot) tryChat(req *ChatRequest, user *user, msg string) (*chatResp
 	if len(req.Tools) > 0 && req.Model != deepseek.DeepSeekReasoner {
 		character += "\n\n" + promptToolCall
 	}
+	if bot.config.Renderer.Enabled {
+		character += "\n\n" + promptDiagram
+		if hasMermaid {
+			character += promptMermaid
+		}
+	}
 	if character != "" {
 		messages = append(messages, ChatMessage{
 			Role:    deepseek.ChatMessageRoleSystem,
@@ -385,9 +437,13 @@ func (bot *DeepBot) tryChat(req *ChatRequest, user *user, msg string) (*chatResp
 	if err != nil {
 		return nil, fmt.Errorf("failed to create chat completion: %s", err)
 	}
-	// reset usage counter before process tool calls
-	resetToolLimit(user)
+	// reset usage counter and the images of the failed
+	// attempt before process tool calls
+	bot.resetToolLimit(user)
+	resetSearchImages(user)
+	user.takeImages()
 	resp, err = bot.doToolCalls(req, resp, user)
+	closeBrowserSession(user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process tool call: %s", err)
 	}
@@ -471,7 +527,7 @@ func (bot *DeepBot) doToolCalls(req *ChatRequest, resp *ChatResponse, user *user
 		Temperature: req.Temperature,
 		TopP:        req.TopP,
 		MaxTokens:   8192,
-		Tools:       updateTools(user, req.Tools),
+		Tools:       bot.updateTools(user, req.Tools),
 	}
 	resp, err := bot.client.CreateChatCompletion(context.Background(), toolReq)
 	if err != nil {
@@ -511,8 +567,16 @@ func (bot *DeepBot) doToolCall(toolCall deepseek.ToolCall, user *user) (string,
 		answer, err = bot.onSearchImage(decoder, user)
 	case fnBrowseURL:
 		answer, err = bot.onBrowseURL(decoder, user)
+	case fnBrowser:
+		answer, err = bot.onBrowserAction(decoder, user)
 	case fnEvalGo:
 		answer, err = bot.onEvalGo(decoder, user)
+	case fnEvalJS:
+		answer, err = bot.onEvalJS(decoder, user)
+	case fnEvalLua:
+		answer, err = bot.onEvalLua(decoder, user)
+	case fnCalc:
+		answer, err = bot.onCalc(decoder, user)
 	default:
 		return "", fmt.Errorf("unknown function: %s", fnName)
 	}
@@ -520,7 +584,7 @@ func (bot *DeepBot) doToolCall(toolCall deepseek.ToolCall, user *user) (string,
 }
 
 func (bot *DeepBot) onGetTime(user *user) (string, error) {
-	err := checkToolLimit(user, fnGetTime)
+	err := bot.checkToolLimit(user, fnGetTime)
 	if err != nil {
 		return "", err
 	}
@@ -528,29 +592,24 @@ func (bot *DeepBot) onGetTime(user *user) (string, error) {
 }
 
 func (bot *DeepBot) onSearchWeb(decoder *json.Decoder, user *user) (string, error) {
-	err := checkToolLimit(user, fnSearchWeb)
+	err := bot.checkToolLimit(user, fnSearchWeb)
 	if err != nil {
 		return "", err
 	}
 
 	args := struct {
 		Keyword string `json:"keyword"`
+		NoCache bool   `json:"no_cache"`
 	}{}
 	err = decoder.Decode(&args)
 	if err != nil {
 		return "", err
 	}
 
-	config := bot.config.SearchAPI
-	timeout := time.Duration(config.Timeout) * time.Millisecond
+	timeout := time.Duration(bot.config.SearchAPI.Timeout) * time.Millisecond
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	cfg := &searchCfg{
-		EngineID: config.EngineID,
-		APIKey:   config.APIKey,
-		ProxyURL: config.ProxyURL,
-	}
-	output, err := onSearchWeb(ctx, cfg, args.Keyword)
+	output, err := onSearchWeb(ctx, bot.searcher, args.Keyword, args.NoCache)
 	if err != nil {
 		return "failed to search web: " + err.Error(), nil
 	}
@@ -558,7 +617,7 @@ func (bot *DeepBot) onSearchWeb(decoder *json.Decoder, user *user) (string, erro
 }
 
 func (bot *DeepBot) onSearchImage(decoder *json.Decoder, user *user) (string, error) {
-	err := checkToolLimit(user, fnSearchImage)
+	err := bot.checkToolLimit(user, fnSearchImage)
 	if err != nil {
 		return "", err
 	}
@@ -566,30 +625,26 @@ func (bot *DeepBot) onSearchImage(decoder *json.Decoder, user *user) (string, er
 	args := struct {
 		Keyword string `json:"keyword"`
 		Size    string `json:"size"`
+		NoCache bool   `json:"no_cache"`
 	}{}
 	err = decoder.Decode(&args)
 	if err != nil {
 		return "", err
 	}
 
-	config := bot.config.SearchAPI
-	timeout := time.Duration(config.Timeout) * time.Millisecond
+	timeout := time.Duration(bot.config.SearchAPI.Timeout) * time.Millisecond
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	cfg := &searchCfg{
-		EngineID: config.EngineID,
-		APIKey:   config.APIKey,
-		ProxyURL: config.ProxyURL,
-	}
-	output, err := onSearchImage(ctx, cfg, args.Keyword, args.Size)
+	output, results, err := onSearchImage(ctx, bot.searcher, args.Keyword, args.Size, args.NoCache)
 	if err != nil {
 		return "failed to search image: " + err.Error(), nil
 	}
+	addSearchImages(user, results)
 	return output, nil
 }
 
 func (bot *DeepBot) onBrowseURL(decoder *json.Decoder, user *user) (string, error) {
-	err := checkToolLimit(user, fnBrowseURL)
+	err := bot.checkToolLimit(user, fnBrowseURL)
 	if err != nil {
 		return "", err
 	}
@@ -605,16 +660,87 @@ func (bot *DeepBot) onBrowseURL(decoder *json.Decoder, user *user) (string, erro
 	timeout := time.Duration(bot.config.Browser.Timeout) * time.Millisecond
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	options := bot.getChromedpOptions()
-	output, err := onBrowseURL(ctx, options, args.URL)
+	output, err := onBrowseURL(ctx, bot.browser, bot.policy, args.URL)
 	if err != nil {
 		return "Chromedp Error: " + err.Error(), nil
 	}
 	return output, nil
 }
 
+func (bot *DeepBot) onBrowserAction(decoder *json.Decoder, user *user) (string, error) {
+	err := bot.checkToolLimit(user, fnBrowser)
+	if err != nil {
+		return "", err
+	}
+
+	args := browserAction{}
+	err = decoder.Decode(&args)
+	if err != nil {
+		return "", err
+	}
+
+	session, err := bot.getBrowserSession(user)
+	if err != nil {
+		return "Chromedp Error: " + err.Error(), nil
+	}
+	output, image, err := doBrowserAction(session, &args)
+	if err != nil {
+		return "Chromedp Error: " + err.Error(), nil
+	}
+	if image != nil {
+		user.addImage(image)
+	}
+	return output, nil
+}
+
+// getBrowserSession is used to get the tab that opened in current round.
+func (bot *DeepBot) getBrowserSession(user *user) (*browserSession, error) {
+	session, ok := user.getContext("BrowserSession").(*browserSession)
+	if ok {
+		return session, nil
+	}
+	timeout := time.Duration(bot.config.Browser.Timeout) * time.Millisecond
+	budget := time.Duration(bot.toolList[fnBrowser].Limit)
+	// the tab will be closed after this round or reach the total timeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout*budget)
+	tabCtx, release, err := bot.browser.NewTab(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	err = bot.policy.Guard(tabCtx)
+	if err == nil {
+		err = chromedp.Run(tabCtx, chromedp.EmulateViewport(1280, 800))
+	}
+	if err != nil {
+		release()
+		cancel()
+		return nil, err
+	}
+	session = &browserSession{
+		ctx:     tabCtx,
+		timeout: timeout,
+		policy:  bot.policy,
+		release: func() {
+			release()
+			cancel()
+		},
+	}
+	user.setContext("BrowserSession", session)
+	return session, nil
+}
+
+func closeBrowserSession(user *user) {
+	session, ok := user.getContext("BrowserSession").(*browserSession)
+	if !ok {
+		return
+	}
+	session.Close()
+	user.setContext("BrowserSession", nil)
+}
+
 func (bot *DeepBot) onEvalGo(decoder *json.Decoder, user *user) (string, error) {
-	err := checkToolLimit(user, fnEvalGo)
+	err := bot.checkToolLimit(user, fnEvalGo)
 	if err != nil {
 		return "", err
 	}
@@ -628,24 +754,91 @@ func (bot *DeepBot) onEvalGo(decoder *json.Decoder, user *user) (string, error)
 	}
 
 	timeout := time.Duration(bot.config.EvalGo.Timeout) * time.Millisecond
+	return doEval(onEvalGo, timeout, args.Src, "Go Error: "), nil
+}
+
+func (bot *DeepBot) onEvalJS(decoder *json.Decoder, user *user) (string, error) {
+	err := bot.checkToolLimit(user, fnEvalJS)
+	if err != nil {
+		return "", err
+	}
+
+	args := struct {
+		Src string `json:"src"`
+	}{}
+	err = decoder.Decode(&args)
+	if err != nil {
+		return "", err
+	}
+
+	timeout := time.Duration(bot.config.EvalJS.Timeout) * time.Millisecond
+	return doEval(onEvalJS, timeout, args.Src, "JS Error: "), nil
+}
+
+func (bot *DeepBot) onEvalLua(decoder *json.Decoder, user *user) (string, error) {
+	err := bot.checkToolLimit(user, fnEvalLua)
+	if err != nil {
+		return "", err
+	}
+
+	args := struct {
+		Src string `json:"src"`
+	}{}
+	err = decoder.Decode(&args)
+	if err != nil {
+		return "", err
+	}
+
+	timeout := time.Duration(bot.config.EvalLua.Timeout) * time.Millisecond
+	return doEval(onEvalLua, timeout, args.Src, "Lua Error: "), nil
+}
+
+func (bot *DeepBot) onCalc(decoder *json.Decoder, user *user) (string, error) {
+	err := bot.checkToolLimit(user, fnCalc)
+	if err != nil {
+		return "", err
+	}
+
+	args := struct {
+		Expr string `json:"expr"`
+	}{}
+	err = decoder.Decode(&args)
+	if err != nil {
+		return "", err
+	}
+
+	timeout := time.Duration(bot.config.Calc.Timeout) * time.Millisecond
+	return doEval(onCalc, timeout, args.Expr, "Calc Error: "), nil
+}
+
+// doEval is used to run the interpreter with the same sandbox limits,
+// the error will be returned to model with the prefix.
+func doEval(eval evaluator, timeout time.Duration, src, prefix string) string {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	output, err := onEvalGo(ctx, args.Src)
+	output, err := eval(ctx, src)
 	if err != nil {
-		return "Go Error: " + err.Error(), nil
+		return prefix + err.Error()
 	}
-	return output, nil
+	return output
+}
+
+func (tool *toolFunc) usageKey() string {
+	if tool.Group != "" {
+		return "Usage_" + tool.Group
+	}
+	return "Usage_" + tool.Name
 }
 
-func resetToolLimit(user *user) {
-	for _, tool := range toolList {
-		user.setContext("Usage_"+tool.Name, 0)
+func (bot *DeepBot) resetToolLimit(user *user) {
+	for _, tool := range bot.toolList {
+		user.setContext(tool.usageKey(), 0)
 	}
 }
 
-func checkToolLimit(user *user, name string) error {
-	tool := toolList[name]
-	key := "Usage_" + tool.Name
+func (bot *DeepBot) checkToolLimit(user *user, name string) error {
+	tool := bot.toolList[name]
+	key := tool.usageKey()
 	usage := user.getContext(key).(int)
 	if usage >= tool.Limit {
 		return fmt.Errorf("too many calls about %s", tool.Name)
@@ -654,9 +847,9 @@ func checkToolLimit(user *user, name string) error {
 	return nil
 }
 
-func reachToolLimit(user *user, name string) bool {
-	tool := toolList[name]
-	key := "Usage_" + tool.Name
+func (bot *DeepBot) reachToolLimit(user *user, name string) bool {
+	tool := bot.toolList[name]
+	key := tool.usageKey()
 	usage := user.getContext(key).(int)
 	if usage >= tool.Limit {
 		return true
@@ -664,10 +857,10 @@ func reachToolLimit(user *user, name string) bool {
 	return false
 }
 
-func updateTools(user *user, tools []deepseek.Tool) []deepseek.Tool {
+func (bot *DeepBot) updateTools(user *user, tools []deepseek.Tool) []deepseek.Tool {
 	var result []deepseek.Tool
 	for _, tool := range tools {
-		if !reachToolLimit(user, tool.Function.Name) {
+		if !bot.reachToolLimit(user, tool.Function.Name) {
 			result = append(result, tool)
 		}
 	}
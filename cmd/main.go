@@ -5,6 +5,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/For-ACGN/DeepBot"
 	"github.com/pelletier/go-toml/v2"
@@ -29,6 +31,13 @@ func main() {
 	checkError(err)
 
 	bot := deepbot.NewDeepBot(&config)
+	go func() {
+		signalCh := make(chan os.Signal, 1)
+		signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+		<-signalCh
+		bot.Close()
+		os.Exit(0)
+	}()
 	bot.Run()
 }
 
@@ -0,0 +1,120 @@
+package deepbot
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const (
+	defaultCodeBlockCount = 5
+	defaultCodeFileSize   = 2000
+)
+
+var codeFileExtensions = map[string]string{
+	"go":         "go",
+	"golang":     "go",
+	"js":         "js",
+	"javascript": "js",
+	"ts":         "ts",
+	"typescript": "ts",
+	"py":         "py",
+	"python":     "py",
+	"lua":        "lua",
+	"c":          "c",
+	"cpp":        "cpp",
+	"c++":        "cpp",
+	"cs":         "cs",
+	"csharp":     "cs",
+	"java":       "java",
+	"rust":       "rs",
+	"rs":         "rs",
+	"sh":         "sh",
+	"bash":       "sh",
+	"shell":      "sh",
+	"powershell": "ps1",
+	"sql":        "sql",
+	"html":       "html",
+	"css":        "css",
+	"json":       "json",
+	"yaml":       "yaml",
+	"toml":       "toml",
+	"xml":        "xml",
+}
+
+// sendCodeBlocks is used to send the fenced code blocks in the rendered
+// answer as text messages that can be copied, the long code block will be
+// uploaded as group file, and it will be sent as text if upload failed.
+// The mermaid and chart code blocks are skipped since they are rendered.
+func (bot *DeepBot) sendCodeBlocks(ctx *zero.Ctx, msg string) {
+	cfg := bot.config.CodeBlock
+	if !cfg.Enabled {
+		return
+	}
+	blocks := slices.DeleteFunc(extractCodeBlocks(msg), func(block *codeBlock) bool {
+		return block.Lang == "mermaid" || block.Lang == "chart"
+	})
+	maxCount := cfg.MaxCount
+	if maxCount < 1 {
+		maxCount = defaultCodeBlockCount
+	}
+	if len(blocks) > maxCount {
+		blocks = blocks[:maxCount]
+	}
+	fileSize := cfg.FileSize
+	if fileSize < 1 {
+		fileSize = defaultCodeFileSize
+	}
+	for i, block := range blocks {
+		if len(block.Code) > fileSize && ctx.Event.GroupID != 0 {
+			err := bot.uploadCodeFile(ctx, block, codeFileName(block, i+1))
+			if err == nil {
+				continue
+			}
+			log.Println("[warning] failed to upload code file:", err)
+		}
+		for _, chunk := range splitLongChunk(block.Code, defaultForwardChunkSize) {
+			time.Sleep(time.Duration(200+rand.IntN(500)) * time.Millisecond)
+			ctx.Send(message.Text(chunk))
+		}
+	}
+}
+
+// uploadCodeFile is used to write the code to a temporary file and upload
+// it, the OneBot implementation must can access the data directory.
+func (bot *DeepBot) uploadCodeFile(ctx *zero.Ctx, block *codeBlock, name string) error {
+	dir := fmt.Sprintf("data/upload/%d", rand.Uint())
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path, err := filepath.Abs(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, []byte(block.Code+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	resp := ctx.UploadGroupFile(ctx.Event.GroupID, path, name, "")
+	if resp.RetCode != 0 {
+		return fmt.Errorf("%s %s", resp.Msg, resp.Wording)
+	}
+	return nil
+}
+
+func codeFileName(block *codeBlock, index int) string {
+	ext, ok := codeFileExtensions[block.Lang]
+	if !ok {
+		ext = "txt"
+	}
+	return fmt.Sprintf("code_%s_%d.%s", time.Now().Format("20060102_150405"), index, ext)
+}
@@ -0,0 +1,168 @@
+package deepbot
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+// testCaller is used to record the actions that called by the bot.
+type testCaller struct {
+	mu       sync.Mutex
+	requests []zero.APIRequest
+
+	uploadCode int64
+}
+
+func (c *testCaller) CallAPI(req zero.APIRequest) (zero.APIResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, req)
+	if req.Action == "upload_group_file" {
+		return zero.APIResponse{RetCode: c.uploadCode, Msg: "FAILED"}, nil
+	}
+	return zero.APIResponse{}, nil
+}
+
+func (c *testCaller) actions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	actions := make([]string, len(c.requests))
+	for i, req := range c.requests {
+		actions[i] = req.Action
+	}
+	return actions
+}
+
+// texts is used to get the text of the sent messages.
+func (c *testCaller) texts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var texts []string
+	for _, req := range c.requests {
+		msg, ok := req.Params["message"].(message.Segment)
+		if ok {
+			texts = append(texts, msg.Data["text"])
+		}
+	}
+	return texts
+}
+
+func (c *testCaller) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = nil
+}
+
+func newTestCtx(caller *testCaller, event *zero.Event) *zero.Ctx {
+	zero.APICallers.Store(event.SelfID, caller)
+	ctx := zero.GetBot(event.SelfID)
+	ctx.Event = event
+	return ctx
+}
+
+func TestCodeFileName(t *testing.T) {
+	re := regexp.MustCompile(`^code_\d{8}_\d{6}_2\.(\w+)$`)
+	for lang, ext := range map[string]string{
+		"go":     "go",
+		"python": "py",
+		"c++":    "cpp",
+		"":       "txt",
+		"brainf": "txt",
+	} {
+		name := codeFileName(&codeBlock{Lang: lang}, 2)
+		match := re.FindStringSubmatch(name)
+		require.Len(t, match, 2, name)
+		require.Equal(t, ext, match[1])
+	}
+}
+
+func TestSendCodeBlocks(t *testing.T) {
+	config := new(Config)
+	bot := &DeepBot{config: config}
+	caller := new(testCaller)
+	ctx := newTestCtx(caller, &zero.Event{SelfID: 10001, UserID: 123})
+
+	msg := "```go\nfmt.Println(1)\n```"
+	bot.sendCodeBlocks(ctx, msg)
+	require.Empty(t, caller.actions())
+
+	config.CodeBlock.Enabled = true
+	config.CodeBlock.FileSize = 100
+
+	t.Run("common", func(t *testing.T) {
+		defer caller.reset()
+
+		bot.sendCodeBlocks(ctx, "no code block")
+		// inline code in help document is not code block
+		bot.sendCodeBlocks(ctx, helpMD)
+		require.Empty(t, caller.actions())
+
+		bot.sendCodeBlocks(ctx, "text\n\n"+msg+"\n\n```\necho 1\n```")
+		require.Equal(t, []string{"send_private_msg", "send_private_msg"}, caller.actions())
+		require.Equal(t, []string{"fmt.Println(1)", "echo 1"}, caller.texts())
+	})
+
+	t.Run("skip diagram", func(t *testing.T) {
+		defer caller.reset()
+
+		diagram := "```mermaid\ngraph TD\n```\n```chart\n{}\n```\n"
+		bot.sendCodeBlocks(ctx, diagram+msg)
+		require.Equal(t, []string{"fmt.Println(1)"}, caller.texts())
+	})
+
+	long := "```go\n" + strings.Repeat("fmt.Println(1)\n", 10) + "```"
+	// remove the empty directories that created by upload
+	defer func() {
+		_ = os.Remove("data/upload")
+		_ = os.Remove("data")
+	}()
+
+	t.Run("upload long code", func(t *testing.T) {
+		defer caller.reset()
+
+		ctx := newTestCtx(caller, &zero.Event{SelfID: 10001, UserID: 123, GroupID: 456})
+		bot.sendCodeBlocks(ctx, long)
+		require.Equal(t, []string{"upload_group_file"}, caller.actions())
+		req := caller.requests[0]
+		require.Equal(t, int64(456), req.Params["group_id"])
+		require.Regexp(t, `^code_\d{8}_\d{6}_1\.go$`, req.Params["name"])
+	})
+
+	t.Run("failed to upload", func(t *testing.T) {
+		caller.uploadCode = 100
+		defer func() {
+			caller.uploadCode = 0
+			caller.reset()
+		}()
+
+		ctx := newTestCtx(caller, &zero.Event{SelfID: 10001, UserID: 123, GroupID: 456})
+		bot.sendCodeBlocks(ctx, long)
+		require.Equal(t, []string{"upload_group_file", "send_group_msg"}, caller.actions())
+		require.Equal(t, []string{strings.TrimSuffix(long[6:len(long)-3], "\n")}, caller.texts())
+	})
+
+	t.Run("long code in private", func(t *testing.T) {
+		defer caller.reset()
+
+		bot.sendCodeBlocks(ctx, long)
+		require.Equal(t, []string{"send_private_msg"}, caller.actions())
+	})
+
+	t.Run("max count", func(t *testing.T) {
+		config.CodeBlock.MaxCount = 2
+		defer func() {
+			config.CodeBlock.MaxCount = 0
+			caller.reset()
+		}()
+
+		bot.sendCodeBlocks(ctx, "```\na\n```\n```\nb\n```\n```\nc\n```")
+		require.Equal(t, []string{"a", "b"}, caller.texts())
+	})
+}
@@ -0,0 +1,416 @@
+package deepbot
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	comfyUIPollPeriod     = time.Second
+	comfyUIRequestTimeout = 60 * time.Second
+	comfyUIMaxResponse    = 64 * 1024 * 1024
+)
+
+// comfyUI is the draw backend about ComfyUI, the workflow file must be
+// exported with the API format, and the values in workflow like "{{prompt}}"
+// will be replaced to the arguments, the default model is the first one in
+// the supported models of backend. The supported placeholders are:
+// prompt, negative_prompt, seed, steps, width, height, cfg, sampler,
+// scheduler, model and batch_size.
+//
+// The job is cancelled by the context of Txt2Img, the prompt will be deleted
+// from the queue or interrupted if it is running, so Interrupt does nothing
+// for not affect the prompts of other users.
+type comfyUI struct {
+	URL      string
+	Workflow string
+	Model    string // default checkpoint
+
+	// the job will be failed if the prompt is lost for this time
+	StallTime time.Duration
+
+	clientID string
+	client   *http.Client
+}
+
+func newComfyUI(URL, workflow, model string, stall time.Duration) *comfyUI {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	if stall <= 0 {
+		stall = defaultDrawStallTime
+	}
+	return &comfyUI{
+		URL:       URL,
+		Workflow:  workflow,
+		Model:     model,
+		StallTime: stall,
+		clientID:  hex.EncodeToString(id),
+		client:    &http.Client{Timeout: comfyUIRequestTimeout},
+	}
+}
+
+func (cu *comfyUI) Txt2Img(ctx context.Context, arg *txt2Image) ([][]byte, *sdImageInfo, error) {
+	seed := arg.Seed
+	if seed < 0 {
+		// ComfyUI not support random seed, so generate it here
+		seed = mrand.Int64N(1 << 32)
+	}
+	model := ""
+	if arg.OverrideSettings != nil {
+		model, _ = arg.OverrideSettings["sd_model_checkpoint"].(string)
+	}
+	if model == "" {
+		model = cu.Model
+	}
+	sampler, scheduler := arg.SamplerName, arg.Scheduler
+	if sampler == "" {
+		sampler = "euler"
+	}
+	if scheduler == "" {
+		scheduler = "normal"
+	}
+	values := map[string]any{
+		"prompt":          arg.Prompt,
+		"negative_prompt": arg.NegPrompt,
+		"seed":            seed,
+		"steps":           arg.Steps,
+		"width":           arg.Width,
+		"height":          arg.Height,
+		"cfg":             arg.CFGScale,
+		"sampler":         sampler,
+		"scheduler":       scheduler,
+		"model":           model,
+		"batch_size":      max(arg.BatchSize, 1),
+	}
+	workflow, err := cu.loadWorkflow(values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body := map[string]any{
+		"prompt":    workflow,
+		"client_id": cu.clientID,
+	}
+	var result struct {
+		PromptID string `json:"prompt_id"`
+	}
+	err = cu.call(ctx, http.MethodPost, "/prompt", body, &result)
+	if err != nil {
+		return nil, nil, err
+	}
+	if result.PromptID == "" {
+		return nil, nil, errors.New("receive empty prompt id")
+	}
+	images, err := cu.waitImages(ctx, result.PromptID)
+	if err != nil {
+		if ctx.Err() != nil {
+			cu.cancelPrompt(result.PromptID)
+		}
+		return nil, nil, err
+	}
+	info := sdImageInfo{
+		Seed:      seed,
+		ModelName: model,
+	}
+	return images, &info, nil
+}
+
+// loadWorkflow is used to read workflow file and replace the placeholders.
+func (cu *comfyUI) loadWorkflow(values map[string]any) (map[string]any, error) {
+	data, err := os.ReadFile(cu.Workflow)
+	if err != nil {
+		return nil, err
+	}
+	var workflow map[string]any
+	err = json.Unmarshal(data, &workflow)
+	if err != nil {
+		return nil, err
+	}
+	return replacePlaceholders(workflow, values).(map[string]any), nil
+}
+
+func replacePlaceholders(node any, values map[string]any) any {
+	switch n := node.(type) {
+	case map[string]any:
+		for key, value := range n {
+			n[key] = replacePlaceholders(value, values)
+		}
+		return n
+	case []any:
+		for i, value := range n {
+			n[i] = replacePlaceholders(value, values)
+		}
+		return n
+	case string:
+		// keep the type if the whole string is a placeholder
+		name, ok := strings.CutPrefix(n, "{{")
+		if ok {
+			name, ok = strings.CutSuffix(name, "}}")
+		}
+		if ok {
+			if value, exist := values[name]; exist {
+				return value
+			}
+		}
+		for name, value := range values {
+			n = strings.ReplaceAll(n, "{{"+name+"}}", fmt.Sprint(value))
+		}
+		return n
+	default:
+		return node
+	}
+}
+
+// comfyUIQueue is the response about /queue, each item is an array
+// that the second element is the prompt id.
+type comfyUIQueue struct {
+	Running [][]any `json:"queue_running"`
+	Pending [][]any `json:"queue_pending"`
+}
+
+func (q *comfyUIQueue) isRunning(promptID string) bool {
+	return containsPrompt(q.Running, promptID)
+}
+
+func (q *comfyUIQueue) contains(promptID string) bool {
+	return containsPrompt(q.Running, promptID) || containsPrompt(q.Pending, promptID)
+}
+
+func containsPrompt(items [][]any, promptID string) bool {
+	for _, item := range items {
+		if len(item) > 1 && item[1] == promptID {
+			return true
+		}
+	}
+	return false
+}
+
+// comfyUIHistory is the item in the response about /history.
+type comfyUIHistory struct {
+	Outputs map[string]struct {
+		Images []*struct {
+			Filename  string `json:"filename"`
+			Subfolder string `json:"subfolder"`
+			Type      string `json:"type"`
+		} `json:"images"`
+	} `json:"outputs"`
+	Status struct {
+		StatusStr string `json:"status_str"`
+		Completed bool   `json:"completed"`
+	} `json:"status"`
+}
+
+// cancelPrompt is used to delete the prompt from the queue, if it is
+// running, only interrupt it when it is still the current prompt.
+func (cu *comfyUI) cancelPrompt(promptID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), comfyUIRequestTimeout)
+	defer cancel()
+	var queue comfyUIQueue
+	err := cu.call(ctx, http.MethodGet, "/queue", nil, &queue)
+	if err != nil {
+		log.Println("failed to get ComfyUI queue:", err)
+		return
+	}
+	var result any
+	if queue.isRunning(promptID) {
+		err = cu.call(ctx, http.MethodPost, "/interrupt", map[string]any{"prompt_id": promptID}, &result)
+		if err != nil {
+			log.Println("failed to interrupt ComfyUI prompt:", err)
+		}
+		return
+	}
+	err = cu.call(ctx, http.MethodPost, "/queue", map[string]any{"delete": []string{promptID}}, &result)
+	if err != nil {
+		log.Println("failed to delete ComfyUI prompt:", err)
+	}
+}
+
+// waitImages is used to poll the history until the prompt is finished. The
+// progress of ComfyUI is not monitored by the draw queue, so the job will be
+// failed if the prompt is not found in the history and queue for the stall
+// time, like ComfyUI is restarted, the transient request error is ignored
+// until the backend can not be accessed for the stall time.
+func (cu *comfyUI) waitImages(ctx context.Context, promptID string) ([][]byte, error) {
+	ticker := time.NewTicker(comfyUIPollPeriod)
+	defer ticker.Stop()
+	lastSeen := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		item, err := cu.getHistory(ctx, promptID)
+		if err == nil && item == nil {
+			var queue comfyUIQueue
+			err = cu.call(ctx, http.MethodGet, "/queue", nil, &queue)
+			if err == nil && queue.contains(promptID) {
+				lastSeen = time.Now()
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if time.Since(lastSeen) > cu.StallTime {
+				return nil, fmt.Errorf("failed to get prompt status: %s", err)
+			}
+			log.Println("failed to get ComfyUI prompt status:", err)
+			continue
+		}
+		if item == nil {
+			if time.Since(lastSeen) > cu.StallTime {
+				return nil, errors.New("prompt is not found in ComfyUI")
+			}
+			continue
+		}
+		lastSeen = time.Now()
+		if item.Status.StatusStr == "error" {
+			return nil, errors.New("failed to execute workflow")
+		}
+		if !item.Status.Completed {
+			continue
+		}
+		var images [][]byte
+		for _, output := range item.Outputs {
+			for _, img := range output.Images {
+				// skip the temp images like preview
+				if img.Type != "output" {
+					continue
+				}
+				data, err := cu.viewImage(ctx, img.Filename, img.Subfolder, img.Type)
+				if err != nil {
+					return nil, err
+				}
+				images = append(images, data)
+			}
+		}
+		if len(images) == 0 {
+			return nil, errors.New("receive empty image")
+		}
+		return images, nil
+	}
+}
+
+// getHistory is used to get the history about prompt, it will
+// return nil if the prompt is not finished or not exist.
+func (cu *comfyUI) getHistory(ctx context.Context, promptID string) (*comfyUIHistory, error) {
+	var history map[string]*comfyUIHistory
+	err := cu.call(ctx, http.MethodGet, "/history/"+promptID, nil, &history)
+	if err != nil {
+		return nil, err
+	}
+	return history[promptID], nil
+}
+
+func (cu *comfyUI) viewImage(ctx context.Context, filename, subfolder, typ string) ([]byte, error) {
+	query := url.Values{}
+	query.Set("filename", filename)
+	query.Set("subfolder", subfolder)
+	query.Set("type", typ)
+	URL, err := url.JoinPath(cu.URL, "/view")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, URL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cu.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return readComfyUIResponse(resp.Body)
+}
+
+func (cu *comfyUI) Img2Img(context.Context, *img2Image) ([]byte, error) {
+	return nil, errDrawNotSupported
+}
+
+func (cu *comfyUI) Upscale(context.Context, *upscaleImage) ([]byte, error) {
+	return nil, errDrawNotSupported
+}
+
+func (cu *comfyUI) Progress(context.Context) (*sdProgress, error) {
+	return nil, errDrawNotSupported
+}
+
+// Interrupt is not used, the prompt is cancelled with the context of Txt2Img.
+func (cu *comfyUI) Interrupt(context.Context) error {
+	return nil
+}
+
+func (cu *comfyUI) Ping(ctx context.Context) error {
+	var result any
+	return cu.call(ctx, http.MethodGet, "/system_stats", nil, &result)
+}
+
+func (cu *comfyUI) call(ctx context.Context, method, path string, arg, result any) error {
+	URL, err := url.JoinPath(cu.URL, path)
+	if err != nil {
+		return err
+	}
+	var body io.Reader
+	if arg != nil {
+		data, err := jsonEncode(arg)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, URL, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := cu.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := readComfyUIResponse(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(data) > 256 {
+			data = data[:256]
+		}
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, data)
+	}
+	// the response of interrupt is empty
+	if len(data) == 0 {
+		return nil
+	}
+	return jsonDecode(data, result)
+}
+
+func readComfyUIResponse(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, comfyUIMaxResponse+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > comfyUIMaxResponse {
+		return nil, fmt.Errorf("response size is larger than %d bytes", comfyUIMaxResponse)
+	}
+	return data, nil
+}
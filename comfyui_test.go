@@ -0,0 +1,279 @@
+package deepbot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testComfyWorkflow = `{
+  "3": {
+    "class_type": "KSampler",
+    "inputs": {"seed": "{{seed}}", "steps": "{{steps}}", "cfg": "{{cfg}}", "sampler_name": "euler"}
+  },
+  "6": {
+    "class_type": "CLIPTextEncode",
+    "inputs": {"text": "masterpiece, {{prompt}}"}
+  },
+  "5": {
+    "class_type": "EmptyLatentImage",
+    "inputs": {"width": "{{width}}", "height": "{{height}}", "batch_size": "{{batch_size}}"}
+  }
+}`
+
+func TestReplacePlaceholders(t *testing.T) {
+	var workflow map[string]any
+	err := json.Unmarshal([]byte(testComfyWorkflow), &workflow)
+	require.NoError(t, err)
+	values := map[string]any{
+		"prompt":     "girl",
+		"seed":       int64(42),
+		"steps":      20,
+		"cfg":        7.5,
+		"width":      512,
+		"height":     768,
+		"batch_size": 1,
+	}
+	output := replacePlaceholders(workflow, values).(map[string]any)
+	inputs := output["3"].(map[string]any)["inputs"].(map[string]any)
+	require.Equal(t, int64(42), inputs["seed"])
+	require.Equal(t, 20, inputs["steps"])
+	require.Equal(t, 7.5, inputs["cfg"])
+	require.Equal(t, "euler", inputs["sampler_name"])
+	inputs = output["6"].(map[string]any)["inputs"].(map[string]any)
+	require.Equal(t, "masterpiece, girl", inputs["text"])
+}
+
+func TestComfyUI(t *testing.T) {
+	workflow := filepath.Join(t.TempDir(), "workflow.json")
+	err := os.WriteFile(workflow, []byte(testComfyWorkflow), 0600)
+	require.NoError(t, err)
+
+	type promptRequest struct {
+		Prompt map[string]struct {
+			Inputs map[string]any `json:"inputs"`
+		} `json:"prompt"`
+		ClientID string `json:"client_id"`
+	}
+	// record the requests and check them in the test goroutine
+	var (
+		polled    int
+		prompt    promptRequest
+		views     []string
+		interrupt string
+		mu        sync.Mutex
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/prompt":
+			err := json.NewDecoder(r.Body).Decode(&prompt)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"prompt_id":"abc","number":1}`))
+		case "/queue":
+			_, _ = w.Write([]byte(`{"queue_running":[[1,"abc",{}]],"queue_pending":[]}`))
+		case "/history/abc":
+			polled++
+			switch polled {
+			case 1:
+				_, _ = w.Write([]byte(`{}`))
+				return
+			case 2:
+				// the transient error is ignored
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			_, _ = w.Write([]byte(`{"abc":{"outputs":{"9":{"images":[
+				{"filename":"a.png","subfolder":"","type":"output"},
+				{"filename":"p.png","subfolder":"","type":"temp"}
+			]}},"status":{"status_str":"success","completed":true}}}`))
+		case "/view":
+			views = append(views, r.URL.Query().Get("filename")+":"+r.URL.Query().Get("type"))
+			_, _ = w.Write([]byte("image"))
+		case "/system_stats":
+			_, _ = w.Write([]byte(`{"system":{}}`))
+		case "/interrupt":
+			interrupt = r.Method
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	comfy := newComfyUI(server.URL, workflow, "flux", 0)
+	ctx := context.Background()
+
+	arg := &txt2Image{
+		Prompt: "girl",
+		Steps:  20,
+		Width:  512,
+		Height: 512,
+		Seed:   123,
+	}
+	images, info, err := comfy.Txt2Img(ctx, arg)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("image")}, images)
+	require.Equal(t, int64(123), info.Seed)
+	require.Equal(t, "flux", info.ModelName)
+
+	mu.Lock()
+	require.Equal(t, 3, polled)
+	require.NotEmpty(t, prompt.ClientID)
+	require.Equal(t, "masterpiece, girl", prompt.Prompt["6"].Inputs["text"])
+	require.Equal(t, float64(123), prompt.Prompt["3"].Inputs["seed"])
+	require.Equal(t, float64(512), prompt.Prompt["5"].Inputs["width"])
+	require.Equal(t, []string{"a.png:output"}, views)
+	mu.Unlock()
+
+	require.NoError(t, comfy.Ping(ctx))
+	// interrupt does nothing for not affect the prompts of other users
+	require.NoError(t, comfy.Interrupt(ctx))
+	mu.Lock()
+	require.Empty(t, interrupt)
+	mu.Unlock()
+	_, err = comfy.Progress(ctx)
+	require.ErrorIs(t, err, errDrawNotSupported)
+	_, err = comfy.Img2Img(ctx, nil)
+	require.ErrorIs(t, err, errDrawNotSupported)
+}
+
+func TestComfyUICancel(t *testing.T) {
+	workflow := filepath.Join(t.TempDir(), "workflow.json")
+	err := os.WriteFile(workflow, []byte(testComfyWorkflow), 0600)
+	require.NoError(t, err)
+
+	var (
+		running bool
+		actions [][2]string // path and body
+		mu      sync.Mutex
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/prompt":
+			_, _ = w.Write([]byte(`{"prompt_id":"abc","number":1}`))
+		case "/history/abc":
+			_, _ = w.Write([]byte(`{}`))
+		case "/queue":
+			if r.Method == http.MethodGet {
+				if running {
+					_, _ = w.Write([]byte(`{"queue_running":[[1,"abc",{}]],"queue_pending":[]}`))
+				} else {
+					_, _ = w.Write([]byte(`{"queue_running":[[1,"other",{}]],"queue_pending":[[2,"abc",{}]]}`))
+				}
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			actions = append(actions, [2]string{r.URL.Path, string(data)})
+		case "/interrupt":
+			data, _ := io.ReadAll(r.Body)
+			actions = append(actions, [2]string{r.URL.Path, string(data)})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	comfy := newComfyUI(server.URL, workflow, "flux", 0)
+	arg := &txt2Image{Prompt: "girl", Steps: 20, Width: 512, Height: 512, Seed: 123}
+	for _, item := range []struct {
+		running bool
+		path    string
+		body    string
+	}{
+		{false, "/queue", `{"delete":["abc"]}`},
+		{true, "/interrupt", `{"prompt_id":"abc"}`},
+	} {
+		mu.Lock()
+		running = item.running
+		actions = nil
+		mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+		_, _, err = comfy.Txt2Img(ctx, arg)
+		cancel()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		mu.Lock()
+		require.Len(t, actions, 1)
+		require.Equal(t, item.path, actions[0][0])
+		require.JSONEq(t, item.body, actions[0][1])
+		mu.Unlock()
+	}
+}
+
+func TestComfyUILostPrompt(t *testing.T) {
+	workflow := filepath.Join(t.TempDir(), "workflow.json")
+	err := os.WriteFile(workflow, []byte(testComfyWorkflow), 0600)
+	require.NoError(t, err)
+
+	var offline atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if offline.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		switch r.URL.Path {
+		case "/prompt":
+			_, _ = w.Write([]byte(`{"prompt_id":"abc","number":1}`))
+		case "/history/abc":
+			_, _ = w.Write([]byte(`{}`))
+		case "/queue":
+			// the prompt is dropped after ComfyUI restart
+			_, _ = w.Write([]byte(`{"queue_running":[],"queue_pending":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	comfy := newComfyUI(server.URL, workflow, "flux", 1500*time.Millisecond)
+	arg := &txt2Image{Prompt: "girl", Steps: 20, Width: 512, Height: 512, Seed: 123}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _, err = comfy.Txt2Img(ctx, arg)
+	require.EqualError(t, err, "prompt is not found in ComfyUI")
+	require.NoError(t, ctx.Err())
+
+	// submit the prompt and the backend is offline
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		offline.Store(true)
+	}()
+	_, _, err = comfy.Txt2Img(ctx, arg)
+	require.ErrorContains(t, err, "failed to get prompt status")
+	require.NoError(t, ctx.Err())
+}
+
+func TestReadComfyUIResponse(t *testing.T) {
+	data, err := readComfyUIResponse(strings.NewReader("data"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), data)
+
+	_, err = readComfyUIResponse(io.LimitReader(zeroReader{}, comfyUIMaxResponse+1))
+	require.Error(t, err)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
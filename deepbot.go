@@ -4,9 +4,9 @@ import (
 	"embed"
 	"fmt"
 	"log"
+	"maps"
 	"math/rand/v2"
 	"os"
-	"strings"
 	"sync"
 	"time"
 
@@ -51,15 +51,35 @@ type Config struct {
 	Chromedp struct {
 		ExecPath string `toml:"exec_path"`
 		ProxyURL string `toml:"proxy_url"`
+		MaxTabs  int    `toml:"max_tabs"`
 	} `toml:"chromedp"`
 
 	Renderer struct {
-		Enabled bool  `toml:"enabled"`
-		Width   int64 `toml:"width"`
-		Height  int64 `toml:"height"`
-		Timeout int   `toml:"timeout"`
+		Enabled    bool    `toml:"enabled"`
+		Engine     string  `toml:"engine"`
+		Theme      string  `toml:"theme"`
+		FontSize   int     `toml:"font_size"`
+		Width      int64   `toml:"width"`
+		Height     int64   `toml:"height"`
+		Scale      float64 `toml:"scale"`
+		PageHeight int     `toml:"page_height"`
+		Timeout    int     `toml:"timeout"`
+		Font       string  `toml:"font"`
 	} `toml:"renderer"`
 
+	Forward struct {
+		Enabled   bool   `toml:"enabled"`
+		Delivery  string `toml:"delivery"`
+		Threshold int    `toml:"threshold"`
+		ChunkSize int    `toml:"chunk_size"`
+	} `toml:"forward"`
+
+	CodeBlock struct {
+		Enabled  bool `toml:"enabled"`
+		MaxCount int  `toml:"max_count"`
+		FileSize int  `toml:"file_size"`
+	} `toml:"code_block"`
+
 	Emoticon struct {
 		Enabled bool `toml:"enabled"`
 		Rate    int  `toml:"rate"`
@@ -70,6 +90,25 @@ type Config struct {
 		URL     string `toml:"url"`
 		Timeout int    `toml:"timeout"`
 		Config  string `toml:"config"`
+
+		Upscaler       string `toml:"upscaler"`
+		QueueSize      int    `toml:"queue_size"`
+		ProgressReport int    `toml:"progress_report"`
+		EnhancePrompt  bool   `toml:"enhance_prompt"`
+		GallerySize    int    `toml:"gallery_size"`
+
+		Balance     string `toml:"balance"`
+		HealthCheck int    `toml:"health_check"`
+		Backends    []struct {
+			Name     string   `toml:"name"`
+			Type     string   `toml:"type"`
+			URL      string   `toml:"url"`
+			Weight   int      `toml:"weight"`
+			MaxJobs  int      `toml:"max_jobs"`
+			Models   []string `toml:"models"`
+			LoRAs    []string `toml:"loras"`
+			Workflow string   `toml:"workflow"`
+		} `toml:"backends"`
 	} `toml:"sd_webui"`
 
 	Memory struct {
@@ -77,28 +116,145 @@ type Config struct {
 	} `toml:"memory"`
 
 	SearchAPI struct {
-		Enabled  bool   `toml:"enabled"`
+		Enabled   bool     `toml:"enabled"`
+		Providers []string `toml:"providers"`
+		Language  string   `toml:"language"`
+		ProxyURL  string   `toml:"proxy_url"`
+		Timeout   int      `toml:"timeout"`
+		CacheTTL  int      `toml:"cache_ttl"`
+		CacheSize int      `toml:"cache_size"`
+		MaxImages int      `toml:"max_images"`
+
+		// the Google search config in old version
 		EngineID string `toml:"engine_id"`
 		APIKey   string `toml:"api_key"`
-		ProxyURL string `toml:"proxy_url"`
-		Timeout  int    `toml:"timeout"`
+
+		Google struct {
+			EngineID string `toml:"engine_id"`
+			APIKey   string `toml:"api_key"`
+		} `toml:"google"`
+
+		SearXNG struct {
+			URL string `toml:"url"`
+		} `toml:"searxng"`
+
+		Bing struct {
+			Endpoint string `toml:"endpoint"`
+			APIKey   string `toml:"api_key"`
+		} `toml:"bing"`
+
+		Brave struct {
+			APIKey string `toml:"api_key"`
+		} `toml:"brave"`
 	} `toml:"search_api"`
 
 	Browser struct {
-		Enabled bool `toml:"enabled"`
-		Timeout int  `toml:"timeout"`
+		Enabled    bool `toml:"enabled"`
+		Timeout    int  `toml:"timeout"`
+		MaxActions int  `toml:"max_actions"`
 	} `toml:"browser"`
 
+	URLPolicy struct {
+		Schemes      []string `toml:"schemes"`
+		AllowPrivate bool     `toml:"allow_private"`
+		AllowDomains []string `toml:"allow_domains"`
+		DenyDomains  []string `toml:"deny_domains"`
+		MaxSize      int64    `toml:"max_size"`
+	} `toml:"url_policy"`
+
 	EvalGo struct {
 		Enabled bool `toml:"enabled"`
 		Timeout int  `toml:"timeout"`
 	} `toml:"eval_go"`
+
+	EvalJS struct {
+		Enabled bool `toml:"enabled"`
+		Timeout int  `toml:"timeout"`
+	} `toml:"eval_js"`
+
+	EvalLua struct {
+		Enabled bool `toml:"enabled"`
+		Timeout int  `toml:"timeout"`
+	} `toml:"eval_lua"`
+
+	RunCode struct {
+		UserID []int64 `toml:"user_id"`
+	} `toml:"run_code"`
+
+	Calc struct {
+		Enabled bool `toml:"enabled"`
+		Timeout int  `toml:"timeout"`
+	} `toml:"calc"`
+
+	Vision struct {
+		Enabled   bool   `toml:"enabled"`
+		Provider  string `toml:"provider"`
+		BaseURL   string `toml:"base_url"`
+		APIKey    string `toml:"api_key"`
+		Model     string `toml:"model"`
+		Prompt    string `toml:"prompt"`
+		MaxImages int    `toml:"max_images"`
+		Timeout   int    `toml:"timeout"`
+	} `toml:"vision"`
+
+	STT struct {
+		Enabled  bool   `toml:"enabled"`
+		Provider string `toml:"provider"`
+		URL      string `toml:"url"`
+		APIKey   string `toml:"api_key"`
+		Model    string `toml:"model"`
+		Language string `toml:"language"`
+		Timeout  int    `toml:"timeout"`
+	} `toml:"stt"`
+
+	TTS struct {
+		Enabled   bool              `toml:"enabled"`
+		Provider  string            `toml:"provider"`
+		URL       string            `toml:"url"`
+		APIKey    string            `toml:"api_key"`
+		Model     string            `toml:"model"`
+		Mode      string            `toml:"mode"`
+		Voice     string            `toml:"voice"`
+		Voices    map[string]string `toml:"voices"`
+		MaxLength int               `toml:"max_length"`
+		Timeout   int               `toml:"timeout"`
+	} `toml:"tts"`
+
+	Moderation struct {
+		Enabled    bool     `toml:"enabled"`
+		Action     string   `toml:"action"`
+		Keywords   []string `toml:"keywords"`
+		Patterns   []string `toml:"patterns"`
+		Classifier string   `toml:"classifier"`
+		URL        string   `toml:"url"`
+		Threshold  float64  `toml:"threshold"`
+		Timeout    int      `toml:"timeout"`
+		FailOpen   bool     `toml:"fail_open"`
+		Groups     []struct {
+			ID     int64  `toml:"id"`
+			Action string `toml:"action"`
+		} `toml:"groups"`
+	} `toml:"moderation"`
 }
 
 type DeepBot struct {
-	config *Config
-	client *deepseek.Client
-	tools  []deepseek.Tool
+	config   *Config
+	client   *deepseek.Client
+	tools    []deepseek.Tool
+	toolList map[string]toolFunc
+	browser  *browser
+	renderer *textRenderer
+	policy   *urlPolicy
+	searcher *searcher
+	vision   visionProvider
+	stt      sttProvider
+	tts      ttsProvider
+
+	moderator *moderator
+
+	drawBackends *drawBackends
+	drawQueue    *drawQueue
+	gallery      *gallery
 
 	users   map[int64]*user
 	usersMu sync.Mutex
@@ -114,8 +270,10 @@ func NewDeepBot(config *Config) *DeepBot {
 	if timeout != 0 {
 		client.Timeout = time.Duration(timeout) * time.Millisecond
 	}
-	// build tools from config
+	// build tools from config, the limit of tools is copied
+	// from the default for each bot instance
 	var tools []deepseek.Tool
+	toolFuncs := maps.Clone(toolList)
 	tools = append(tools, toolGetTime)
 	if config.SearchAPI.Enabled {
 		tools = append(tools, toolSearchWeb)
@@ -123,16 +281,46 @@ func NewDeepBot(config *Config) *DeepBot {
 	}
 	if config.Browser.Enabled {
 		tools = append(tools, toolBrowseURL)
+		tools = append(tools, toolBrowser)
+		// set the action budget about browser in one round
+		if n := config.Browser.MaxActions; n > 0 {
+			for _, name := range []string{fnBrowseURL, fnBrowser} {
+				tool := toolFuncs[name]
+				tool.Limit = n
+				toolFuncs[name] = tool
+			}
+		}
 	}
 	if config.EvalGo.Enabled {
 		tools = append(tools, toolEvalGo)
 	}
+	if config.EvalJS.Enabled {
+		tools = append(tools, toolEvalJS)
+	}
+	if config.EvalLua.Enabled {
+		tools = append(tools, toolEvalLua)
+	}
+	if config.Calc.Enabled {
+		tools = append(tools, toolCalc)
+	}
 	bot := DeepBot{
-		config: config,
-		client: client,
-		tools:  tools,
-		users:  make(map[int64]*user),
+		config:   config,
+		client:   client,
+		tools:    tools,
+		toolList: toolFuncs,
+		users:    make(map[int64]*user),
 	}
+	bot.browser = newBrowser(bot.getChromedpOptions(), config.Chromedp.MaxTabs)
+	bot.renderer = newTextRenderer(config)
+	bot.policy = newURLPolicy(config)
+	bot.searcher = newSearcher(config)
+	bot.vision = newVisionProvider(config)
+	bot.stt = newSTTProvider(config)
+	bot.tts = newTTSProvider(config)
+	bot.moderator = newModerator(config)
+	bot.drawBackends = newDrawBackends(config)
+	bot.drawQueue = newDrawQueue(&bot)
+	bot.gallery = newGallery("data/gallery", config.SDWebUI.GallerySize)
 	// register message handler
 	groupID := config.GroupID
 	blockID := config.BlockID
@@ -163,6 +351,16 @@ func NewDeepBot(config *Config) *DeepBot {
 	zero.OnCommand("coderx ", filter).SetBlock(true).Handle(bot.onCoderX)
 	zero.OnCommand("pic ", filter).SetBlock(true).Handle(bot.onDrawImage)
 	zero.OnCommand("picx ", filter).SetBlock(true).Handle(bot.onDrawImageWithArgs)
+	zero.OnCommand("img2img ", filter).SetBlock(true).Handle(bot.onImageToImage)
+	zero.OnCommand("inpaint ", filter).SetBlock(true).Handle(bot.onInpaintImage)
+	zero.OnCommand("upscale", filter).SetBlock(true).Handle(bot.onUpscaleImage)
+	zero.OnCommand("reroll", filter).SetBlock(true).Handle(bot.onRerollImage)
+	zero.OnCommand("vary", filter).SetBlock(true).Handle(bot.onVaryImage)
+	zero.OnCommand("redraw ", filter).SetBlock(true).Handle(bot.onRedrawResolution)
+	zero.OnCommand("deep.画图进度", filter).SetBlock(true).Handle(bot.onDrawStatus)
+	zero.OnCommand("deep.取消画图", filter).SetBlock(true).Handle(bot.onCancelDraw)
+	zero.OnCommand("deep.图库", filter).SetBlock(true).Handle(bot.onListGallery)
+	zero.OnCommand("deep.查看图片 ", filter).SetBlock(true).Handle(bot.onShowGallery)
 	zero.OnCommand("deep.当前模型", filter).SetBlock(true).Handle(bot.onGetModel)
 	zero.OnCommand("deep.设置模型 ", filter).SetBlock(true).Handle(bot.onSetModel)
 	zero.OnCommand("deep.启用函数", filter).SetBlock(true).Handle(bot.onEnableToolCall)
@@ -186,8 +384,12 @@ func NewDeepBot(config *Config) *DeepBot {
 	zero.OnCommand("deep.配置人设 ", filter).SetBlock(true).Handle(bot.onSetCharacter)
 	zero.OnCommand("deep.添加人设 ", filter).SetBlock(true).Handle(bot.onAddCharacter)
 	zero.OnCommand("deep.删除人设 ", filter).SetBlock(true).Handle(bot.onDelCharacter)
+	zero.OnCommand("deep.渲染设置", filter).SetBlock(true).Handle(bot.onGetRenderOptions)
+	zero.OnCommand("deep.设置渲染 ", filter).SetBlock(true).Handle(bot.onSetRenderOptions)
 	zero.OnCommand("deep.读取心情", filter).SetBlock(true).Handle(bot.onGetMood)
 	zero.OnCommand("deep.当前心情", filter).SetBlock(true).Handle(bot.onUpdateMood)
+	zero.OnCommand("deep.run", filter).SetBlock(true).Handle(bot.onRunCode)
+	zero.OnCommand("deep.运行代码", filter).SetBlock(true).Handle(bot.onRunCode)
 	zero.OnCommand("deep.总结群聊", filter).SetBlock(true).Handle(bot.onSummarizeGroupMsg)
 	zero.OnCommand("deep.help", filter).SetBlock(true).Handle(bot.onHelp)
 	zero.OnCommand("deep.帮助文档", filter).SetBlock(true).Handle(bot.onHelp)
@@ -198,6 +400,14 @@ func NewDeepBot(config *Config) *DeepBot {
 }
 
 func (bot *DeepBot) Run() {
+	if bot.config.Renderer.Enabled || bot.config.Browser.Enabled {
+		go func() {
+			err := bot.browser.WarmUp()
+			if err != nil {
+				log.Println("[warning] failed to launch browser:", err)
+			}
+		}()
+	}
 	go func() {
 		for {
 			var connected bool
@@ -230,6 +440,14 @@ func (bot *DeepBot) Run() {
 	zero.RunAndBlock(&cfg, nil)
 }
 
+// Close is used to release the resource about bot like browser.
+func (bot *DeepBot) Close() {
+	bot.browser.Close()
+	bot.searcher.Close()
+	bot.drawQueue.Close()
+	bot.drawBackends.Close()
+}
+
 func (bot *DeepBot) getUser(uid int64) *user {
 	bot.usersMu.Lock()
 	defer bot.usersMu.Unlock()
@@ -274,32 +492,41 @@ func (bot *DeepBot) onNotice(ctx *zero.Ctx) {
 	}
 }
 
-// process command about chat.
-func (bot *DeepBot) reply(ctx *zero.Ctx, user *user, msg string) {
-	defer bot.postProcess(ctx, user, msg)
-	if !bot.config.Renderer.Enabled {
-		sendText(ctx, msg, true)
+// process command about chat, fromVoice is true if the question is a voice message.
+func (bot *DeepBot) reply(ctx *zero.Ctx, user *user, msg string, fromVoice bool) {
+	msg, ok := bot.moderateText(ctx, msg)
+	if !ok {
+		bot.sendToolImages(ctx, user)
 		return
 	}
-	if isMarkdown(msg) {
-		img, err := bot.markdownToImage(msg)
+	defer bot.postProcess(ctx, user, msg, fromVoice)
+	defer bot.sendToolImages(ctx, user)
+	msg = bot.attachSearchImages(user, msg)
+	opts := bot.renderOptions(bot.getUser(ctx.Event.UserID))
+	switch bot.selectDelivery(msg, opts) {
+	case deliveryText:
+		sendText(ctx, msg, true)
+	case deliveryForward:
+		bot.sendForward(ctx, msg)
+	default:
+		if !opts.isMarkdown(msg) {
+			bot.sendLongText(ctx, msg)
+			return
+		}
+		images, err := bot.markdownToImages(msg, opts)
 		if err != nil {
 			log.Println(err)
+			sendText(ctx, msg, true)
 			return
 		}
-		sendImage(ctx, img)
-		return
+		sendImages(ctx, images)
+		bot.sendCodeBlocks(ctx, msg)
 	}
-	if len(msg) < 1024 {
-		sendText(ctx, msg, true)
-		return
-	}
-	bot.sendLongText(ctx, msg)
 }
 
 // process command about get status.
 func (bot *DeepBot) sendText(ctx *zero.Ctx, text string) {
-	if !bot.config.Renderer.Enabled || len(text) < 1024 {
+	if !bot.config.Renderer.Enabled || len(text) < longTextLength {
 		sendText(ctx, text, false)
 		return
 	}
@@ -307,23 +534,35 @@ func (bot *DeepBot) sendText(ctx *zero.Ctx, text string) {
 }
 
 func (bot *DeepBot) sendLongText(ctx *zero.Ctx, text string) {
-	sections := strings.Split(text, "\n")
-	builder := strings.Builder{}
-	builder.Grow(len(text))
-	for _, section := range sections {
-		builder.WriteString("<div>")
-		builder.WriteString(section)
-		builder.WriteString("</div>")
-	}
-	img, err := bot.htmlToImage(builder.String())
+	images, err := bot.textToImages(text, bot.renderOptions(bot.getUser(ctx.Event.UserID)))
 	if err != nil {
 		log.Println(err)
+		sendText(ctx, text, true)
+		return
+	}
+	sendImages(ctx, images)
+}
+
+// sendToolImages is used to send the images like screenshot from tool call.
+func (bot *DeepBot) sendToolImages(ctx *zero.Ctx, user *user) {
+	if user == nil {
+		return
+	}
+	for _, img := range user.takeImages() {
+		bot.sendImage(ctx, img)
+	}
+}
+
+// sendImage is used to send the image after the moderation.
+func (bot *DeepBot) sendImage(ctx *zero.Ctx, img []byte) {
+	img, ok := bot.moderateImage(ctx, img)
+	if !ok {
 		return
 	}
 	sendImage(ctx, img)
 }
 
-func (bot *DeepBot) sendImage(ctx *zero.Ctx, path string) {
+func (bot *DeepBot) sendImageFile(ctx *zero.Ctx, path string) {
 	fmt.Println("===============reply image==============")
 	fmt.Println(path)
 	fmt.Println("========================================")
@@ -333,7 +572,7 @@ func (bot *DeepBot) sendImage(ctx *zero.Ctx, path string) {
 		log.Println("failed to load image:", err)
 		return
 	}
-	sendImage(ctx, img)
+	bot.sendImage(ctx, img)
 }
 
 func sendText(ctx *zero.Ctx, text string, reply bool) {
@@ -380,9 +619,20 @@ func sendImage(ctx *zero.Ctx, img []byte) {
 	ctx.Send(message.ImageBytes(img))
 }
 
+// sendImages is used to send the pages of the rendered image in order.
+func sendImages(ctx *zero.Ctx, images [][]byte) {
+	for i, img := range images {
+		if i == 0 {
+			sendImage(ctx, img)
+			continue
+		}
+		ctx.Send(message.ImageBytes(img))
+	}
+}
+
 //go:embed template/help.md
 var helpMD string
 
 func (bot *DeepBot) onHelp(ctx *zero.Ctx) {
-	bot.reply(ctx, nil, helpMD)
+	bot.reply(ctx, nil, helpMD, false)
 }
@@ -22,7 +22,7 @@ func (bot *DeepBot) replyEmoticon(ctx *zero.Ctx, user *user) {
 		dir := "data/emoticon/通用"
 		cat := selectRandomItem(dir)
 		img := selectRandomItem(cat)
-		bot.sendImage(ctx, img)
+		bot.sendImageFile(ctx, img)
 		return
 	}
 
@@ -51,16 +51,16 @@ func (bot *DeepBot) replyEmoticon(ctx *zero.Ctx, user *user) {
 		}
 		fmt.Println("draw image prompt:", prompt)
 
-		img, err := bot.drawImage(prompt, 30, 1024, 1024)
+		img, err := bot.drawImage(ctx, prompt, 30, 1024, 1024)
 		if err == nil {
-			sendImage(ctx, img)
+			bot.sendImage(ctx, img)
 			return
 		}
 	}
 
 	dir := fmt.Sprintf("data/emoticon/%s/%s", role, category)
 	img := selectRandomItem(dir)
-	bot.sendImage(ctx, img)
+	bot.sendImageFile(ctx, img)
 }
 
 func selectRandomItem(dir string) string {
@@ -0,0 +1,261 @@
+package deepbot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+	"github.com/yuin/gopher-lua"
+)
+
+// evaluator is the common signature about interpreter tools,
+// the output is the content that program write to stdout and stderr.
+type evaluator func(ctx context.Context, src string) (string, error)
+
+var errOutputTooLarge = errors.New("program output is too large")
+
+// evalGoDenyPackages are the packages that can access the network or start
+// process, they are not provided to the Go interpreter because the program
+// can not be checked by the URL policy, the "/..." suffix means include the
+// sub packages. JavaScript and Lua interpreters have no network library, so
+// they are not need to be restricted.
+var evalGoDenyPackages = []string{
+	"net", "net/http/...", "net/rpc/...", "net/smtp", "net/textproto",
+	"crypto/tls", "log/syslog", "os/exec", "syscall/...",
+}
+
+// evalGoDenySymbols are the symbols in the allowed packages that can
+// start process, the other symbols of these packages are still provided.
+var evalGoDenySymbols = map[string][]string{
+	"os": {"StartProcess", "FindProcess"},
+}
+
+// evalGoSymbols is used to build the symbols of standard library that
+// exclude the denied packages and symbols.
+func evalGoSymbols() interp.Exports {
+	symbols := make(interp.Exports, len(stdlib.Symbols))
+	for key, values := range stdlib.Symbols {
+		// the key is the package path joined with the package name
+		path := key[:max(strings.LastIndex(key, "/"), 0)]
+		if isDeniedPackage(path) {
+			continue
+		}
+		names, ok := evalGoDenySymbols[path]
+		if ok {
+			values = maps.Clone(values)
+			for _, name := range names {
+				delete(values, name)
+			}
+		}
+		symbols[key] = values
+	}
+	return symbols
+}
+
+func isDeniedPackage(path string) bool {
+	for _, pkg := range evalGoDenyPackages {
+		prefix, ok := strings.CutSuffix(pkg, "/...")
+		if path == prefix || (ok && strings.HasPrefix(path, prefix+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitedWriter is used to limit the output of program, the data that
+// exceed the limit will be dropped and the write will return an error.
+type limitedWriter struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func newLimitedWriter(limit int) *limitedWriter {
+	return &limitedWriter{limit: limit}
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	n := w.limit - w.buf.Len()
+	if len(p) > n {
+		w.buf.Write(p[:max(n, 0)])
+		return max(n, 0), errOutputTooLarge
+	}
+	return w.buf.Write(p)
+}
+
+func (w *limitedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *limitedWriter) Len() int {
+	return w.buf.Len()
+}
+
+func (w *limitedWriter) String() string {
+	return w.buf.String()
+}
+
+func onEvalGo(ctx context.Context, src string) (string, error) {
+	fmt.Println("================EvalGo================")
+	fmt.Println(src)
+	fmt.Println("======================================")
+
+	stdin := bytes.NewReader(nil)
+	output := newLimitedWriter(maxToolCallLen)
+	opts := interp.Options{
+		Stdin:  stdin,
+		Stdout: output,
+		Stderr: output,
+	}
+	interpreter := interp.New(opts)
+	err := interpreter.Use(evalGoSymbols())
+	if err != nil {
+		return "", err
+	}
+	_, err = interpreter.EvalWithContext(ctx, src)
+	if err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
+
+func onEvalJS(ctx context.Context, src string) (string, error) {
+	fmt.Println("================EvalJS================")
+	fmt.Println(src)
+	fmt.Println("======================================")
+
+	vm := goja.New()
+	output := newLimitedWriter(maxToolCallLen)
+	write := func(call goja.FunctionCall) goja.Value {
+		args := make([]string, len(call.Arguments))
+		for i, arg := range call.Arguments {
+			args[i] = arg.String()
+		}
+		_, err := output.WriteString(strings.Join(args, " ") + "\n")
+		if err != nil {
+			panic(vm.NewGoError(err))
+		}
+		return goja.Undefined()
+	}
+	vm.SetMaxCallStackSize(4096)
+	console := vm.NewObject()
+	for _, name := range []string{"log", "info", "warn", "error", "debug"} {
+		err := console.Set(name, write)
+		if err != nil {
+			return "", err
+		}
+	}
+	err := vm.Set("console", console)
+	if err != nil {
+		return "", err
+	}
+	err = vm.Set("print", write)
+	if err != nil {
+		return "", err
+	}
+
+	// interrupt the virtual machine when reach timeout
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			vm.Interrupt(ctx.Err())
+		case <-done:
+		}
+	}()
+	value, err := vm.RunString(src)
+	if err != nil {
+		return "", err
+	}
+	// return the value of the last expression if program not print anything
+	if output.Len() == 0 && value != nil && !goja.IsUndefined(value) {
+		return value.String(), nil
+	}
+	return output.String(), nil
+}
+
+func onEvalLua(ctx context.Context, src string) (string, error) {
+	fmt.Println("================EvalLua===============")
+	fmt.Println(src)
+	fmt.Println("======================================")
+
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:        true,
+		CallStackSize:       1024,
+		RegistryMaxSize:     1024 * 1024,
+		IncludeGoStackTrace: false,
+	})
+	defer L.Close()
+	// only open the libraries without file system and process access
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		err := L.CallByParam(lua.P{
+			Fn:      L.NewFunction(lib.fn),
+			NRet:    0,
+			Protect: true,
+		}, lua.LString(lib.name))
+		if err != nil {
+			return "", err
+		}
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	output := newLimitedWriter(maxToolCallLen)
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		top := L.GetTop()
+		args := make([]string, top)
+		for i := 1; i <= top; i++ {
+			args[i-1] = L.ToStringMeta(L.Get(i)).String()
+		}
+		_, err := output.WriteString(strings.Join(args, "\t") + "\n")
+		if err != nil {
+			L.RaiseError("%s", err)
+		}
+		return 0
+	}))
+	L.SetContext(ctx)
+
+	err := L.DoString(src)
+	if err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
+
+func onCalc(ctx context.Context, expr string) (string, error) {
+	fmt.Println("=================Calc=================")
+	fmt.Println(expr)
+	fmt.Println("======================================")
+
+	var results []string
+	for _, line := range strings.Split(expr, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		result, err := calculate(line)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", line, err)
+		}
+		results = append(results, line+" = "+result)
+	}
+	return strings.Join(results, "\n"), nil
+}
@@ -0,0 +1,211 @@
+package deepbot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnEvalGoSandbox(t *testing.T) {
+	for name, src := range map[string]string{
+		"start process": `
+package main
+
+import "os"
+
+func main() {
+	_, _ = os.StartProcess("/bin/sh", []string{"sh"}, &os.ProcAttr{})
+}
+`,
+		"find process": `
+package main
+
+import "os"
+
+func main() {
+	p, _ := os.FindProcess(1)
+	_ = p.Kill()
+}
+`,
+		"syscall exec": `
+package main
+
+import "syscall"
+
+func main() {
+	_ = syscall.Exec("/bin/sh", []string{"sh"}, nil)
+}
+`,
+		"syscall socket": `
+package main
+
+import "syscall"
+
+func main() {
+	fd, _ := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	_ = syscall.Connect(fd, &syscall.SockaddrInet4{Port: 80, Addr: [4]byte{127, 0, 0, 1}})
+}
+`,
+		"textproto dial": `
+package main
+
+import "net/textproto"
+
+func main() {
+	_, _ = textproto.Dial("tcp", "127.0.0.1:25")
+}
+`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+
+			_, err := onEvalGo(ctx, src)
+			require.Error(t, err)
+		})
+	}
+
+	t.Run("allowed symbols", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		src := `
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println(os.Getpid() > 0)
+}
+`
+		output, err := onEvalGo(ctx, src)
+		require.NoError(t, err)
+		require.Equal(t, "true\n", output)
+	})
+}
+
+func TestIsDeniedPackage(t *testing.T) {
+	for _, path := range []string{
+		"net", "net/http", "net/http/httputil", "net/textproto", "os/exec", "syscall", "syscall/js",
+	} {
+		require.True(t, isDeniedPackage(path), path)
+	}
+	for _, path := range []string{"net/url", "net/netip", "os", "fmt"} {
+		require.False(t, isDeniedPackage(path), path)
+	}
+}
+
+func TestOnEvalJS(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		src := `
+var data = JSON.parse('{"a": [1, 2, 3]}');
+console.log("sum:", data.a.reduce(function(x, y) { return x + y; }, 0));
+`
+		output, err := onEvalJS(ctx, src)
+		require.NoError(t, err)
+		require.Equal(t, "sum: 6\n", output)
+	})
+
+	t.Run("last expression", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		output, err := onEvalJS(ctx, "1 + 2")
+		require.NoError(t, err)
+		require.Equal(t, "3", output)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := onEvalJS(ctx, "for (;;) {}")
+		require.Error(t, err)
+	})
+
+	t.Run("output too large", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		_, err := onEvalJS(ctx, `for (;;) { print("a".repeat(1024)); }`)
+		require.ErrorContains(t, err, errOutputTooLarge.Error())
+	})
+}
+
+func TestOnEvalLua(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		src := `
+local sum = 0
+for i = 1, 10 do
+	sum = sum + i
+end
+print("sum:", sum)
+`
+		output, err := onEvalLua(ctx, src)
+		require.NoError(t, err)
+		require.Equal(t, "sum:\t55\n", output)
+	})
+
+	t.Run("sandbox", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		_, err := onEvalLua(ctx, `os.execute("whoami")`)
+		require.Error(t, err)
+		_, err = onEvalLua(ctx, `dofile("/etc/passwd")`)
+		require.Error(t, err)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := onEvalLua(ctx, "while true do end")
+		require.Error(t, err)
+	})
+
+	t.Run("output too large", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		_, err := onEvalLua(ctx, `while true do print(string.rep("a", 1024)) end`)
+		require.ErrorContains(t, err, errOutputTooLarge.Error())
+	})
+}
+
+func TestLimitedWriter(t *testing.T) {
+	w := newLimitedWriter(8)
+	n, err := w.WriteString("hello")
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	n, err = w.WriteString("world")
+	require.Equal(t, errOutputTooLarge, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, "hellowor", w.String())
+
+	n, err = w.WriteString("!")
+	require.Equal(t, errOutputTooLarge, err)
+	require.Zero(t, n)
+	require.Equal(t, 8, w.Len())
+}
+
+func TestOnCalc(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	output, err := onCalc(ctx, "1 + 2\n5 km to m")
+	require.NoError(t, err)
+	require.Equal(t, "1 + 2 = 3\n5 km to m = 5000 m", output)
+}
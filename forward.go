@@ -0,0 +1,205 @@
+package deepbot
+
+import (
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const (
+	deliveryAuto    = "auto"
+	deliveryImage   = "image"
+	deliveryForward = "forward"
+	deliveryText    = "text"
+)
+
+const (
+	longTextLength          = 1024
+	defaultForwardThreshold = 4096
+	defaultForwardChunkSize = 1500
+	minForwardChunkSize     = 256
+)
+
+// selectDelivery is used to select the way to send the answer by the
+// preference of user and the length, forward message is used for the
+// long answer that need keep the text and code blocks can be copied, the
+// auto mode will not forward the markdown answer if renderer is enabled.
+func (bot *DeepBot) selectDelivery(msg string, opts *renderOptions) string {
+	forward := bot.config.Forward.Enabled
+	long := len(msg) >= longTextLength
+	switch opts.Delivery {
+	case deliveryText:
+		return deliveryText
+	case deliveryForward:
+		if forward && long {
+			return deliveryForward
+		}
+	case deliveryAuto:
+		threshold := bot.config.Forward.Threshold
+		if threshold < 1 {
+			threshold = defaultForwardThreshold
+		}
+		// the markdown answer is always rendered for keep the format
+		if !bot.config.Renderer.Enabled {
+			if forward && long {
+				return deliveryForward
+			}
+		} else if forward && len(msg) >= threshold && !opts.isMarkdown(msg) {
+			return deliveryForward
+		}
+	}
+	if !bot.config.Renderer.Enabled {
+		return deliveryText
+	}
+	if opts.isMarkdown(msg) || long {
+		return deliveryImage
+	}
+	return deliveryText
+}
+
+// sendForward is used to send the chunks of answer as a merged forward
+// message, it will fall back to the long text if the OneBot failed.
+func (bot *DeepBot) sendForward(ctx *zero.Ctx, msg string) {
+	size := bot.config.Forward.ChunkSize
+	if size > 0 && size < minForwardChunkSize {
+		size = minForwardChunkSize
+	}
+	chunks := splitChunks(msg, size)
+	nickname := "DeepBot"
+	if len(zero.BotConfig.NickName) > 0 {
+		nickname = zero.BotConfig.NickName[0]
+	}
+	nodes := make(message.Message, 0, len(chunks))
+	for _, chunk := range chunks {
+		content := message.Message{message.Text(chunk)}
+		nodes = append(nodes, message.CustomNode(nickname, ctx.Event.SelfID, content))
+	}
+	var ok bool
+	if ctx.Event.GroupID != 0 {
+		ok = ctx.SendGroupForwardMessage(ctx.Event.GroupID, nodes).Get("message_id").Exists()
+	} else {
+		ok = ctx.SendPrivateForwardMessage(ctx.Event.UserID, nodes).Get("message_id").Exists()
+	}
+	if ok {
+		return
+	}
+	log.Println("[warning] failed to send forward message, use long text")
+	if !bot.config.Renderer.Enabled {
+		sendText(ctx, msg, true)
+		return
+	}
+	bot.sendLongText(ctx, msg)
+}
+
+// splitChunks is used to split the answer to chunks at the paragraph
+// boundaries, the fenced code block is a separate chunk without fence
+// lines, so that it can be copied directly from the forward message.
+func splitChunks(text string, size int) []string {
+	if size < 1 {
+		size = defaultForwardChunkSize
+	}
+	var (
+		chunks []string
+		buf    strings.Builder
+		code   []string
+		inCode bool
+	)
+	flush := func() {
+		chunk := strings.TrimSpace(buf.String())
+		if chunk != "" {
+			chunks = append(chunks, splitLongChunk(chunk, size)...)
+		}
+		buf.Reset()
+	}
+	flushCode := func() {
+		chunk := strings.Trim(strings.Join(code, "\n"), "\n")
+		if chunk != "" {
+			chunks = append(chunks, splitLongChunk(chunk, size)...)
+		}
+		code = code[:0]
+	}
+	var paragraph strings.Builder
+	endParagraph := func() {
+		p := strings.TrimSpace(paragraph.String())
+		paragraph.Reset()
+		if p == "" {
+			return
+		}
+		if buf.Len() > 0 && buf.Len()+len(p)+2 > size {
+			flush()
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n\n")
+		}
+		buf.WriteString(p)
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fence := strings.HasPrefix(strings.TrimSpace(line), "```")
+		switch {
+		case inCode && fence:
+			flushCode()
+			inCode = false
+		case inCode:
+			code = append(code, line)
+		case fence:
+			endParagraph()
+			flush()
+			inCode = true
+		case strings.TrimSpace(line) == "":
+			endParagraph()
+		default:
+			paragraph.WriteString(line)
+			paragraph.WriteString("\n")
+		}
+	}
+	if inCode {
+		flushCode()
+	}
+	endParagraph()
+	flush()
+	return chunks
+}
+
+// splitLongChunk is used to split the chunk that larger than the size by
+// lines, and the line that too long will be split at the rune boundary.
+func splitLongChunk(chunk string, size int) []string {
+	if size < utf8.UTFMax {
+		size = utf8.UTFMax
+	}
+	if len(chunk) <= size {
+		return []string{chunk}
+	}
+	var (
+		chunks []string
+		buf    strings.Builder
+	)
+	for _, line := range strings.Split(chunk, "\n") {
+		if buf.Len() > 0 && buf.Len()+len(line)+1 > size {
+			chunks = append(chunks, buf.String())
+			buf.Reset()
+		}
+		for len(line) > size {
+			n := size
+			for n > 0 && !utf8.RuneStart(line[n]) {
+				n--
+			}
+			// the size is smaller than the rune, keep the whole rune
+			if n == 0 {
+				_, n = utf8.DecodeRuneInString(line)
+			}
+			chunks = append(chunks, line[:n])
+			line = line[n:]
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		chunks = append(chunks, buf.String())
+	}
+	return chunks
+}
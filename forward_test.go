@@ -0,0 +1,108 @@
+package deepbot
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectDelivery(t *testing.T) {
+	config := new(Config)
+	config.Renderer.Enabled = true
+	config.Forward.Enabled = true
+	config.Forward.Threshold = 2048
+	bot := &DeepBot{config: config}
+
+	short := "hello"
+	markdown := "# title\n\n* item 1\n* item 2"
+	long := strings.Repeat("word ", 300)
+	huge := strings.Repeat("word ", 500)
+	hugeMarkdown := strings.Repeat(markdown+"\n\n", 100)
+
+	for _, item := range []struct {
+		delivery string
+		msg      string
+		expected string
+	}{
+		{deliveryAuto, short, deliveryText},
+		{deliveryAuto, markdown, deliveryImage},
+		{deliveryAuto, long, deliveryImage},
+		{deliveryAuto, huge, deliveryForward},
+		{deliveryAuto, hugeMarkdown, deliveryImage},
+		{deliveryImage, huge, deliveryImage},
+		{deliveryForward, short, deliveryText},
+		{deliveryForward, markdown, deliveryImage},
+		{deliveryForward, long, deliveryForward},
+		{deliveryText, huge, deliveryText},
+	} {
+		opts := &renderOptions{Delivery: item.delivery}
+		require.Equal(t, item.expected, bot.selectDelivery(item.msg, opts), item.delivery)
+	}
+
+	t.Run("disable renderer", func(t *testing.T) {
+		config.Renderer.Enabled = false
+		defer func() { config.Renderer.Enabled = true }()
+
+		opts := &renderOptions{Delivery: deliveryAuto}
+		require.Equal(t, deliveryText, bot.selectDelivery(markdown, opts))
+		require.Equal(t, deliveryForward, bot.selectDelivery(long, opts))
+		require.Equal(t, deliveryForward, bot.selectDelivery(hugeMarkdown, opts))
+	})
+
+	t.Run("disable forward", func(t *testing.T) {
+		config.Forward.Enabled = false
+		defer func() { config.Forward.Enabled = true }()
+
+		opts := &renderOptions{Delivery: deliveryForward}
+		require.Equal(t, deliveryImage, bot.selectDelivery(huge, opts))
+	})
+}
+
+func TestSplitLongChunk(t *testing.T) {
+	for _, size := range []int{-1, 0, 1, 2, 5} {
+		chunks := splitLongChunk("a你好😀b", size)
+		require.Equal(t, "a你好😀b", strings.Join(chunks, ""))
+		for _, chunk := range chunks {
+			require.True(t, utf8.ValidString(chunk))
+		}
+	}
+	chunks := splitLongChunk("你好😀", 2)
+	require.Equal(t, []string{"你", "好", "😀"}, chunks)
+}
+
+func TestSplitChunks(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		text := "para 1\nline 2\n\n\npara 2\n\n```go\nfmt.Println(1)\n\nfmt.Println(2)\n```\npara 3"
+		chunks := splitChunks(text, 100)
+		expected := []string{
+			"para 1\nline 2\n\npara 2",
+			"fmt.Println(1)\n\nfmt.Println(2)",
+			"para 3",
+		}
+		require.Equal(t, expected, chunks)
+	})
+
+	t.Run("merge paragraphs", func(t *testing.T) {
+		text := "aaaa\n\nbbbb\n\ncccc\n\ndddd"
+		chunks := splitChunks(text, 10)
+		require.Equal(t, []string{"aaaa\n\nbbbb", "cccc\n\ndddd"}, chunks)
+	})
+
+	t.Run("long paragraph", func(t *testing.T) {
+		text := "aaaa\nbbbb\n" + strings.Repeat("你", 5)
+		chunks := splitChunks(text, 10)
+		require.Equal(t, []string{"aaaa\nbbbb", "你你你", "你你"}, chunks)
+	})
+
+	t.Run("tiny size", func(t *testing.T) {
+		chunks := splitChunks("你好\n世界", 1)
+		require.Equal(t, []string{"你", "好", "世", "界"}, chunks)
+	})
+
+	t.Run("unclosed code block", func(t *testing.T) {
+		chunks := splitChunks("text\n```\ncode", 0)
+		require.Equal(t, []string{"text", "code"}, chunks)
+	})
+}
@@ -1,19 +1,12 @@
 package deepbot
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"os"
 	"time"
 
 	"github.com/chromedp/chromedp"
 	"github.com/cohesion-org/deepseek-go"
-	"github.com/traefik/yaegi/interp"
-	"github.com/traefik/yaegi/stdlib"
 )
 
 const (
@@ -21,13 +14,18 @@ const (
 	fnSearchWeb   = "SearchWeb"
 	fnSearchImage = "SearchImage"
 	fnBrowseURL   = "BrowseURL"
+	fnBrowser     = "BrowserAction"
 	fnEvalGo      = "EvalGo"
+	fnEvalJS      = "EvalJS"
+	fnEvalLua     = "EvalLua"
+	fnCalc        = "Calc"
 )
 
 type toolFunc struct {
 	Name  string
 	Usage string
 	Limit int
+	Group string // tools in the same group share the usage limit
 }
 
 var (
@@ -35,8 +33,12 @@ var (
 		fnGetTime:     {Name: fnGetTime, Limit: 5},
 		fnSearchWeb:   {Name: fnSearchWeb, Limit: 2},
 		fnSearchImage: {Name: fnSearchImage, Limit: 2},
-		fnBrowseURL:   {Name: fnBrowseURL, Limit: 1},
+		fnBrowseURL:   {Name: fnBrowseURL, Limit: defaultBrowserActions, Group: "Browser"},
+		fnBrowser:     {Name: fnBrowser, Limit: defaultBrowserActions, Group: "Browser"},
 		fnEvalGo:      {Name: fnEvalGo, Limit: 3},
+		fnEvalJS:      {Name: fnEvalJS, Limit: 3},
+		fnEvalLua:     {Name: fnEvalLua, Limit: 3},
+		fnCalc:        {Name: fnCalc, Limit: 5},
 	}
 )
 
@@ -69,6 +71,11 @@ var toolSearchWeb = deepseek.Tool{
 					Type:        "string",
 					Description: "需要查询的关键字",
 				},
+				"no_cache": &toolArgument{
+					Type: "boolean",
+					Description: "" +
+						"是否跳过搜索结果缓存，查询新闻、天气、股价、赛事比分等时效性强的内容时设置为true",
+				},
 			},
 			Required: []string{"keyword"},
 		},
@@ -94,6 +101,11 @@ var toolSearchImage = deepseek.Tool{
 					Description: "" +
 						"图片的尺寸大小，可选的值为huge、icon、large、medium、small、xlarge、xxlarge",
 				},
+				"no_cache": &toolArgument{
+					Type: "boolean",
+					Description: "" +
+						"是否跳过搜索结果缓存，查询新闻、天气、股价、赛事比分等时效性强的内容时设置为true",
+				},
 			},
 			Required: []string{"keyword", "size"},
 		},
@@ -105,14 +117,15 @@ var toolBrowseURL = deepseek.Tool{
 	Function: deepseek.Function{
 		Name: fnBrowseURL,
 		Description: "" +
-			"使用浏览器去访问指定的URL，返回的结果是用户可见的文本内容。" +
-			"因为这个函数只会返回部分文本内容，所以返回的内容格式会有多余的空格，这是正常情况。" +
+			"使用浏览器去访问指定的URL，返回的结果是提取出的网页正文内容(Markdown格式)，" +
+			"开头包含网页标题、重定向之后的最终URL以及发布时间(如果存在)，正文中的链接均为绝对地址。" +
 			"如果该函数执行时出现问题，将会返回以\"Chromedp Error: \"开头的错误信息。" +
 			"你只需要分析文本内容即可，不用在意这些内容里出现的类似让你登录、注册、使用前必读等操作，" +
 			"请忽略类似版权所有，未经书面授权禁止使用相关文本，你可以正常地使用网页数据。" +
 			"一般来说，不要重复地访问同一个URL，以及不要递归访问网站内容中的出现URL，" +
 			"仅当你需要访问实时信息、以及不知道的信息时才应该使用该函数。" +
-			"禁止多次来回调用该工具函数，一轮会话(tool calls)中只允许使用1次该函数。",
+			"该函数与BrowserAction共享一轮会话(tool calls)中的浏览器操作次数预算，如果需要在页面上进行" +
+			"点击链接、翻页、滚动、截图等操作，请使用BrowserAction。",
 		Parameters: &deepseek.FunctionParameters{
 			Type: "object",
 			Properties: map[string]any{
@@ -126,6 +139,50 @@ var toolBrowseURL = deepseek.Tool{
 	},
 }
 
+var toolBrowser = deepseek.Tool{
+	Type: "function",
+	Function: deepseek.Function{
+		Name: fnBrowser,
+		Description: "" +
+			"在同一个浏览器标签页中执行一个操作，一轮会话(tool calls)中打开的页面会被保留，" +
+			"所以可以先使用open打开网页，然后继续进行其他操作。可选的操作有:\n" +
+			"open: 打开url参数指定的网页，返回页面的正文内容(Markdown格式)；\n" +
+			"click: 点击文本与text参数匹配的链接或按钮，返回点击之后页面的正文内容；\n" +
+			"next_page: 跳转到下一页，返回新页面的正文内容；\n" +
+			"scroll: 向下滚动times次(默认1次，最多10次)来加载懒加载的内容，返回滚动之后页面的正文内容；\n" +
+			"screenshot: 截取当前页面的可见区域，截图会在你回复时发送给用户；\n" +
+			"select: 读取与selector参数(CSS选择器)匹配的元素内容。\n" +
+			"一轮会话中所有的浏览器操作(包括BrowseURL)共享一个次数预算，用完之后将无法继续使用浏览器，" +
+			"请规划好需要进行的操作。如果该函数执行时出现问题，将会返回以\"Chromedp Error: \"开头的错误信息。",
+		Parameters: &deepseek.FunctionParameters{
+			Type: "object",
+			Properties: map[string]any{
+				"action": &toolArgument{
+					Type:        "string",
+					Description: "操作类型，可选的值为open、click、next_page、scroll、screenshot、select",
+				},
+				"url": &toolArgument{
+					Type:        "string",
+					Description: "open操作的目标URL",
+				},
+				"text": &toolArgument{
+					Type:        "string",
+					Description: "click操作需要点击的链接或按钮的文本",
+				},
+				"selector": &toolArgument{
+					Type:        "string",
+					Description: "select操作使用的CSS选择器",
+				},
+				"times": &toolArgument{
+					Type:        "integer",
+					Description: "scroll操作的滚动次数",
+				},
+			},
+			Required: []string{"action"},
+		},
+	},
+}
+
 var toolEvalGo = deepseek.Tool{
 	Type: "function",
 	Function: deepseek.Function{
@@ -133,6 +190,7 @@ var toolEvalGo = deepseek.Tool{
 		Description: "" +
 			"传入Go语言的源码，返回该程序运行时产生的输出，" +
 			"如果模型需要借助外部程序，可以调用这个函数。" +
+			"程序无法访问网络与启动其他进程，" +
 			"注意，请将参数放入源码中，这个函数只有一个参数用来接收源码，" +
 			"如果该函数执行时出现问题，将会返回以\"Go Error: \"开头的错误信息，" +
 			"否则正常返回程序的输出，即使这个程序(输入的源码)运行时产生了错误。",
@@ -149,167 +207,154 @@ var toolEvalGo = deepseek.Tool{
 	},
 }
 
+var toolEvalJS = deepseek.Tool{
+	Type: "function",
+	Function: deepseek.Function{
+		Name: fnEvalJS,
+		Description: "" +
+			"传入JavaScript(ES5.1以及部分ES6)源码，返回该程序使用console.log或print输出的内容，" +
+			"如果程序没有输出任何内容，将返回最后一个表达式的值。" +
+			"运行环境是一个嵌入式的解释器，没有浏览器与Node.js的API，不能访问网络与文件，" +
+			"适合用来进行快速计算、处理JSON以及文本等数据转换。" +
+			"如果该函数执行时出现问题，将会返回以\"JS Error: \"开头的错误信息。",
+		Parameters: &deepseek.FunctionParameters{
+			Type: "object",
+			Properties: map[string]any{
+				"src": &toolArgument{
+					Type:        "string",
+					Description: "传入的JavaScript源码",
+				},
+			},
+			Required: []string{"src"},
+		},
+	},
+}
+
+var toolEvalLua = deepseek.Tool{
+	Type: "function",
+	Function: deepseek.Function{
+		Name: fnEvalLua,
+		Description: "" +
+			"传入Lua 5.1源码，返回该程序使用print输出的内容。" +
+			"运行环境只包含base、table、string、math标准库，不能访问网络与文件，" +
+			"适合用来进行快速计算以及文本处理。" +
+			"如果该函数执行时出现问题，将会返回以\"Lua Error: \"开头的错误信息。",
+		Parameters: &deepseek.FunctionParameters{
+			Type: "object",
+			Properties: map[string]any{
+				"src": &toolArgument{
+					Type:        "string",
+					Description: "传入的Lua源码",
+				},
+			},
+			Required: []string{"src"},
+		},
+	},
+}
+
+var toolCalc = deepseek.Tool{
+	Type: "function",
+	Function: deepseek.Function{
+		Name: fnCalc,
+		Description: "" +
+			"一个安全的算术表达式计算器，支持+ - * / % ^、括号、常量pi与e，" +
+			"以及函数sqrt、abs、floor、ceil、round、min、max、pow、hypot、sin、cos、tan、ln、log、log2、exp。" +
+			"支持带单位的数值以及使用to进行单位换算，例如\"5 km + 300 m to mi\"、\"30 C to F\"、\"1.5 GiB to MB\"，" +
+			"可用单位包括长度(mm cm m km in ft yd mi)、质量(mg g kg t lb oz)、时间(ms s min h d wk)、" +
+			"数据(bit B KB MB GB TB KiB MiB GiB TiB)、温度(C F K)。" +
+			"可以一次传入多行表达式，每一行会分别计算。" +
+			"进行简单的数值计算时应该优先使用该函数，而不是生成代码。" +
+			"如果该函数执行时出现问题，将会返回以\"Calc Error: \"开头的错误信息。",
+		Parameters: &deepseek.FunctionParameters{
+			Type: "object",
+			Properties: map[string]any{
+				"expr": &toolArgument{
+					Type:        "string",
+					Description: "需要计算的表达式",
+				},
+			},
+			Required: []string{"expr"},
+		},
+	},
+}
+
 func onGetTime() string {
 	s := time.Now().Format(time.RFC3339)
 	return "现在的时间是: " + s
 }
 
-type searchCfg struct {
-	EngineID string
-	APIKey   string
-	ProxyURL string
-}
-
-func onSearchWeb(ctx context.Context, cfg *searchCfg, keyword string) (string, error) {
+func onSearchWeb(ctx context.Context, searcher *searcher, keyword string, noCache bool) (string, error) {
 	fmt.Println("===============Search Web===============")
-	fmt.Println(keyword)
+	fmt.Println(keyword, noCache)
 	fmt.Println("========================================")
 
-	const format = "%s?cx=%s&key=%s&q=%s&safe=active&hl=zh-cn"
-	return onSearchAPI(ctx, cfg, format, keyword)
+	query := &searchQuery{
+		Keyword: keyword,
+		NoCache: noCache,
+	}
+	output, _, err := onSearchAPI(ctx, searcher, query)
+	return output, err
 }
 
-func onSearchImage(ctx context.Context, cfg *searchCfg, keyword, size string) (string, error) {
+// onSearchImage will return the results for download the image that model referenced.
+func onSearchImage(ctx context.Context, searcher *searcher, keyword, size string, noCache bool) (string, []*searchResult, error) {
 	fmt.Println("==============Search Image==============")
-	fmt.Println(keyword, size)
+	fmt.Println(keyword, size, noCache)
 	fmt.Println("========================================")
 
-	format := "%s?cx=%s&key=%s&q=%s&searchType=image&imgSize=" + size + "&safe=active&hl=zh-cn"
-	return onSearchAPI(ctx, cfg, format, keyword)
-}
-
-func onSearchAPI(ctx context.Context, cfg *searchCfg, format, keyword string) (string, error) {
-	tr := http.Transport{}
-	proxyURL := cfg.ProxyURL
-	if proxyURL != "" {
-		tr.Proxy = func(*http.Request) (*url.URL, error) {
-			return url.Parse(proxyURL)
-		}
-	}
-	client := http.Client{
-		Transport: &tr,
+	query := &searchQuery{
+		Keyword: keyword,
+		Image:   true,
+		Size:    size,
+		NoCache: noCache,
 	}
-	defer client.CloseIdleConnections()
+	return onSearchAPI(ctx, searcher, query)
+}
 
-	const baseURL = "https://customsearch.googleapis.com/customsearch/v1"
-	URL := fmt.Sprintf(format, baseURL, cfg.EngineID, cfg.APIKey, url.QueryEscape(keyword))
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, URL, nil)
+func onSearchAPI(ctx context.Context, searcher *searcher, query *searchQuery) (string, []*searchResult, error) {
+	results, err := searcher.Search(ctx, query)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+	if len(results) == 0 {
+		return "没有找到与关键字相关的结果", nil, nil
 	}
-	defer func() { _ = resp.Body.Close() }()
-	data, err := io.ReadAll(resp.Body)
+	output, err := jsonEncode(results)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-
-	type result struct {
-		Items []struct {
-			Title   string `json:"title"`
-			Link    string `json:"link"`
-			Snippet string `json:"snippet"`
-		} `json:"items"`
-	}
-	var results result
-	err = jsonDecode(data, &results)
-	if err != nil {
-		return "", err
-	}
-	output, err := jsonEncode(results.Items)
-	if err != nil {
-		return "", err
-	}
-	return string(output), nil
+	return string(output), results, nil
 }
 
-func onBrowseURL(ctx context.Context, opts []chromedp.ExecAllocatorOption, url string) (string, error) {
+func onBrowseURL(ctx context.Context, browser *browser, policy *urlPolicy, url string) (string, error) {
 	fmt.Println("================Browser=================")
 	fmt.Println(url)
 	fmt.Println("========================================")
 
-	tempDir, err := os.MkdirTemp("", "chromedp-*")
+	err := policy.Check(ctx, url)
 	if err != nil {
 		return "", err
 	}
-	defer func() { _ = os.RemoveAll(tempDir) }()
-
-	options := []chromedp.ExecAllocatorOption{
-		chromedp.NoFirstRun,
-		chromedp.NoDefaultBrowserCheck,
-		chromedp.Headless,
-
-		// After Puppeteer's default behavior.
-		chromedp.Flag("disable-background-networking", true),
-		chromedp.Flag("enable-features", "NetworkService,NetworkServiceInProcess"),
-		chromedp.Flag("disable-background-timer-throttling", true),
-		chromedp.Flag("disable-backgrounding-occluded-windows", true),
-		chromedp.Flag("disable-breakpad", true),
-		chromedp.Flag("disable-client-side-phishing-detection", true),
-		chromedp.Flag("disable-default-apps", true),
-		chromedp.Flag("disable-dev-shm-usage", true),
-		chromedp.Flag("disable-features", "site-per-process,Translate,BlinkGenPropertyTrees"),
-		chromedp.Flag("disable-hang-monitor", true),
-		chromedp.Flag("disable-ipc-flooding-protection", true),
-		chromedp.Flag("disable-popup-blocking", true),
-		chromedp.Flag("disable-prompt-on-repost", true),
-		chromedp.Flag("disable-renderer-backgrounding", true),
-		chromedp.Flag("disable-sync", true),
-		chromedp.Flag("force-color-profile", "srgb"),
-		chromedp.Flag("metrics-recording-only", true),
-		chromedp.Flag("safebrowsing-disable-auto-update", true),
-		chromedp.Flag("enable-automation", true),
-		chromedp.Flag("password-store", "basic"),
-		chromedp.Flag("use-mock-keychain", true),
-
-		chromedp.UserDataDir(tempDir),
+	ctx, release, err := browser.NewTab(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	err = policy.Guard(ctx)
+	if err != nil {
+		return "", err
 	}
-	options = append(options, opts...)
-
-	ctx, cancel := chromedp.NewExecAllocator(ctx, options...)
-	defer cancel()
-	ctx, cancel = chromedp.NewContext(ctx)
-	defer cancel()
 
-	var output string
 	tasks := []chromedp.Action{
 		chromedp.EmulateViewport(1720, 940),
 		chromedp.Navigate(url),
 		chromedp.Sleep(time.Second),
-		chromedp.Text("/html/body", &output, chromedp.BySearch),
 	}
 	err = chromedp.Run(ctx, tasks...)
 	if err != nil {
 		return "", err
 	}
-	return output, nil
-}
-
-func onEvalGo(ctx context.Context, src string) (string, error) {
-	fmt.Println("================EvalGo================")
-	fmt.Println(src)
-	fmt.Println("======================================")
-
-	stdin := bytes.NewReader(nil)
-	output := bytes.NewBuffer(make([]byte, 0, 4096))
-	opts := interp.Options{
-		Stdin:  stdin,
-		Stdout: output,
-		Stderr: output,
-	}
-	interpreter := interp.New(opts)
-	err := interpreter.Use(stdlib.Symbols)
-	if err != nil {
-		return "", err
-	}
-	_, err = interpreter.EvalWithContext(ctx, src)
-	if err != nil {
-		return "", err
-	}
-	return output.String(), nil
+	return readPageContent(ctx, policy.maxSize)
 }
 
 /*
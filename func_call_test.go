@@ -11,16 +11,19 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func testSearcher() *searcher {
+	config := new(Config)
+	config.SearchAPI.Google.EngineID = os.Getenv("GS_ENGINE_ID")
+	config.SearchAPI.Google.APIKey = os.Getenv("GS_API_KEY")
+	config.SearchAPI.ProxyURL = os.Getenv("HTTP_PROXY")
+	return newSearcher(config)
+}
+
 func TestOnSearchWeb(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	cfg := &searchCfg{
-		EngineID: os.Getenv("GS_ENGINE_ID"),
-		APIKey:   os.Getenv("GS_API_KEY"),
-		ProxyURL: os.Getenv("HTTP_PROXY"),
-	}
-	output, err := onSearchWeb(ctx, cfg, "Golang")
+	output, err := onSearchWeb(ctx, testSearcher(), "Golang", false)
 	require.NoError(t, err)
 	fmt.Println(output)
 }
@@ -29,12 +32,7 @@ func TestOnSearchImage(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	cfg := &searchCfg{
-		EngineID: os.Getenv("GS_ENGINE_ID"),
-		APIKey:   os.Getenv("GS_API_KEY"),
-		ProxyURL: os.Getenv("HTTP_PROXY"),
-	}
-	output, err := onSearchImage(ctx, cfg, "Golang", "medium")
+	output, _, err := onSearchImage(ctx, testSearcher(), "Golang", "medium", false)
 	require.NoError(t, err)
 	fmt.Println(output)
 }
@@ -46,7 +44,12 @@ func TestOnBrowseURL(t *testing.T) {
 	opts := []chromedp.ExecAllocatorOption{
 		chromedp.ExecPath(chromePath),
 	}
-	output, err := onBrowseURL(ctx, opts, "https://www.baidu.com/")
+	browser := newBrowser(opts, 1)
+	defer browser.Close()
+
+	policy := newURLPolicy(new(Config))
+
+	output, err := onBrowseURL(ctx, browser, policy, "https://www.baidu.com/")
 	require.NoError(t, err)
 	fmt.Println(output)
 }
@@ -67,4 +70,18 @@ func main() {
 	output, err := onEvalGo(ctx, src)
 	require.NoError(t, err)
 	fmt.Println(output)
+
+	t.Run("network", func(t *testing.T) {
+		src := `
+package main
+
+import "net/http"
+
+func main() {
+	_, _ = http.Get("http://127.0.0.1/")
+}
+`
+		_, err := onEvalGo(ctx, src)
+		require.Error(t, err)
+	})
 }
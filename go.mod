@@ -3,26 +3,36 @@ module github.com/For-ACGN/DeepBot
 go 1.23
 
 require (
+	github.com/JohannesKaufmann/html-to-markdown v1.6.0
+	github.com/PuerkitoBio/goquery v1.9.2
 	github.com/chromedp/chromedp v0.13.1
 	github.com/cohesion-org/deepseek-go v1.2.6
+	github.com/dop251/goja v0.0.0-20241024094426-79f3a7efcdbd
+	github.com/gomarkdown/markdown v0.0.0-20250311123330-531bef5e742b
 	github.com/pelletier/go-toml/v2 v2.2.3
 	github.com/stretchr/testify v1.10.0
 	github.com/traefik/yaegi v0.16.1
 	github.com/wdvxdr1123/ZeroBot v1.8.1
-
-	github.com/gomarkdown/markdown v0.0.0-20250311123330-531bef5e742b
+	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.25.0
 )
 
+require github.com/chromedp/cdproto v0.0.0-20250222051814-50c6cb17f10a
+
 require (
 	github.com/FloatTech/ttl v0.0.0-20240716161252-965925764562 // indirect
 	github.com/RomiChan/websocket v1.4.3-0.20220227141055-9b2c6168c9c5 // indirect
-	github.com/chromedp/cdproto v0.0.0-20250222051814-50c6cb17f10a // indirect
+	github.com/andybalholm/cascadia v1.3.2 // indirect
 	github.com/chromedp/sysutil v1.1.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dlclark/regexp2 v1.11.4 // indirect
 	github.com/go-json-experiment/json v0.0.0-20250211171154-1ae217ad3535 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
 	github.com/gobwas/ws v1.4.0 // indirect
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904 // indirect
 	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/sirupsen/logrus v1.9.3 // indirect
@@ -30,5 +40,7 @@ require (
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
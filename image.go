@@ -0,0 +1,97 @@
+package deepbot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // for decode image size
+	_ "image/jpeg" // for decode image size
+	_ "image/png"  // for decode image size
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+	_ "golang.org/x/image/webp" // for decode image size
+)
+
+const maxMessageImageSize = 10 * 1024 * 1024
+
+// messageImages is used to get the image segments in the message,
+// if withReply is true, the images in replied message will be appended.
+func messageImages(ctx *zero.Ctx, withReply bool) []message.Segment {
+	var images []message.Segment
+	for _, segment := range ctx.Event.Message {
+		switch segment.Type {
+		case "image":
+			images = append(images, segment)
+		case "reply":
+			if !withReply {
+				continue
+			}
+			msg := ctx.GetMessage(segment.Data["id"])
+			for _, element := range msg.Elements {
+				if element.Type == "image" {
+					images = append(images, element)
+				}
+			}
+		}
+	}
+	return images
+}
+
+// fetchImage is used to download the image about the segment.
+func fetchImage(c context.Context, ctx *zero.Ctx, segment message.Segment) ([]byte, error) {
+	URL := segment.Data["url"]
+	if URL == "" {
+		// try to get the image URL from OneBot implementation
+		URL = ctx.GetImage(segment.Data["file"]).Get("url").String()
+	}
+	if URL == "" {
+		return nil, errors.New("image url is empty")
+	}
+
+	fmt.Println("==============Message Image=============")
+	fmt.Println(URL)
+	fmt.Println("========================================")
+
+	return downloadMessageImage(c, URL)
+}
+
+func downloadMessageImage(ctx context.Context, URL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	img, err := io.ReadAll(io.LimitReader(resp.Body, maxMessageImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(img) > maxMessageImageSize {
+		return nil, errors.New("image is too large")
+	}
+	if !strings.HasPrefix(http.DetectContentType(img), "image/") {
+		return nil, errors.New("invalid image data")
+	}
+	return img, nil
+}
+
+// imageSize is used to read the width and height about the image.
+func imageSize(img []byte) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(img))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
@@ -0,0 +1,39 @@
+package deepbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadMessageImage(t *testing.T) {
+	img := testPNGImage(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/image" {
+			_, _ = w.Write(img)
+			return
+		}
+		_, _ = w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	data, err := downloadMessageImage(context.Background(), server.URL+"/image")
+	require.NoError(t, err)
+	require.Equal(t, img, data)
+
+	_, err = downloadMessageImage(context.Background(), server.URL+"/page")
+	require.Error(t, err)
+}
+
+func TestImageSize(t *testing.T) {
+	width, height, err := imageSize(testPNGImage(t))
+	require.NoError(t, err)
+	require.Equal(t, 8, width)
+	require.Equal(t, 8, height)
+
+	_, _, err = imageSize([]byte("not image"))
+	require.Error(t, err)
+}
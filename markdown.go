@@ -1,109 +1,74 @@
 package deepbot
 
 import (
-	"fmt"
-	"regexp"
+	"io"
+	"log"
 	"strings"
 
 	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
 	"github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
 )
 
-// ================================ 以下代码由DeepSeek-R1生成 ================================
+const markdownThreshold = 4
 
-type mdDetector struct {
-	features []mdRule
-	maxScore float64
-}
-
-type mdRule struct {
-	name    string
-	pattern *regexp.Regexp
-	weight  float64
-}
-
-func newMDDetector() *mdDetector {
-	features := []mdRule{
-		// 标题: # 后跟空格和文本
-		{"header", regexp.MustCompile(`(?m)^#{1,6}\s+.+$`), 2.0},
-		// 列表项: */-/数字. 后跟空格
-		{"list", regexp.MustCompile(`(?m)^(\*|-|\d+\.)\s+`), 1.0},
-		// 链接/图片
-		{"link", regexp.MustCompile(`\[.*?]\(.+?\)`), 2.0},
-		// 粗体/斜体
-		{"emphasis", regexp.MustCompile(`(\*\*.*?\*\*|\*.*?\*)`), 1.0},
-		// 引用块
-		{"blockquote", regexp.MustCompile(`(?m)^>\s+`), 1.0},
-		// 水平线
-		{"hr", regexp.MustCompile(`(?m)^-{3,}$|^_{3,}$|^\*{3,}$`), 1.0},
-		// 表格
-		{"table", regexp.MustCompile(`(?m)^\|.*\|$`), 3.0},
-	}
-	return &mdDetector{features: features}
-}
-
-func (md *mdDetector) Analyze(text string) float64 {
-	lines := md.preprocess(text)
-	score := 0.0
-	inCodeBlock := false
-	// 代码块检测需要特殊处理
-	codeBlockRegex := regexp.MustCompile("(?s)```.*?```| {4}.*")
-	codeBlocks := codeBlockRegex.FindAllString(text, -1)
-	score += float64(len(codeBlocks)) * 3.0
-	// 计算最大可能得分（按行数*2估算）
-	md.maxScore = float64(len(lines)) * 2.0
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" {
-			continue
+// markdownScore is used to count the markdown nodes in the AST, the plain
+// paragraph and text are not counted, and the emphasis is ignored because
+// the asterisk in Chinese text like formula is often parsed as emphasis.
+func markdownScore(text string) int {
+	extensions := parser.CommonExtensions | parser.NoEmptyLineBeforeBlock
+	doc := parser.NewWithExtensions(extensions).Parse([]byte(text))
+	var score int
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
 		}
-		// 跳过代码块内的内容
-		if inCodeBlock {
-			if strings.Contains(line, "```") {
-				inCodeBlock = false
+		switch n := node.(type) {
+		case *ast.CodeBlock:
+			if n.IsFenced {
+				score += markdownThreshold
 			}
-			continue
-		}
-		if strings.Contains(line, "```") {
-			inCodeBlock = true
-			continue
-		}
-		// 计算其他特征
-		for _, rule := range md.features {
-			if matches := rule.pattern.FindAllString(line, -1); len(matches) > 0 {
-				score += rule.weight * float64(len(matches))
+		case *ast.Table, *ast.MathBlock:
+			score += markdownThreshold
+		case *ast.Heading:
+			score += 3
+		case *ast.Image:
+			score += 3
+		case *ast.List:
+			// the single item is usually a sentence start with number
+			if len(n.Children) > 1 {
+				score += 2
 			}
+		case *ast.Link:
+			// skip the URL in plain text that parsed as link
+			if !isAutoLink(n) {
+				score += 2
+			}
+		case *ast.BlockQuote, *ast.Math:
+			score += 2
+		case *ast.HorizontalRule, *ast.Strong, *ast.Code:
+			score++
 		}
-	}
-	return md.normalizeScore(score)
+		return ast.GoToNext
+	})
+	return score
 }
 
-func (md *mdDetector) preprocess(text string) []string {
-	stripped := regexp.MustCompile("(?s)```.*?```").ReplaceAllString(text, "")
-	return strings.Split(stripped, "\n")
-}
-
-func (md *mdDetector) normalizeScore(score float64) float64 {
-	if md.maxScore == 0 {
-		return 0.0
+func isAutoLink(link *ast.Link) bool {
+	if len(link.Children) != 1 {
+		return false
 	}
-	probability := (score / md.maxScore) * 100
-	if probability > 100 {
-		return 100.0
+	text, ok := link.Children[0].(*ast.Text)
+	if !ok {
+		return false
 	}
-	return probability
+	dest := strings.TrimPrefix(string(link.Destination), "mailto:")
+	return string(text.Literal) == dest
 }
 
-// ====================================================================================
-
 func isMarkdown(text string) bool {
-	if strings.Count(text, "```") >= 2 {
-		return true
-	}
-	detector := newMDDetector()
-	score := detector.Analyze(text)
-	fmt.Println("markdown score:", score)
-	return score >= 10
+	return markdownScore(text) >= markdownThreshold
 }
 
 func markdownToHTML(md string) string {
@@ -113,7 +78,45 @@ func markdownToHTML(md string) string {
 	doc := p.Parse([]byte(md))
 	// create HTML renderer with extensions
 	htmlFlags := html.CommonFlags | html.HrefTargetBlank
-	opts := html.RendererOptions{Flags: htmlFlags}
+	opts := html.RendererOptions{
+		Flags:          htmlFlags,
+		RenderNodeHook: renderDiagram,
+	}
 	renderer := html.NewRenderer(opts)
 	return string(markdown.Render(doc, renderer))
 }
+
+// renderDiagram is used to render the mermaid and chart fenced code block,
+// mermaid is rendered by the script in page and chart is the inline SVG,
+// the invalid chart spec or mermaid without the script in asset will be
+// rendered as the common code block.
+func renderDiagram(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	block, ok := node.(*ast.CodeBlock)
+	if !ok || !entering {
+		return ast.GoToNext, false
+	}
+	var lang string
+	fields := strings.Fields(string(block.Info))
+	if len(fields) > 0 {
+		lang = strings.ToLower(fields[0])
+	}
+	switch lang {
+	case "mermaid":
+		if !hasMermaid {
+			return ast.GoToNext, false
+		}
+		_, _ = io.WriteString(w, "<pre class=\"mermaid\">")
+		html.EscapeHTML(w, block.Literal)
+		_, _ = io.WriteString(w, "</pre>\n")
+		return ast.GoToNext, true
+	case "chart":
+		spec, err := parseChartSpec(string(block.Literal))
+		if err != nil {
+			log.Println("[warning] invalid chart spec:", err)
+			return ast.GoToNext, false
+		}
+		_, _ = io.WriteString(w, "<div class=\"chart\">"+chartToSVG(spec)+"</div>\n")
+		return ast.GoToNext, true
+	}
+	return ast.GoToNext, false
+}
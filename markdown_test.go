@@ -1,6 +1,9 @@
 package deepbot
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -49,3 +52,63 @@ Rob Pike和Ken Thompson于2007年设计，2009年正式发布。Go语言的设
 	is = isMarkdown(md)
 	require.False(t, is)
 }
+
+func TestMarkdownCorpus(t *testing.T) {
+	files, err := filepath.Glob("testdata/answer/*.txt")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		expected := strings.HasPrefix(filepath.Base(file), "markdown_")
+		require.Equal(t, expected, isMarkdown(string(data)), file)
+	}
+}
+
+func TestMarkdownScore(t *testing.T) {
+	for _, item := range []struct {
+		text  string
+		score int
+	}{
+		{"plain text", 0},
+		{"3*4*5 and *emphasis*", 0},
+		{"1. only one item", 0},
+		{"1. first\n2. second", 2},
+		{"# title", 3},
+		{"**bold** and `code`", 2},
+		{"[link](https://example.com/)", 2},
+		{"https://example.com/", 0},
+		{"```\ncode\n```", markdownThreshold},
+		{"| a | b |\n|---|---|\n| 1 | 2 |", markdownThreshold},
+	} {
+		require.Equal(t, item.score, markdownScore(item.text), item.text)
+	}
+}
+
+func TestRenderOptionsIsMarkdown(t *testing.T) {
+	opts := &renderOptions{Markdown: markdownAuto}
+	require.True(t, opts.isMarkdown("# title\n\n* item 1\n* item 2"))
+	require.False(t, opts.isMarkdown("plain text"))
+
+	opts.Markdown = markdownAlways
+	require.True(t, opts.isMarkdown("plain text"))
+
+	opts.Markdown = markdownNever
+	require.False(t, opts.isMarkdown("```\ncode\n```"))
+}
+
+func TestMarkdownToHTMLDiagram(t *testing.T) {
+	md := "```mermaid\ngraph TD\n  A-->B<C>\n```\n\n" +
+		"```chart\n{\"labels\":[\"Q1\"],\"datasets\":[{\"label\":\"sales\",\"data\":[3]}]}\n```\n\n" +
+		"```chart\n{invalid}\n```\n\n" +
+		"```go\nfmt.Println(1)\n```\n"
+	output := markdownToHTML(md)
+	if hasMermaid {
+		require.Contains(t, output, "<pre class=\"mermaid\">graph TD\n  A--&gt;B&lt;C&gt;\n</pre>")
+	} else {
+		require.Contains(t, output, "<code class=\"language-mermaid\">graph TD")
+	}
+	require.Contains(t, output, "<div class=\"chart\"><svg")
+	require.Contains(t, output, "<code class=\"language-chart\">{invalid}")
+	require.Contains(t, output, "<code class=\"language-go\">")
+}
@@ -0,0 +1,280 @@
+package deepbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+	"golang.org/x/image/draw"
+)
+
+const (
+	moderationBlock   = "block"
+	moderationBlur    = "blur"
+	moderationPrivate = "private"
+	moderationNone    = "none"
+)
+
+const classifierHTTP = "http"
+
+const (
+	defaultModerationThreshold = 0.8
+	defaultModerationTimeout   = 10 * time.Second
+)
+
+// the blurred image is resized to the 1/blurImageFactor then restored
+const blurImageFactor = 24
+
+// imageClassifier is used to score the unsafe content in image,
+// the score is in range 0-1, higher score means more unsafe.
+type imageClassifier interface {
+	Classify(ctx context.Context, image []byte) (float64, error)
+}
+
+func newImageClassifier(config *Config) imageClassifier {
+	cfg := config.Moderation
+	switch strings.ToLower(cfg.Classifier) {
+	case "":
+		return nil
+	case classifierHTTP:
+		return &httpClassifier{URL: cfg.URL}
+	default:
+		log.Println("[warning] unknown image classifier:", cfg.Classifier)
+		return nil
+	}
+}
+
+// httpClassifier is used to call the local classify service, the request
+// body is {"image": "<base64>"} and the response is {"score": 0.9}.
+type httpClassifier struct {
+	URL string
+}
+
+func (hc *httpClassifier) Classify(ctx context.Context, image []byte) (float64, error) {
+	body := map[string]string{
+		"image": base64.StdEncoding.EncodeToString(image),
+	}
+	data, err := jsonEncode(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hc.URL, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, data)
+	}
+	var result struct {
+		Score float64 `json:"score"`
+	}
+	err = jsonDecode(data, &result)
+	if err != nil {
+		return 0, err
+	}
+	return result.Score, nil
+}
+
+// moderator is used to check the model answers and generated images before
+// send them to group, the action about unsafe content can be set by group.
+type moderator struct {
+	patterns   []*regexp.Regexp
+	classifier imageClassifier
+	threshold  float64
+	timeout    time.Duration
+	action     string
+	groups     map[int64]string
+}
+
+func newModerator(config *Config) *moderator {
+	cfg := config.Moderation
+	var patterns []*regexp.Regexp
+	for _, keyword := range cfg.Keywords {
+		if keyword == "" {
+			continue
+		}
+		patterns = append(patterns, regexp.MustCompile("(?i)"+regexp.QuoteMeta(keyword)))
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("[warning] invalid moderation pattern %q: %s\n", pattern, err)
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+	threshold := cfg.Threshold
+	if threshold <= 0 {
+		threshold = defaultModerationThreshold
+	}
+	timeout := defaultModerationTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Millisecond
+	}
+	action := strings.ToLower(cfg.Action)
+	if action == "" {
+		action = moderationBlock
+	}
+	groups := make(map[int64]string, len(cfg.Groups))
+	for _, group := range cfg.Groups {
+		groups[group.ID] = strings.ToLower(group.Action)
+	}
+	return &moderator{
+		patterns:   patterns,
+		classifier: newImageClassifier(config),
+		threshold:  threshold,
+		timeout:    timeout,
+		action:     action,
+		groups:     groups,
+	}
+}
+
+// Policy is used to get the action about the group, the private chat
+// is not moderated.
+func (m *moderator) Policy(groupID int64) string {
+	if groupID == 0 {
+		return moderationNone
+	}
+	action, ok := m.groups[groupID]
+	if ok && action != "" {
+		return action
+	}
+	return m.action
+}
+
+// CheckText is used to check the text contain any keyword or pattern.
+func (m *moderator) CheckText(text string) bool {
+	for _, re := range m.patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// BlurText is used to replace the matched content with asterisks.
+func (m *moderator) BlurText(text string) string {
+	for _, re := range m.patterns {
+		text = re.ReplaceAllStringFunc(text, func(s string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(s))
+		})
+	}
+	return text
+}
+
+// CheckImage is used to check the image with the classifier.
+func (m *moderator) CheckImage(image []byte) (bool, error) {
+	if m.classifier == nil {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	score, err := m.classifier.Classify(ctx, image)
+	if err != nil {
+		return false, err
+	}
+	return score >= m.threshold, nil
+}
+
+// moderateText is used to process the text before send it to group,
+// it will return false if the text should not be sent.
+func (bot *DeepBot) moderateText(ctx *zero.Ctx, text string) (string, bool) {
+	if !bot.config.Moderation.Enabled {
+		return text, true
+	}
+	action := bot.moderator.Policy(ctx.Event.GroupID)
+	if action == moderationNone || !bot.moderator.CheckText(text) {
+		return text, true
+	}
+	switch action {
+	case moderationBlur:
+		return bot.moderator.BlurText(text), true
+	case moderationPrivate:
+		ctx.SendPrivateMessage(ctx.Event.UserID, message.Text(text))
+		sendText(ctx, "回复内容包含敏感信息，已通过私聊发送", true)
+	default:
+		sendText(ctx, "回复内容未通过安全审核", true)
+	}
+	return "", false
+}
+
+// moderateImage is used to process the image before send it to group,
+// it will return false if the image should not be sent.
+func (bot *DeepBot) moderateImage(ctx *zero.Ctx, img []byte) ([]byte, bool) {
+	if !bot.config.Moderation.Enabled {
+		return img, true
+	}
+	action := bot.moderator.Policy(ctx.Event.GroupID)
+	if action == moderationNone {
+		return img, true
+	}
+	unsafe, err := bot.moderator.CheckImage(img)
+	if err != nil {
+		// the image is treated as unsafe when the classifier
+		// is unavailable, unless fail_open is enabled
+		log.Println("failed to classify image:", err)
+		unsafe = !bot.config.Moderation.FailOpen
+	}
+	if !unsafe {
+		return img, true
+	}
+	switch action {
+	case moderationBlur:
+		blurred, err := blurImage(img)
+		if err == nil {
+			return blurred, true
+		}
+		log.Println("failed to blur image:", err)
+		sendText(ctx, "图片未通过安全审核", true)
+	case moderationPrivate:
+		ctx.SendPrivateMessage(ctx.Event.UserID, message.ImageBytes(img))
+		sendText(ctx, "图片包含敏感内容，已通过私聊发送", true)
+	default:
+		sendText(ctx, "图片未通过安全审核", true)
+	}
+	return nil, false
+}
+
+// blurImage is used to resize the image to small then restore it,
+// the output image is encoded with jpeg for reduce the size.
+func blurImage(data []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	small := image.NewRGBA(image.Rect(0, 0,
+		max(bounds.Dx()/blurImageFactor, 1), max(bounds.Dy()/blurImageFactor, 1),
+	))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), src, bounds, draw.Src, nil)
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.BiLinear.Scale(dst, dst.Bounds(), small, small.Bounds(), draw.Src, nil)
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)/4))
+	err = jpeg.Encode(buf, dst, &jpeg.Options{Quality: 80})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,131 @@
+package deepbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/wdvxdr1123/ZeroBot"
+)
+
+func TestModerator(t *testing.T) {
+	cfg := `
+[moderation]
+  enabled  = true
+  keywords = ["BadWord", ""]
+  patterns = ['\d{11}', "("]
+
+[[moderation.groups]]
+  id     = 1
+  action = "Blur"
+
+[[moderation.groups]]
+  id     = 2
+  action = "none"
+`
+	config := new(Config)
+	err := toml.Unmarshal([]byte(cfg), config)
+	require.NoError(t, err)
+	m := newModerator(config)
+	require.Len(t, m.patterns, 2)
+	require.Nil(t, m.classifier)
+
+	require.Equal(t, moderationNone, m.Policy(0))
+	require.Equal(t, moderationBlur, m.Policy(1))
+	require.Equal(t, moderationNone, m.Policy(2))
+	require.Equal(t, moderationBlock, m.Policy(3))
+
+	require.False(t, m.CheckText("hello"))
+	require.True(t, m.CheckText("a badword"))
+	require.True(t, m.CheckText("call 13800138000"))
+
+	output := m.BlurText("BADWORD 测试 13800138000")
+	require.Equal(t, "******* 测试 ***********", output)
+
+	unsafe, err := m.CheckImage(testPNGImage(t))
+	require.NoError(t, err)
+	require.False(t, unsafe)
+}
+
+func TestHTTPClassifier(t *testing.T) {
+	img := testPNGImage(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// not assert in the handler goroutine, the client will fail
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Image []byte `json:"image"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || !bytes.Equal(img, req.Image) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"score":0.85}`))
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.Moderation.Classifier = "HTTP"
+	config.Moderation.URL = server.URL
+	m := newModerator(config)
+
+	unsafe, err := m.CheckImage(img)
+	require.NoError(t, err)
+	require.True(t, unsafe)
+
+	m.threshold = 0.9
+	unsafe, err = m.CheckImage(img)
+	require.NoError(t, err)
+	require.False(t, unsafe)
+
+	server.Close()
+	_, err = m.CheckImage(img)
+	require.Error(t, err)
+}
+
+func TestBlurImage(t *testing.T) {
+	output, err := blurImage(testPNGImage(t))
+	require.NoError(t, err)
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(output))
+	require.NoError(t, err)
+	require.Equal(t, "jpeg", format)
+	require.Equal(t, 8, cfg.Width)
+	require.Equal(t, 8, cfg.Height)
+
+	_, err = blurImage([]byte("not image"))
+	require.Error(t, err)
+}
+
+func TestModerateImageFailClosed(t *testing.T) {
+	img := testPNGImage(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.Moderation.Enabled = true
+	config.Moderation.Action = moderationBlur
+	config.Moderation.Classifier = "http"
+	config.Moderation.URL = server.URL
+	bot := &DeepBot{config: config, moderator: newModerator(config)}
+	ctx := &zero.Ctx{Event: &zero.Event{GroupID: 1}}
+
+	// the image is blurred when the classifier is unavailable
+	output, ok := bot.moderateImage(ctx, img)
+	require.True(t, ok)
+	require.NotEqual(t, img, output)
+
+	config.Moderation.FailOpen = true
+	output, ok = bot.moderateImage(ctx, img)
+	require.True(t, ok)
+	require.Equal(t, img, output)
+}
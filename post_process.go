@@ -6,14 +6,13 @@ import (
 	"github.com/wdvxdr1123/ZeroBot"
 )
 
-func (bot *DeepBot) postProcess(ctx *zero.Ctx, user *user, msg string) {
+func (bot *DeepBot) postProcess(ctx *zero.Ctx, user *user, msg string, fromVoice bool) {
 	if user == nil {
 		return
 	}
 	bot.mayUpdateMood(user)
 	bot.randomEmoticon(ctx, user)
-
-	_ = msg
+	bot.replyVoice(ctx, user, msg, fromVoice)
 }
 
 func (bot *DeepBot) mayUpdateMood(user *user) {
@@ -0,0 +1,311 @@
+package deepbot
+
+import (
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/JohannesKaufmann/html-to-markdown/plugin"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// pageContent is the readable main content about a web page.
+type pageContent struct {
+	Title     string
+	URL       string
+	Published string
+	Content   string // markdown
+}
+
+func (pc *pageContent) String() string {
+	builder := strings.Builder{}
+	builder.WriteString("标题: " + pc.Title + "\n")
+	builder.WriteString("URL: " + pc.URL + "\n")
+	if pc.Published != "" {
+		builder.WriteString("发布时间: " + pc.Published + "\n")
+	}
+	builder.WriteString("\n")
+	builder.WriteString(pc.Content)
+	return builder.String()
+}
+
+// elements that never contain the main content
+const noiseSelector = "" +
+	"script, style, noscript, template, iframe, svg, canvas, object, embed, " +
+	"form, button, input, select, textarea, dialog, " +
+	"nav, footer, aside, " +
+	"[role=navigation], [role=banner], [role=contentinfo], [role=complementary], " +
+	"[role=dialog], [role=alertdialog], [aria-hidden=true], [hidden]"
+
+var (
+	unlikelyRegexp = regexp.MustCompile(`(?i)` +
+		`cookie|consent|gdpr|banner|advert|\bads?\b|sponsor|promo|sidebar|footer|` +
+		`\bnav|menu|breadcrumb|share|social|comment|related|recommend|` +
+		`popup|modal|overlay|subscribe|newsletter|login|signup|toolbar|pager`,
+	)
+	// the header in article or main is the title and byline about content
+	headerRegexp = regexp.MustCompile(`(?i)header|masthead`)
+	maybeRegexp  = regexp.MustCompile(`(?i)article|content|main|post|entry|body|text|story|column`)
+
+	positiveRegexp = regexp.MustCompile(`(?i)article|content|main|post|entry|body|text|story|blog`)
+	negativeRegexp = regexp.MustCompile(`(?i)` +
+		`hidden|combx|comment|contact|foot|meta|outbrain|promo|related|scroll|` +
+		`shoutbox|sidebar|sponsor|shopping|tags|tool|widget|banner|share`,
+	)
+
+	blankLinesRegexp = regexp.MustCompile(`\n{3,}`)
+)
+
+var publishedSelectors = []struct {
+	selector string
+	attr     string
+}{
+	{"meta[property='article:published_time']", "content"},
+	{"meta[property='og:published_time']", "content"},
+	{"meta[itemprop=datePublished]", "content"},
+	{"meta[name=pubdate]", "content"},
+	{"meta[name=publishdate]", "content"},
+	{"meta[name=publish-date]", "content"},
+	{"meta[name='DC.date.issued']", "content"},
+	{"meta[name=date]", "content"},
+	{"[itemprop=datePublished]", "datetime"},
+	{"time[datetime]", "datetime"},
+}
+
+var jsonLDDateRegexp = regexp.MustCompile(`"datePublished"\s*:\s*"([^"]+)"`)
+
+// extractContent is a readability-style extractor that find the main content
+// in the page and convert it to markdown with absolute links.
+func extractContent(document, pageURL string) (*pageContent, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(document))
+	if err != nil {
+		return nil, err
+	}
+	baseURL, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		base, err := baseURL.Parse(href)
+		if err == nil {
+			baseURL = base
+		}
+	}
+	pc := &pageContent{
+		Title:     extractTitle(doc),
+		URL:       pageURL,
+		Published: extractPublished(doc),
+	}
+	// clean the noise elements and make links to absolute
+	doc.Find(noiseSelector).Remove()
+	removeUnlikely(doc)
+	resolveLinks(doc, baseURL)
+
+	pc.Content = convertToMarkdown(findMainContent(doc))
+	return pc, nil
+}
+
+// extractFragment is used to convert a part of page to markdown.
+func extractFragment(fragment, pageURL string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(fragment))
+	if err != nil {
+		return "", err
+	}
+	baseURL, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	doc.Find("script, style, noscript, template, svg").Remove()
+	resolveLinks(doc, baseURL)
+	return convertToMarkdown(doc.Find("body")), nil
+}
+
+func convertToMarkdown(selection *goquery.Selection) string {
+	conv := md.NewConverter("", true, nil)
+	conv.Use(plugin.GitHubFlavored())
+	content := conv.Convert(selection)
+	content = blankLinesRegexp.ReplaceAllString(content, "\n\n")
+	return strings.TrimSpace(content)
+}
+
+func extractTitle(doc *goquery.Document) string {
+	if title, ok := doc.Find("meta[property='og:title']").Attr("content"); ok {
+		if title = strings.TrimSpace(title); title != "" {
+			return title
+		}
+	}
+	title := strings.TrimSpace(doc.Find("title").First().Text())
+	if title != "" {
+		return title
+	}
+	return strings.TrimSpace(doc.Find("h1").First().Text())
+}
+
+func extractPublished(doc *goquery.Document) string {
+	for _, item := range publishedSelectors {
+		val, ok := doc.Find(item.selector).First().Attr(item.attr)
+		if !ok {
+			continue
+		}
+		if val = strings.TrimSpace(val); val != "" {
+			return val
+		}
+	}
+	var date string
+	doc.Find("script[type='application/ld+json']").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		match := jsonLDDateRegexp.FindStringSubmatch(s.Text())
+		if len(match) == 2 {
+			date = match[1]
+			return false
+		}
+		return true
+	})
+	return date
+}
+
+func removeUnlikely(doc *goquery.Document) {
+	doc.Find("body *").Each(func(_ int, s *goquery.Selection) {
+		switch goquery.NodeName(s) {
+		case "body", "article", "main", "table", "tbody", "tr", "td", "th", "pre", "code":
+			return
+		}
+		inContent := s.ParentsFiltered("article, main").Length() > 0
+		if goquery.NodeName(s) == "header" && !inContent {
+			s.Remove()
+			return
+		}
+		class, _ := s.Attr("class")
+		id, _ := s.Attr("id")
+		match := class + " " + id
+		unlikely := unlikelyRegexp.MatchString(match) || (headerRegexp.MatchString(match) && !inContent)
+		if !unlikely || maybeRegexp.MatchString(match) {
+			return
+		}
+		s.Remove()
+	})
+}
+
+func resolveLinks(doc *goquery.Document, base *url.URL) {
+	for _, item := range []struct {
+		selector string
+		attr     string
+	}{
+		{"a[href]", "href"},
+		{"img[src]", "src"},
+	} {
+		doc.Find(item.selector).Each(func(_ int, s *goquery.Selection) {
+			val, _ := s.Attr(item.attr)
+			val = strings.TrimSpace(val)
+			if strings.HasPrefix(val, "#") || strings.HasPrefix(strings.ToLower(val), "javascript:") {
+				if item.attr == "href" {
+					s.RemoveAttr("href")
+				}
+				return
+			}
+			ref, err := base.Parse(val)
+			if err != nil {
+				return
+			}
+			s.SetAttr(item.attr, ref.String())
+		})
+	}
+	// lazy loaded image
+	doc.Find("img[data-src]").Each(func(_ int, s *goquery.Selection) {
+		val, _ := s.Attr("data-src")
+		ref, err := base.Parse(strings.TrimSpace(val))
+		if err != nil {
+			return
+		}
+		s.SetAttr("src", ref.String())
+	})
+}
+
+// findMainContent is used to select the element that has the highest score.
+func findMainContent(doc *goquery.Document) *goquery.Selection {
+	// use the semantic element first
+	for _, selector := range []string{"article", "main", "[role=main]", "[itemprop=articleBody]"} {
+		s := doc.Find(selector)
+		if s.Length() != 1 {
+			continue
+		}
+		if textLength(s) > 200 {
+			return s
+		}
+	}
+	scores := make(map[*html.Node]float64)
+	var nodes []*goquery.Selection
+	addScore := func(s *goquery.Selection, score float64) {
+		if s.Length() == 0 {
+			return
+		}
+		node := s.Get(0)
+		if _, ok := scores[node]; !ok {
+			nodes = append(nodes, s)
+			scores[node] = classWeight(s)
+		}
+		scores[node] += score
+	}
+	doc.Find("p, pre, td, blockquote, li, section > div").Each(func(_ int, s *goquery.Selection) {
+		text := strings.TrimSpace(s.Text())
+		length := len([]rune(text))
+		if length < 25 {
+			return
+		}
+		score := 1.0
+		score += float64(strings.Count(text, ",") + strings.Count(text, "，"))
+		score += math.Min(float64(length)/100, 3)
+		addScore(s.Parent(), score)
+		addScore(s.Parent().Parent(), score/2)
+	})
+	var (
+		best      *goquery.Selection
+		bestScore float64
+	)
+	for _, node := range nodes {
+		score := scores[node.Get(0)] * (1 - linkDensity(node))
+		if best == nil || score > bestScore {
+			best = node
+			bestScore = score
+		}
+	}
+	if best == nil {
+		return doc.Find("body")
+	}
+	return best
+}
+
+func classWeight(s *goquery.Selection) float64 {
+	var weight float64
+	for _, attr := range []string{"class", "id"} {
+		val, ok := s.Attr(attr)
+		if !ok || val == "" {
+			continue
+		}
+		if negativeRegexp.MatchString(val) {
+			weight -= 25
+		}
+		if positiveRegexp.MatchString(val) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+func textLength(s *goquery.Selection) int {
+	return len([]rune(strings.TrimSpace(s.Text())))
+}
+
+func linkDensity(s *goquery.Selection) float64 {
+	length := textLength(s)
+	if length == 0 {
+		return 0
+	}
+	var linkLength int
+	s.Find("a").Each(func(_ int, a *goquery.Selection) {
+		linkLength += textLength(a)
+	})
+	return float64(linkLength) / float64(length)
+}
@@ -0,0 +1,52 @@
+package deepbot
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractContent(t *testing.T) {
+	data, err := os.ReadFile("testdata/article.html")
+	require.NoError(t, err)
+
+	pc, err := extractContent(string(data), "https://example.com/blog/go1.24")
+	require.NoError(t, err)
+	fmt.Println(pc)
+
+	require.Equal(t, "Go 1.24 发布说明 - 示例博客", pc.Title)
+	require.Equal(t, "2025-02-11T08:00:00Z", pc.Published)
+
+	require.Contains(t, pc.Content, "# Go 1.24 发布说明")
+	require.Contains(t, pc.Content, "## 主要变化")
+	require.Contains(t, pc.Content, "- 完全支持泛型类型别名")
+	require.Contains(t, pc.Content, "[官方发布说明](https://example.com/doc/go1.24)")
+	require.Contains(t, pc.Content, "| 1.24 | 2025-02 |")
+
+	require.NotContains(t, pc.Content, "Cookie")
+	require.NotContains(t, pc.Content, "热门文章")
+	require.NotContains(t, pc.Content, "版权所有")
+	require.NotContains(t, pc.Content, "tracking")
+}
+
+func TestExtractContentArticleHeader(t *testing.T) {
+	page := `<html><head><title>title</title></head><body>
+<header class="site-header"><a href="/">站点首页</a></header>
+<article>
+  <header class="entry-header">
+    <h1>文章标题</h1>
+    <p class="byline">作者: Alice</p>
+  </header>
+  <p>这是文章的正文内容，用于测试文章中的头部不会被当作噪声移除。</p>
+</article>
+</body></html>`
+	pc, err := extractContent(page, "https://example.com/post")
+	require.NoError(t, err)
+
+	require.Contains(t, pc.Content, "# 文章标题")
+	require.Contains(t, pc.Content, "作者: Alice")
+	require.Contains(t, pc.Content, "这是文章的正文内容")
+	require.NotContains(t, pc.Content, "站点首页")
+}
@@ -1,33 +1,130 @@
 package deepbot
 
 import (
+	"bytes"
 	"context"
 	"embed"
 	"fmt"
+	"io/fs"
+	"log"
 	"math/rand/v2"
 	"net"
 	"net/http"
-	"os"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/chromedp/chromedp"
 )
 
+// the mermaid.min.js is not included for the size, download it
+// from the release of mermaid to asset for render the diagram.
+//
 //go:embed asset
 var asset embed.FS
 
+// hasMermaid is used to check the mermaid.min.js is placed at asset.
+var hasMermaid = func() bool {
+	_, err := fs.Stat(asset, "asset/mermaid.min.js")
+	return err == nil
+}()
+
 //go:embed template/renderer.html
 var renderer string
 
-func (bot *DeepBot) markdownToImage(content string) ([]byte, error) {
+var rendererTemplate = template.Must(template.New("renderer").Funcs(template.FuncMap{
+	"css": cssColor,
+}).Parse(renderer))
+
+// pageBreaksScript is used to get the bottom of the block elements, the
+// element that higher than the page will be walked for inner boundaries.
+const pageBreaksScript = `(function(height) {
+  let breaks = [];
+  let walk = function(elem) {
+    for (const child of elem.children) {
+      let rect = child.getBoundingClientRect();
+      if (rect.height > height && child.children.length > 0) {
+        walk(child);
+      }
+      breaks.push(rect.bottom + window.scrollY);
+    }
+  };
+  walk(document.body);
+  return breaks;
+})(%d)`
+
+func (bot *DeepBot) markdownToImages(content string, opts *renderOptions) ([][]byte, error) {
+	img, err := bot.renderMarkdown(content, opts)
+	if err != nil {
+		return nil, err
+	}
+	return bot.paginate(img, opts)
+}
+
+func (bot *DeepBot) textToImages(text string, opts *renderOptions) ([][]byte, error) {
+	img, err := bot.renderText(text, opts)
+	if err != nil {
+		return nil, err
+	}
+	return bot.paginate(img, opts)
+}
+
+func (bot *DeepBot) htmlToImages(content string, opts *renderOptions) ([][]byte, error) {
+	img, err := bot.renderHTML(content, opts)
+	if err != nil {
+		return nil, err
+	}
+	return bot.paginate(img, opts)
+}
+
+func (bot *DeepBot) renderMarkdown(content string, opts *renderOptions) (*renderedImage, error) {
+	engine := strings.ToLower(bot.config.Renderer.Engine)
+	if engine == rendererNative {
+		return bot.renderer.Markdown(content, opts)
+	}
 	output := markdownToHTML(content)
-	return bot.htmlToImage(output)
+	img, err := bot.renderHTML(output, opts)
+	if err == nil || engine == rendererChrome {
+		return img, err
+	}
+	log.Println("[warning] failed to render with chrome, use native renderer:", err)
+	return bot.renderer.Markdown(content, opts)
 }
 
-func (bot *DeepBot) htmlToImage(content string) ([]byte, error) {
+func (bot *DeepBot) renderText(text string, opts *renderOptions) (*renderedImage, error) {
+	engine := strings.ToLower(bot.config.Renderer.Engine)
+	if engine == rendererNative {
+		return bot.renderer.Text(text, opts)
+	}
+	sections := strings.Split(text, "\n")
+	builder := strings.Builder{}
+	builder.Grow(len(text))
+	for _, section := range sections {
+		builder.WriteString("<div>")
+		builder.WriteString(section)
+		builder.WriteString("</div>")
+	}
+	img, err := bot.renderHTML(builder.String(), opts)
+	if err == nil || engine == rendererChrome {
+		return img, err
+	}
+	log.Println("[warning] failed to render with chrome, use native renderer:", err)
+	return bot.renderer.Text(text, opts)
+}
+
+func (bot *DeepBot) renderHTML(content string, opts *renderOptions) (*renderedImage, error) {
 	// insert code about js and css for renderer code block
-	document := strings.ReplaceAll(renderer, "{{data}}", content)
+	buf := bytes.NewBuffer(make([]byte, 0, len(renderer)+len(content)))
+	err := rendererTemplate.Execute(buf, map[string]any{
+		"Data":     content,
+		"Theme":    opts.theme(),
+		"FontSize": opts.FontSize,
+		"Mermaid":  hasMermaid && strings.Contains(content, `<pre class="mermaid">`),
+	})
+	if err != nil {
+		return nil, err
+	}
+	document := buf.String()
 	fmt.Println(document)
 
 	// deploy a http server for headless browser
@@ -50,65 +147,43 @@ func (bot *DeepBot) htmlToImage(content string) ([]byte, error) {
 	defer func() { _ = server.Close() }()
 	targetURL := fmt.Sprintf("http://%s/%s", listener.Addr(), randomName)
 
-	// start headless browser to renderer it
-	tempDir, err := os.MkdirTemp("", "chromedp-*")
-	if err != nil {
-		return nil, err
-	}
-	defer func() { _ = os.RemoveAll(tempDir) }()
-
-	options := []chromedp.ExecAllocatorOption{
-		chromedp.NoFirstRun,
-		chromedp.NoDefaultBrowserCheck,
-		chromedp.Headless,
-
-		// After Puppeteer's default behavior.
-		chromedp.Flag("disable-background-networking", true),
-		chromedp.Flag("enable-features", "NetworkService,NetworkServiceInProcess"),
-		chromedp.Flag("disable-background-timer-throttling", true),
-		chromedp.Flag("disable-backgrounding-occluded-windows", true),
-		chromedp.Flag("disable-breakpad", true),
-		chromedp.Flag("disable-client-side-phishing-detection", true),
-		chromedp.Flag("disable-default-apps", true),
-		chromedp.Flag("disable-dev-shm-usage", true),
-		chromedp.Flag("disable-features", "site-per-process,Translate,BlinkGenPropertyTrees"),
-		chromedp.Flag("disable-hang-monitor", true),
-		chromedp.Flag("disable-ipc-flooding-protection", true),
-		chromedp.Flag("disable-popup-blocking", true),
-		chromedp.Flag("disable-prompt-on-repost", true),
-		chromedp.Flag("disable-renderer-backgrounding", true),
-		chromedp.Flag("disable-sync", true),
-		chromedp.Flag("force-color-profile", "srgb"),
-		chromedp.Flag("metrics-recording-only", true),
-		chromedp.Flag("safebrowsing-disable-auto-update", true),
-		chromedp.Flag("enable-automation", true),
-		chromedp.Flag("password-store", "basic"),
-		chromedp.Flag("use-mock-keychain", true),
-
-		chromedp.UserDataDir(tempDir),
-	}
-	options = append(options, bot.getChromedpOptions()...)
-
+	// get a tab from the shared browser to renderer it
 	cfg := bot.config.Renderer
 	timeout := time.Duration(cfg.Timeout) * time.Millisecond
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	ctx, cancel = chromedp.NewExecAllocator(ctx, options...)
-	defer cancel()
-	ctx, cancel = chromedp.NewContext(ctx)
-	defer cancel()
+	ctx, release, err := bot.browser.NewTab(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	var image []byte
+	var (
+		image  []byte
+		breaks []float64
+	)
 	tasks := []chromedp.Action{
-		chromedp.EmulateViewport(cfg.Width, cfg.Height, chromedp.EmulateScale(4)),
+		chromedp.EmulateViewport(opts.Width, cfg.Height, chromedp.EmulateScale(opts.Scale)),
 		chromedp.Navigate(targetURL),
 		chromedp.Sleep(time.Second),
 		chromedp.WaitReady("/html/body"),
-		chromedp.FullScreenshot(&image, 100),
+		chromedp.Poll("window.rendererReady === true", nil, chromedp.WithPollingTimeout(timeout)),
+	}
+	if cfg.PageHeight > 0 {
+		script := fmt.Sprintf(pageBreaksScript, cfg.PageHeight)
+		tasks = append(tasks, chromedp.Evaluate(script, &breaks))
 	}
+	tasks = append(tasks, chromedp.FullScreenshot(&image, 100))
 	err = chromedp.Run(ctx, tasks...)
 	if err != nil {
 		return nil, err
 	}
-	return image, nil
+	img := renderedImage{
+		Data:   image,
+		Breaks: make([]int, len(breaks)),
+	}
+	for i, y := range breaks {
+		img.Breaks[i] = int(y * opts.Scale)
+	}
+	return &img, nil
 }
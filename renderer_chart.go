@@ -0,0 +1,217 @@
+package deepbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	chartBar  = "bar"
+	chartLine = "line"
+)
+
+const (
+	chartWidth       = 560
+	chartHeight      = 320
+	chartMarginLeft  = 56
+	chartMarginRight = 16
+	chartMarginTop   = 48
+	chartMarginBtm   = 40
+	chartTicks       = 5
+	chartMaxPoints   = 100
+	chartMaxDatasets = 8
+)
+
+var chartColors = []string{
+	"#4E79A7", "#F28E2B", "#E15759", "#76B7B2",
+	"#59A14F", "#EDC948", "#B07AA1", "#FF9DA7",
+}
+
+// chartSpec is the JSON spec in the chart fenced code block, the data
+// structure is similar with Chart.js for the model can write it easily.
+//
+// ```chart
+// {"type":"bar","title":"sales","labels":["Q1","Q2"],"datasets":[{"label":"2024","data":[1,2]}]}
+// ```
+type chartSpec struct {
+	Type     string   `json:"type"`
+	Title    string   `json:"title"`
+	Labels   []string `json:"labels"`
+	Datasets []struct {
+		Label string    `json:"label"`
+		Data  []float64 `json:"data"`
+	} `json:"datasets"`
+}
+
+func parseChartSpec(data string) (*chartSpec, error) {
+	spec := new(chartSpec)
+	err := json.Unmarshal([]byte(data), spec)
+	if err != nil {
+		return nil, err
+	}
+	spec.Type = strings.ToLower(spec.Type)
+	switch spec.Type {
+	case "":
+		spec.Type = chartBar
+	case chartBar, chartLine:
+	default:
+		return nil, fmt.Errorf("unsupported chart type: %s", spec.Type)
+	}
+	if len(spec.Labels) == 0 || len(spec.Labels) > chartMaxPoints {
+		return nil, errors.New("invalid number of chart labels")
+	}
+	if len(spec.Datasets) == 0 || len(spec.Datasets) > chartMaxDatasets {
+		return nil, errors.New("invalid number of chart datasets")
+	}
+	for _, dataset := range spec.Datasets {
+		if len(dataset.Data) != len(spec.Labels) {
+			return nil, errors.New("chart data is not match the labels")
+		}
+		for _, v := range dataset.Data {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, errors.New("invalid value in chart data")
+			}
+		}
+	}
+	return spec, nil
+}
+
+// chartToSVG is used to draw the bar or line chart as the inline SVG, so
+// that it can be rendered without any script, the text color is inherited
+// from the page for adapt the render theme.
+func chartToSVG(spec *chartSpec) string {
+	lower, upper := chartRange(spec)
+	plotW := float64(chartWidth - chartMarginLeft - chartMarginRight)
+	plotH := float64(chartHeight - chartMarginTop - chartMarginBtm)
+	toY := func(v float64) float64 {
+		return chartMarginTop + plotH - (v-lower)/(upper-lower)*plotH
+	}
+	groupW := plotW / float64(len(spec.Labels))
+
+	b := strings.Builder{}
+	_, _ = fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-size="12" fill="currentColor">`,
+		chartWidth, chartHeight, chartWidth, chartHeight,
+	)
+	if spec.Title != "" {
+		_, _ = fmt.Fprintf(&b, `<text x="%d" y="20" text-anchor="middle" font-size="16" font-weight="bold">%s</text>`,
+			chartWidth/2, html.EscapeString(spec.Title),
+		)
+	}
+	// draw the grid lines and the labels of y axis
+	step := (upper - lower) / chartTicks
+	for i := 0; i <= chartTicks; i++ {
+		v := lower + step*float64(i)
+		y := toY(v)
+		_, _ = fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="currentColor" stroke-opacity="0.2"/>`,
+			chartMarginLeft, y, chartWidth-chartMarginRight, y,
+		)
+		_, _ = fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`,
+			chartMarginLeft-6, y, formatChartValue(v),
+		)
+	}
+	// draw the labels of x axis
+	for i, label := range spec.Labels {
+		x := chartMarginLeft + groupW*(float64(i)+0.5)
+		_, _ = fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`,
+			x, chartHeight-chartMarginBtm+18, html.EscapeString(label),
+		)
+	}
+	// draw the data of each dataset
+	zero := toY(0)
+	barW := groupW * 0.8 / float64(len(spec.Datasets))
+	for i, dataset := range spec.Datasets {
+		color := chartColors[i%len(chartColors)]
+		switch spec.Type {
+		case chartBar:
+			for j, v := range dataset.Data {
+				x := chartMarginLeft + groupW*(float64(j)+0.1) + barW*float64(i)
+				y := toY(v)
+				top, height := min(y, zero), math.Abs(zero-y)
+				_, _ = fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`,
+					x, top, barW, height, color,
+				)
+			}
+		case chartLine:
+			points := make([]string, len(dataset.Data))
+			circles := strings.Builder{}
+			for j, v := range dataset.Data {
+				x := chartMarginLeft + groupW*(float64(j)+0.5)
+				y := toY(v)
+				points[j] = fmt.Sprintf("%.1f,%.1f", x, y)
+				_, _ = fmt.Fprintf(&circles, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"/>`, x, y, color)
+			}
+			_, _ = fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`,
+				strings.Join(points, " "), color,
+			)
+			b.WriteString(circles.String())
+		}
+	}
+	// draw the legend at the top right
+	x := float64(chartWidth - chartMarginRight)
+	for i := len(spec.Datasets) - 1; i >= 0; i-- {
+		label := spec.Datasets[i].Label
+		if label == "" {
+			continue
+		}
+		x -= float64(len([]rune(label))*12 + 24)
+		_, _ = fmt.Fprintf(&b, `<rect x="%.1f" y="30" width="12" height="12" fill="%s"/>`,
+			x, chartColors[i%len(chartColors)],
+		)
+		_, _ = fmt.Fprintf(&b, `<text x="%.1f" y="40">%s</text>`, x+16, html.EscapeString(label))
+	}
+	b.WriteString("</svg>")
+	return b.String()
+}
+
+// chartRange is used to calculate the range of y axis, the zero is
+// always included, and the bound is extended to a readable value.
+func chartRange(spec *chartSpec) (float64, float64) {
+	var lower, upper float64
+	for _, dataset := range spec.Datasets {
+		for _, v := range dataset.Data {
+			lower = min(lower, v)
+			upper = max(upper, v)
+		}
+	}
+	if lower == upper {
+		return 0, 1
+	}
+	step := niceNumber((upper - lower) / chartTicks)
+	lower = math.Floor(lower/step) * step
+	// the step will be larger if the floor of lower make it is not enough
+	for lower+step*chartTicks < upper {
+		step = niceNumber(step * 1.1)
+		lower = math.Floor(lower/step) * step
+	}
+	return lower, lower + step*chartTicks
+}
+
+// niceNumber is used to round the step to 1, 2, 5 or 10 times of the power of 10.
+func niceNumber(n float64) float64 {
+	exp := math.Floor(math.Log10(n))
+	base := math.Pow(10, exp)
+	f := n / base
+	switch {
+	case f <= 1:
+		f = 1
+	case f <= 2:
+		f = 2
+	case f <= 5:
+		f = 5
+	default:
+		f = 10
+	}
+	return f * base
+}
+
+func formatChartValue(v float64) string {
+	if math.Abs(v) < 1e-9 {
+		return "0"
+	}
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
@@ -0,0 +1,80 @@
+package deepbot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChartSpec(t *testing.T) {
+	spec, err := parseChartSpec(`{"labels":["A","B"],"datasets":[{"data":[1,2]}]}`)
+	require.NoError(t, err)
+	require.Equal(t, chartBar, spec.Type)
+
+	spec, err = parseChartSpec(`{"type":"Line","labels":["A"],"datasets":[{"data":[1]}]}`)
+	require.NoError(t, err)
+	require.Equal(t, chartLine, spec.Type)
+
+	for _, data := range []string{
+		`{invalid}`,
+		`{"type":"pie","labels":["A"],"datasets":[{"data":[1]}]}`,
+		`{"labels":[],"datasets":[{"data":[]}]}`,
+		`{"labels":["A"],"datasets":[]}`,
+		`{"labels":["A","B"],"datasets":[{"data":[1]}]}`,
+	} {
+		spec, err = parseChartSpec(data)
+		require.Error(t, err, data)
+		require.Nil(t, spec)
+	}
+}
+
+func TestChartToSVG(t *testing.T) {
+	for _, typ := range []string{chartBar, chartLine} {
+		spec, err := parseChartSpec(`{
+			"type": "` + typ + `",
+			"title": "<sales>",
+			"labels": ["Q1", "Q2", "Q3"],
+			"datasets": [
+				{"label": "2023", "data": [12, -3, 25]},
+				{"label": "2024", "data": [15, 8, 31]}
+			]
+		}`)
+		require.NoError(t, err)
+
+		svg := chartToSVG(spec)
+		require.True(t, strings.HasPrefix(svg, "<svg "))
+		require.True(t, strings.HasSuffix(svg, "</svg>"))
+		require.Contains(t, svg, "&lt;sales&gt;")
+		require.Contains(t, svg, ">Q2</text>")
+		require.Contains(t, svg, ">2024</text>")
+		switch typ {
+		case chartBar:
+			require.Equal(t, 6+2, strings.Count(svg, "<rect "))
+		case chartLine:
+			require.Equal(t, 2, strings.Count(svg, "<polyline "))
+			require.Equal(t, 6, strings.Count(svg, "<circle "))
+		}
+	}
+}
+
+func TestChartRange(t *testing.T) {
+	for _, item := range []struct {
+		data  string
+		lower float64
+		upper float64
+	}{
+		{`[0, 0]`, 0, 1},
+		{`[3, 7]`, 0, 10},
+		{`[12, 31]`, 0, 50},
+		{`[-3, 31]`, -10, 40},
+		{`[-20, -5]`, -20, 5},
+		{`[0.2, 0.8]`, 0, 1},
+	} {
+		spec, err := parseChartSpec(`{"labels":["A","B"],"datasets":[{"data":` + item.data + `}]}`)
+		require.NoError(t, err)
+		lower, upper := chartRange(spec)
+		require.InDelta(t, item.lower, lower, 1e-9, item.data)
+		require.InDelta(t, item.upper, upper, 1e-9, item.data)
+	}
+}
@@ -0,0 +1,652 @@
+package deepbot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	rendererChrome = "chrome"
+	rendererNative = "native"
+)
+
+const (
+	nativeLineSpacing   = 1.5
+	nativePadding       = 16
+	nativeListIndent    = 28
+	nativeCodePadding   = 10
+	nativeQuoteBarWidth = 4
+)
+
+const (
+	nativeFontCJK  = "asset/font/NotoSansSC-VariableFont_wght.ttf"
+	nativeFontMono = "asset/font/RobotoMono-VariableFont_wght.ttf"
+)
+
+// errNoCJKFont is returned when render the CJK text without CJK font,
+// otherwise the text will be rendered as boxes.
+var errNoCJKFont = errors.New("CJK font is not found for native renderer, set renderer.font in config")
+
+type textStyle int
+
+const (
+	styleRegular textStyle = iota
+	styleBold
+	styleMono
+)
+
+// textRenderer is the pure Go renderer for the plain text and the basic
+// markdown, it is used when the Chrome is unavailable or for speed. The
+// fonts are selected by each rune, so the CJK font can be used with the
+// embedded Go fonts, it is configured by Renderer.Font or placed at asset.
+type textRenderer struct {
+	fonts map[textStyle][]*opentype.Font
+	cjk   bool
+}
+
+func newTextRenderer(config *Config) *textRenderer {
+	var cjk []*opentype.Font
+	if config.Renderer.Font != "" {
+		data, err := os.ReadFile(config.Renderer.Font)
+		if err == nil {
+			cjk = appendFont(cjk, data)
+		} else {
+			log.Println("[warning] failed to load font for native renderer:", err)
+		}
+	}
+	data, err := asset.ReadFile(nativeFontCJK)
+	if err == nil {
+		cjk = appendFont(cjk, data)
+	}
+	if len(cjk) == 0 && config.Renderer.Enabled {
+		switch strings.ToLower(config.Renderer.Engine) {
+		case "", "auto", rendererNative:
+			log.Printf("[warning] %s, CJK text can not be rendered by it\n", errNoCJKFont)
+		}
+	}
+	var regular, bold, mono []*opentype.Font
+	regular = appendFont(append(regular, cjk...), goregular.TTF)
+	bold = append(appendFont(bold, gobold.TTF), cjk...)
+	data, err = asset.ReadFile(nativeFontMono)
+	if err == nil {
+		mono = appendFont(mono, data)
+	}
+	mono = append(appendFont(mono, gomono.TTF), cjk...)
+	return &textRenderer{
+		fonts: map[textStyle][]*opentype.Font{
+			styleRegular: regular,
+			styleBold:    bold,
+			styleMono:    mono,
+		},
+		cjk: len(cjk) > 0,
+	}
+}
+
+func appendFont(fonts []*opentype.Font, data []byte) []*opentype.Font {
+	f, err := opentype.Parse(data)
+	if err != nil {
+		log.Println("[warning] failed to parse font for native renderer:", err)
+		return fonts
+	}
+	return append(fonts, f)
+}
+
+// Text is used to render the plain text, each line is a paragraph.
+func (tr *textRenderer) Text(text string, opts *renderOptions) (*renderedImage, error) {
+	err := tr.checkFont(text)
+	if err != nil {
+		return nil, err
+	}
+	canvas := tr.newCanvas(opts)
+	for _, line := range strings.Split(text, "\n") {
+		span := canvas.span(line, styleRegular, canvas.fontSize, canvas.theme.Text)
+		canvas.paragraph([]textSpan{span}, 0)
+		canvas.mark()
+	}
+	return canvas.encode()
+}
+
+// Markdown is used to render the paragraphs, headings, lists, quotes,
+// tables and code blocks, the other elements are rendered as text.
+func (tr *textRenderer) Markdown(content string, opts *renderOptions) (*renderedImage, error) {
+	err := tr.checkFont(content)
+	if err != nil {
+		return nil, err
+	}
+	extensions := parser.CommonExtensions | parser.NoEmptyLineBeforeBlock
+	doc := parser.NewWithExtensions(extensions).Parse([]byte(content))
+	canvas := tr.newCanvas(opts)
+	for _, child := range doc.GetChildren() {
+		canvas.block(child, 0)
+		canvas.mark()
+	}
+	return canvas.encode()
+}
+
+// checkFont is used to fail when the text contain CJK rune but the
+// CJK font is not loaded, so that the caller can send it as text.
+func (tr *textRenderer) checkFont(text string) error {
+	if tr.cjk || !strings.ContainsFunc(text, isWideRune) {
+		return nil
+	}
+	return errNoCJKFont
+}
+
+func (tr *textRenderer) newCanvas(opts *renderOptions) *textCanvas {
+	canvas := textCanvas{
+		renderer: tr,
+		ratio:    opts.Scale,
+		fontSize: float64(opts.FontSize),
+		theme:    opts.theme(),
+		faces:    make(map[faceKey]*fallbackFace),
+	}
+	canvas.width = int(float64(opts.Width) * opts.Scale)
+	canvas.y = canvas.scale(nativePadding)
+	return &canvas
+}
+
+type textSpan struct {
+	Text  string
+	Style textStyle
+	Size  float64
+	Color color.Color
+	Code  bool
+}
+
+type faceKey struct {
+	style textStyle
+	size  float64
+}
+
+// textCanvas is used to layout the content and record the drawing
+// operations, the image is created after the total height is known.
+type textCanvas struct {
+	renderer *textRenderer
+	ratio    float64
+	fontSize float64
+	theme    *renderTheme
+	width    int
+	y        int
+	breaks   []int
+	ops      []func(dst *image.RGBA)
+	faces    map[faceKey]*fallbackFace
+}
+
+func (c *textCanvas) face(style textStyle, size float64) *fallbackFace {
+	key := faceKey{style: style, size: size}
+	if face, ok := c.faces[key]; ok {
+		return face
+	}
+	face := new(fallbackFace)
+	for _, f := range c.renderer.fonts[style] {
+		ff, err := opentype.NewFace(f, &opentype.FaceOptions{
+			Size:    size * c.ratio,
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if err != nil {
+			continue
+		}
+		face.fonts = append(face.fonts, f)
+		face.faces = append(face.faces, ff)
+	}
+	c.faces[key] = face
+	return face
+}
+
+func (c *textCanvas) scale(n int) int {
+	return int(float64(n) * c.ratio)
+}
+
+func (c *textCanvas) space(n int) {
+	c.y += c.scale(n)
+}
+
+// mark is used to record the current y offset as a block boundary.
+func (c *textCanvas) mark() {
+	c.breaks = append(c.breaks, c.y)
+}
+
+func (c *textCanvas) block(node ast.Node, indent int) {
+	switch n := node.(type) {
+	case *ast.Heading:
+		size := []float64{2, 1.5, 1.25, 1.125, 1, 1}[min(max(n.Level, 1), 6)-1] * c.fontSize
+		c.space(8)
+		c.paragraph(c.inline(n, styleBold, size, c.theme.Text, nil), indent)
+		if n.Level <= 2 {
+			y := c.y
+			c.rect(indent, y, c.width-c.scale(nativePadding), y+c.scale(1), c.theme.Border)
+			c.space(4)
+		}
+		c.space(4)
+	case *ast.Paragraph:
+		c.paragraph(c.inline(n, styleRegular, c.fontSize, c.theme.Text, nil), indent)
+		c.space(8)
+	case *ast.List:
+		c.list(n, indent)
+		c.space(4)
+	case *ast.CodeBlock:
+		c.codeBlock(string(n.Literal), indent)
+		c.space(8)
+	case *ast.MathBlock:
+		c.codeBlock(string(n.Literal), indent)
+		c.space(8)
+	case *ast.BlockQuote:
+		top := c.y
+		for _, child := range n.Children {
+			c.block(child, indent+c.scale(nativeListIndent/2))
+		}
+		bottom := c.y - c.scale(8)
+		c.rect(indent, top, indent+c.scale(nativeQuoteBarWidth), bottom, c.theme.Border)
+	case *ast.HorizontalRule:
+		c.space(8)
+		c.rect(indent, c.y, c.width-c.scale(nativePadding), c.y+c.scale(2), c.theme.Border)
+		c.space(10)
+	case *ast.Table:
+		c.table(n, indent)
+		c.space(8)
+	case *ast.HTMLBlock:
+		// skip the style and script, only keep the text in tags
+		literal := strings.TrimSpace(string(n.Literal))
+		if strings.HasPrefix(literal, "<style") || strings.HasPrefix(literal, "<script") {
+			return
+		}
+		text := strings.TrimSpace(stripTags(literal))
+		if text == "" {
+			return
+		}
+		c.paragraph([]textSpan{c.span(text, styleRegular, c.fontSize, c.theme.Text)}, indent)
+		c.space(8)
+	default:
+		container := node.AsContainer()
+		if container != nil && len(container.Children) > 0 {
+			for _, child := range container.Children {
+				c.block(child, indent)
+			}
+			return
+		}
+		leaf := node.AsLeaf()
+		if leaf != nil && len(leaf.Literal) > 0 {
+			c.paragraph([]textSpan{c.span(string(leaf.Literal), styleRegular, c.fontSize, c.theme.Text)}, indent)
+		}
+	}
+}
+
+func (c *textCanvas) span(text string, style textStyle, size float64, col color.Color) textSpan {
+	return textSpan{Text: text, Style: style, Size: size, Color: col}
+}
+
+// inline is used to collect the text spans in the inline elements.
+func (c *textCanvas) inline(node ast.Node, style textStyle, size float64, col color.Color, spans []textSpan) []textSpan {
+	for _, child := range node.GetChildren() {
+		switch n := child.(type) {
+		case *ast.Text:
+			spans = append(spans, c.span(string(n.Literal), style, size, col))
+		case *ast.Code:
+			span := c.span(string(n.Literal), styleMono, size*0.9, col)
+			span.Code = true
+			spans = append(spans, span)
+		case *ast.Math:
+			spans = append(spans, c.span(string(n.Literal), styleMono, size, col))
+		case *ast.Strong:
+			spans = c.inline(n, styleBold, size, col, spans)
+		case *ast.Link:
+			spans = c.inline(n, style, size, c.theme.Link, spans)
+		case *ast.Image:
+			spans = append(spans, c.span("[图片]", style, size, c.theme.Link))
+		case *ast.Hardbreak, *ast.Softbreak:
+			spans = append(spans, c.span("\n", style, size, col))
+		case *ast.NonBlockingSpace:
+			spans = append(spans, c.span(" ", style, size, col))
+		case *ast.HTMLSpan:
+			spans = append(spans, c.span(string(n.Literal), style, size, col))
+		default:
+			leaf := child.AsLeaf()
+			if leaf != nil {
+				spans = append(spans, c.span(string(leaf.Literal), style, size, col))
+				continue
+			}
+			spans = c.inline(child, style, size, col, spans)
+		}
+	}
+	return spans
+}
+
+func (c *textCanvas) list(list *ast.List, indent int) {
+	number := max(list.Start, 1)
+	ordered := list.ListFlags&ast.ListTypeOrdered != 0
+	for _, child := range list.Children {
+		item, ok := child.(*ast.ListItem)
+		if !ok {
+			continue
+		}
+		marker := "•"
+		if ordered {
+			marker = fmt.Sprintf("%d.", number)
+			number++
+		}
+		markerSpan := c.span(marker, styleRegular, c.fontSize, c.theme.Text)
+		c.drawLine([]textToken{c.token(markerSpan, marker)}, indent+c.scale(nativePadding))
+		// draw the item content at the same line with the marker
+		c.y -= c.lineHeight(c.fontSize)
+		for _, sub := range item.Children {
+			switch sub.(type) {
+			case *ast.Paragraph:
+				spans := c.inline(sub, styleRegular, c.fontSize, c.theme.Text, nil)
+				c.paragraph(spans, indent+c.scale(nativeListIndent))
+				c.space(2)
+			default:
+				c.block(sub, indent+c.scale(nativeListIndent))
+			}
+		}
+		c.mark()
+	}
+}
+
+func (c *textCanvas) codeBlock(code string, indent int) {
+	code = strings.TrimRight(code, "\n")
+	padding := c.scale(nativeCodePadding)
+	left := indent + c.scale(nativePadding)
+	right := c.width - c.scale(nativePadding)
+	top := c.y
+	c.y += padding
+	// record the background operation before the text
+	index := len(c.ops)
+	c.ops = append(c.ops, nil)
+	for _, line := range strings.Split(code, "\n") {
+		line = strings.ReplaceAll(line, "\t", "    ")
+		span := c.span(line, styleMono, c.fontSize*0.9, c.theme.Text)
+		c.wrap([]textSpan{span}, left+padding, right-padding, true)
+		c.mark()
+	}
+	c.y += padding
+	bottom := c.y
+	c.ops[index] = func(dst *image.RGBA) {
+		fillRect(dst, image.Rect(left, top, right, bottom), c.theme.Code)
+	}
+}
+
+func (c *textCanvas) table(table *ast.Table, indent int) {
+	ast.WalkFunc(table, func(node ast.Node, entering bool) ast.WalkStatus {
+		row, ok := node.(*ast.TableRow)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		var spans []textSpan
+		for i, cell := range row.Children {
+			cell, ok := cell.(*ast.TableCell)
+			if !ok {
+				continue
+			}
+			if i > 0 {
+				spans = append(spans, c.span(" | ", styleRegular, c.fontSize, c.theme.Quote))
+			}
+			style := styleRegular
+			if cell.IsHeader {
+				style = styleBold
+			}
+			spans = c.inline(cell, style, c.fontSize, c.theme.Text, spans)
+		}
+		c.paragraph(spans, indent)
+		bottom := c.y
+		c.rect(indent, bottom, c.width-c.scale(nativePadding), bottom+c.scale(1), c.theme.Border)
+		c.space(2)
+		c.mark()
+		return ast.SkipChildren
+	})
+}
+
+func (c *textCanvas) paragraph(spans []textSpan, indent int) {
+	x := indent + c.scale(nativePadding)
+	c.wrap(spans, x, c.width-c.scale(nativePadding), false)
+}
+
+func (c *textCanvas) rect(x0, y0, x1, y1 int, col color.Color) {
+	x0 += c.scale(nativePadding)
+	c.ops = append(c.ops, func(dst *image.RGBA) {
+		fillRect(dst, image.Rect(x0, y0, x1, y1), col)
+	})
+}
+
+func (c *textCanvas) lineHeight(size float64) int {
+	return int(size * c.ratio * nativeLineSpacing)
+}
+
+type textToken struct {
+	span  textSpan
+	text  string
+	width int
+}
+
+func (c *textCanvas) token(span textSpan, text string) textToken {
+	face := c.face(span.Style, span.Size)
+	width := font.MeasureString(face, text).Ceil()
+	return textToken{span: span, text: text, width: width}
+}
+
+// wrap is used to break the spans to lines in the width, the latin word
+// is kept in one line, and the CJK text can be broken at any rune.
+func (c *textCanvas) wrap(spans []textSpan, left, right int, keepSpace bool) {
+	var (
+		line []textToken
+		x    = left
+		y    = c.y
+	)
+	flush := func(force bool) {
+		if len(line) == 0 && !force {
+			return
+		}
+		c.drawLine(line, left)
+		line = nil
+		x = left
+	}
+	for _, span := range spans {
+		for _, word := range splitWords(span.Text) {
+			if word == "\n" {
+				flush(true)
+				continue
+			}
+			token := c.token(span, word)
+			if x+token.width > right && len(line) > 0 {
+				flush(false)
+			}
+			if len(line) == 0 && !keepSpace && strings.TrimSpace(word) == "" {
+				continue
+			}
+			// break the long word by rune
+			for x+token.width > right && utf8.RuneCountInString(token.text) > 1 {
+				n := c.fitRunes(span, token.text, right-x)
+				line = append(line, c.token(span, token.text[:n]))
+				flush(false)
+				token = c.token(span, token.text[n:])
+			}
+			line = append(line, token)
+			x += token.width
+		}
+	}
+	flush(false)
+	// keep the empty line
+	if c.y == y {
+		c.y += c.lineHeight(c.fontSize)
+	}
+}
+
+// fitRunes is used to get the byte length of the runes that in the width.
+func (c *textCanvas) fitRunes(span textSpan, text string, width int) int {
+	face := c.face(span.Style, span.Size)
+	var (
+		n       int
+		advance fixed.Int26_6
+	)
+	for i, r := range text {
+		a, _ := face.GlyphAdvance(r)
+		if (advance+a).Ceil() > width && i > 0 {
+			return i
+		}
+		advance += a
+		n = i + utf8.RuneLen(r)
+	}
+	return n
+}
+
+// drawLine is used to record the operation about draw the tokens in line.
+func (c *textCanvas) drawLine(tokens []textToken, left int) {
+	size := c.fontSize
+	for _, token := range tokens {
+		size = max(size, token.span.Size)
+	}
+	height := c.lineHeight(size)
+	baseline := c.y + (height+int(size*c.ratio*0.75))/2
+	x := left
+	for _, token := range tokens {
+		tx := x
+		face := c.face(token.span.Style, token.span.Size)
+		c.ops = append(c.ops, func(dst *image.RGBA) {
+			if token.span.Code && strings.TrimSpace(token.text) != "" {
+				metrics := face.Metrics()
+				rect := image.Rect(tx, baseline-metrics.Ascent.Ceil(), tx+token.width, baseline+metrics.Descent.Ceil())
+				fillRect(dst, rect, c.theme.Code)
+			}
+			drawer := font.Drawer{
+				Dst:  dst,
+				Src:  image.NewUniform(token.span.Color),
+				Face: face,
+				Dot:  fixed.P(tx, baseline),
+			}
+			drawer.DrawString(token.text)
+		})
+		x += token.width
+	}
+	c.y += height
+}
+
+func (c *textCanvas) encode() (*renderedImage, error) {
+	height := c.y + c.scale(nativePadding)
+	dst := image.NewRGBA(image.Rect(0, 0, c.width, height))
+	fillRect(dst, dst.Bounds(), c.theme.Background)
+	for _, op := range c.ops {
+		op(dst)
+	}
+	for _, face := range c.faces {
+		_ = face.Close()
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, c.width*height/4))
+	err := png.Encode(buf, dst)
+	if err != nil {
+		return nil, err
+	}
+	img := renderedImage{
+		Data:   buf.Bytes(),
+		Breaks: c.breaks,
+	}
+	return &img, nil
+}
+
+func fillRect(dst *image.RGBA, rect image.Rectangle, col color.Color) {
+	draw.Draw(dst, rect, image.NewUniform(col), image.Point{}, draw.Src)
+}
+
+// splitWords is used to split the text to the latin words, spaces,
+// line breaks and the single CJK rune for break line.
+func splitWords(text string) []string {
+	var (
+		words []string
+		start = -1
+	)
+	end := func(i int) {
+		if start != -1 {
+			words = append(words, text[start:i])
+			start = -1
+		}
+	}
+	for i, r := range text {
+		switch {
+		case r == '\n':
+			end(i)
+			words = append(words, "\n")
+		case unicode.IsSpace(r), isWideRune(r):
+			end(i)
+			words = append(words, string(r))
+		default:
+			if start == -1 {
+				start = i
+			}
+		}
+	}
+	end(len(text))
+	return words
+}
+
+func isWideRune(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) ||
+		(r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF)
+}
+
+// fallbackFace is used to select the first font that contain the glyph.
+type fallbackFace struct {
+	fonts []*opentype.Font
+	faces []font.Face
+	buf   sfnt.Buffer
+}
+
+func (ff *fallbackFace) selectFace(r rune) font.Face {
+	for i, f := range ff.fonts {
+		index, err := f.GlyphIndex(&ff.buf, r)
+		if err == nil && index != 0 {
+			return ff.faces[i]
+		}
+	}
+	return ff.faces[0]
+}
+
+func (ff *fallbackFace) Close() error {
+	for _, face := range ff.faces {
+		_ = face.Close()
+	}
+	return nil
+}
+
+func (ff *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return ff.selectFace(r).Glyph(dot, r)
+}
+
+func (ff *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return ff.selectFace(r).GlyphBounds(r)
+}
+
+func (ff *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return ff.selectFace(r).GlyphAdvance(r)
+}
+
+func (ff *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	face := ff.selectFace(r0)
+	if face != ff.selectFace(r1) {
+		return 0
+	}
+	return face.Kern(r0, r1)
+}
+
+func (ff *fallbackFace) Metrics() font.Metrics {
+	return ff.faces[0].Metrics()
+}
@@ -0,0 +1,105 @@
+package deepbot
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font/sfnt"
+)
+
+const testCJKFont = `C:\Windows\Fonts\simhei.ttf`
+
+func TestTextRenderer(t *testing.T) {
+	config := new(Config)
+	config.Renderer.Width = 600
+	config.Renderer.Font = testCJKFont
+	bot := &DeepBot{config: config}
+	renderer := newTextRenderer(config)
+	opts := bot.renderOptions(nil)
+	opts.Scale = 2
+
+	t.Run("markdown", func(t *testing.T) {
+		md, err := os.ReadFile("testdata/message.md")
+		require.NoError(t, err)
+
+		output, err := renderer.Markdown(string(md), opts)
+		if !renderer.cjk {
+			require.ErrorIs(t, err, errNoCJKFont)
+			return
+		}
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(output.Data))
+		require.NoError(t, err)
+		require.Equal(t, 1200, img.Bounds().Dx())
+		require.NotEmpty(t, output.Breaks)
+
+		err = os.WriteFile("testdata/native_markdown.png", output.Data, 0600)
+		require.NoError(t, err)
+	})
+
+	t.Run("help document", func(t *testing.T) {
+		output, err := renderer.Markdown(helpMD, opts)
+		if !renderer.cjk {
+			require.ErrorIs(t, err, errNoCJKFont)
+			return
+		}
+		require.NoError(t, err)
+
+		err = os.WriteFile("testdata/native_help.png", output.Data, 0600)
+		require.NoError(t, err)
+	})
+
+	t.Run("text", func(t *testing.T) {
+		short, err := renderer.Text("hello", opts)
+		require.NoError(t, err)
+		long, err := renderer.Text("hello\n\n"+string(bytes.Repeat([]byte("word "), 500)), opts)
+		require.NoError(t, err)
+
+		img1, err := png.Decode(bytes.NewReader(short.Data))
+		require.NoError(t, err)
+		img2, err := png.Decode(bytes.NewReader(long.Data))
+		require.NoError(t, err)
+		require.Greater(t, img2.Bounds().Dy(), img1.Bounds().Dy())
+	})
+}
+
+func TestTextRendererCJK(t *testing.T) {
+	config := new(Config)
+	config.Renderer.Font = testCJKFont
+	renderer := newTextRenderer(config)
+	opts := &renderOptions{Theme: themeLight, FontSize: 16, Width: 300, Scale: 1}
+
+	output, err := renderer.Text("你好", opts)
+	if !renderer.cjk {
+		require.ErrorIs(t, err, errNoCJKFont)
+		require.Nil(t, output)
+		t.Skip("CJK font is not found")
+	}
+	require.NoError(t, err)
+	_, err = png.Decode(bytes.NewReader(output.Data))
+	require.NoError(t, err)
+
+	// each rune must be found in the fonts, otherwise it is .notdef
+	buf := new(sfnt.Buffer)
+	for _, r := range "你好" {
+		var found bool
+		for _, f := range renderer.fonts[styleRegular] {
+			idx, err := f.GlyphIndex(buf, r)
+			require.NoError(t, err)
+			if idx != 0 {
+				found = true
+				break
+			}
+		}
+		require.True(t, found, string(r))
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	words := splitWords("hello world\n你好，go")
+	expected := []string{"hello", " ", "world", "\n", "你", "好", "，", "go"}
+	require.Equal(t, expected, words)
+}
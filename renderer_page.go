@@ -0,0 +1,121 @@
+package deepbot
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"slices"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	pageFooterHeight = 28
+	pageNumberSize   = 12
+)
+
+// renderedImage is the rendered image with the y offsets of the block
+// boundaries in pixel, they are used to split the long image to pages.
+type renderedImage struct {
+	Data   []byte
+	Breaks []int
+}
+
+// paginate is used to split the long image to pages with the bounded
+// height, it will not split if the page height in config is zero.
+func (bot *DeepBot) paginate(img *renderedImage, opts *renderOptions) ([][]byte, error) {
+	height := bot.config.Renderer.PageHeight
+	if height < 1 {
+		return [][]byte{img.Data}, nil
+	}
+	return splitPages(img, int(float64(height)*opts.Scale), opts)
+}
+
+// splitPages is used to cut the image at the block boundaries, and each
+// page has a footer with the page number when the image is split.
+func splitPages(img *renderedImage, height int, opts *renderOptions) ([][]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	if bounds.Dy() <= height {
+		return [][]byte{img.Data}, nil
+	}
+	f, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    pageNumberSize * opts.Scale,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = face.Close() }()
+	// use the color at the bottom of image for fill footer
+	background := src.At(bounds.Min.X, bounds.Max.Y-1)
+	footer := int(pageFooterHeight * opts.Scale)
+	cuts := pageCuts(img.Breaks, bounds.Dy(), height)
+	pages := make([][]byte, 0, len(cuts))
+	var top int
+	for i, cut := range cuts {
+		page := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), cut-top+footer))
+		draw.Draw(page, page.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+		draw.Draw(page, image.Rect(0, 0, bounds.Dx(), cut-top), src, bounds.Min.Add(image.Pt(0, top)), draw.Src)
+		label := fmt.Sprintf("%d / %d", i+1, len(cuts))
+		width := font.MeasureString(face, label).Ceil()
+		metrics := face.Metrics()
+		baseline := cut - top + (footer+metrics.Ascent.Ceil()-metrics.Descent.Ceil())/2
+		drawer := font.Drawer{
+			Dst:  page,
+			Src:  image.NewUniform(opts.theme().Quote),
+			Face: face,
+			Dot:  fixed.P((bounds.Dx()-width)/2, baseline),
+		}
+		drawer.DrawString(label)
+		buf := bytes.NewBuffer(make([]byte, 0, len(img.Data)/len(cuts)))
+		err = png.Encode(buf, page)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, buf.Bytes())
+		top = cut
+	}
+	return pages, nil
+}
+
+// pageCuts is used to select the y offsets for cut the image, it prefers
+// the last block boundary in the page, and the page will be cut at the
+// maximum height if the block is too high. The last offset is the total.
+func pageCuts(breaks []int, total, height int) []int {
+	breaks = slices.Clone(breaks)
+	slices.Sort(breaks)
+	var (
+		cuts []int
+		top  int
+	)
+	for total-top > height {
+		limit := top + height
+		cut := limit
+		// avoid the page is too short that before a high block
+		for _, y := range breaks {
+			if y > limit {
+				break
+			}
+			if y >= top+height/3 {
+				cut = y
+			}
+		}
+		cuts = append(cuts, cut)
+		top = cut
+	}
+	return append(cuts, total)
+}
@@ -0,0 +1,79 @@
+package deepbot
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPageCuts(t *testing.T) {
+	for _, item := range []struct {
+		breaks []int
+		total  int
+		cuts   []int
+	}{
+		{nil, 80, []int{80}},
+		{nil, 250, []int{100, 200, 250}},
+		{[]int{90, 40, 150, 230}, 250, []int{90, 150, 250}},
+		// the block boundary is too close to the top
+		{[]int{10, 260}, 300, []int{100, 200, 300}},
+	} {
+		cuts := pageCuts(item.breaks, item.total, 100)
+		require.Equal(t, item.cuts, cuts, item.breaks)
+	}
+}
+
+func TestSplitPages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 200, 1000))
+	for y := 0; y < 1000; y++ {
+		for x := 0; x < 200; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(y / 4), A: 0xFF})
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	err := png.Encode(buf, src)
+	require.NoError(t, err)
+	opts := &renderOptions{Theme: themeLight, Scale: 1}
+
+	img := &renderedImage{
+		Data:   buf.Bytes(),
+		Breaks: []int{300, 500, 900},
+	}
+	pages, err := splitPages(img, 400, opts)
+	require.NoError(t, err)
+	require.Len(t, pages, 4)
+
+	var top int
+	for i, height := range []int{300, 200, 400, 100} {
+		page, err := png.Decode(bytes.NewReader(pages[i]))
+		require.NoError(t, err)
+		// check the content and the footer with page number
+		require.Equal(t, 200, page.Bounds().Dx())
+		require.Equal(t, height+pageFooterHeight, page.Bounds().Dy())
+		r, _, _, _ := page.At(0, 0).RGBA()
+		require.Equal(t, uint8(top/4), uint8(r>>8))
+		top += height
+	}
+
+	// not split the short image
+	pages, err = splitPages(img, 1000, opts)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{img.Data}, pages)
+
+	t.Run("not config page height", func(t *testing.T) {
+		bot := &DeepBot{config: new(Config)}
+		pages, err := bot.paginate(img, opts)
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{img.Data}, pages)
+	})
+
+	t.Run("invalid image", func(t *testing.T) {
+		pages, err := splitPages(&renderedImage{Data: []byte("foo")}, 100, opts)
+		require.Error(t, err)
+		require.Nil(t, pages)
+	})
+}
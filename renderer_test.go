@@ -1,6 +1,7 @@
 package deepbot
 
 import (
+	"fmt"
 	"os"
 	"testing"
 
@@ -12,6 +13,7 @@ var testBot *DeepBot
 func init() {
 	cfg := &Config{}
 	cfg.Chromedp.ExecPath = chromePath
+	cfg.Renderer.Font = testCJKFont
 	cfg.Renderer.Width = 600
 	cfg.Renderer.Height = 300
 	cfg.Renderer.Timeout = 15000
@@ -22,28 +24,34 @@ func TestMarkdownToImage(t *testing.T) {
 	md, err := os.ReadFile("testdata/message.md")
 	require.NoError(t, err)
 
-	output, err := testBot.markdownToImage(string(md))
+	images, err := testBot.markdownToImages(string(md), testBot.renderOptions(nil))
 	require.NoError(t, err)
 
-	err = os.WriteFile("testdata/markdown.jpg", output, 0600)
-	require.NoError(t, err)
+	for i, img := range images {
+		err = os.WriteFile(fmt.Sprintf("testdata/markdown_%d.jpg", i+1), img, 0600)
+		require.NoError(t, err)
+	}
 }
 
 func TestHTMLToImage(t *testing.T) {
 	data, err := os.ReadFile("testdata/message.html")
 	require.NoError(t, err)
 
-	output, err := testBot.htmlToImage(string(data))
+	images, err := testBot.htmlToImages(string(data), testBot.renderOptions(nil))
 	require.NoError(t, err)
 
-	err = os.WriteFile("testdata/html.jpg", output, 0600)
-	require.NoError(t, err)
+	for i, img := range images {
+		err = os.WriteFile(fmt.Sprintf("testdata/html_%d.jpg", i+1), img, 0600)
+		require.NoError(t, err)
+	}
 }
 
 func TestRendererHelpDocument(t *testing.T) {
-	output, err := testBot.markdownToImage(helpMD)
+	images, err := testBot.markdownToImages(helpMD, testBot.renderOptions(nil))
 	require.NoError(t, err)
 
-	err = os.WriteFile("testdata/help.jpg", output, 0600)
-	require.NoError(t, err)
+	for i, img := range images {
+		err = os.WriteFile(fmt.Sprintf("testdata/help_%d.jpg", i+1), img, 0600)
+		require.NoError(t, err)
+	}
 }
@@ -0,0 +1,339 @@
+package deepbot
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/wdvxdr1123/ZeroBot"
+)
+
+const (
+	themeDark         = "dark"
+	themeLight        = "light"
+	themeSepia        = "sepia"
+	themeHighContrast = "high-contrast"
+)
+
+const (
+	markdownAuto   = "auto"
+	markdownAlways = "always"
+	markdownNever  = "never"
+)
+
+const (
+	defaultRenderTheme    = themeDark
+	defaultRenderFontSize = 16
+	defaultRenderScale    = 4
+	minRenderFontSize     = 10
+	maxRenderFontSize     = 32
+	minRenderWidth        = 300
+	maxRenderWidth        = 1600
+	maxRenderScale        = 4
+)
+
+var fontSizePresets = map[string]int{
+	"small":  14,
+	"medium": 16,
+	"large":  20,
+}
+
+var widthPresets = map[string]int64{
+	"narrow": 400,
+	"normal": 600,
+	"wide":   900,
+}
+
+// renderTheme is the colors about the renderer, the dark theme use the
+// DarkReader to process the page, so the page colors are not used in it.
+type renderTheme struct {
+	Highlight  string // highlight.js stylesheet in asset
+	Mermaid    string // mermaid theme name
+	DarkReader bool
+
+	Background color.RGBA
+	Text       color.RGBA
+	Quote      color.RGBA
+	Link       color.RGBA
+	Code       color.RGBA
+	Border     color.RGBA
+	RowEven    color.RGBA
+	RowOdd     color.RGBA
+
+	// about the code highlight without stylesheet
+	Keyword color.RGBA
+	String  color.RGBA
+	Comment color.RGBA
+	Number  color.RGBA
+	Title   color.RGBA
+}
+
+var renderThemes = map[string]*renderTheme{
+	themeDark: {
+		Highlight:  "github-dark.min.css",
+		Mermaid:    "default",
+		DarkReader: true,
+		Background: color.RGBA{R: 0x18, G: 0x1A, B: 0x1B, A: 0xFF},
+		Text:       color.RGBA{R: 0xE8, G: 0xE6, B: 0xE3, A: 0xFF},
+		Quote:      color.RGBA{R: 0xA8, G: 0xA0, B: 0x95, A: 0xFF},
+		Link:       color.RGBA{R: 0x3F, G: 0x96, B: 0xF5, A: 0xFF},
+		Code:       color.RGBA{R: 0x3C, G: 0x3D, B: 0x3E, A: 0xFF},
+		Border:     color.RGBA{R: 0x45, G: 0x4A, B: 0x4D, A: 0xFF},
+		RowEven:    color.RGBA{R: 0x1D, G: 0x1F, B: 0x20, A: 0xFF},
+		RowOdd:     color.RGBA{R: 0x26, G: 0x2C, B: 0x36, A: 0xFF},
+	},
+	themeLight: {
+		Mermaid:    "default",
+		Background: color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF},
+		Text:       color.RGBA{R: 0x1F, G: 0x23, B: 0x28, A: 0xFF},
+		Quote:      color.RGBA{R: 0x59, G: 0x63, B: 0x6E, A: 0xFF},
+		Link:       color.RGBA{R: 0x09, G: 0x69, B: 0xDA, A: 0xFF},
+		Code:       color.RGBA{R: 0xF6, G: 0xF8, B: 0xFA, A: 0xFF},
+		Border:     color.RGBA{R: 0xD0, G: 0xD7, B: 0xDE, A: 0xFF},
+		RowEven:    color.RGBA{R: 0xF6, G: 0xF8, B: 0xFA, A: 0xFF},
+		RowOdd:     color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF},
+		Keyword:    color.RGBA{R: 0xCF, G: 0x22, B: 0x2E, A: 0xFF},
+		String:     color.RGBA{R: 0x0A, G: 0x30, B: 0x69, A: 0xFF},
+		Comment:    color.RGBA{R: 0x6E, G: 0x77, B: 0x81, A: 0xFF},
+		Number:     color.RGBA{R: 0x05, G: 0x50, B: 0xAE, A: 0xFF},
+		Title:      color.RGBA{R: 0x82, G: 0x50, B: 0xDF, A: 0xFF},
+	},
+	themeSepia: {
+		Mermaid:    "neutral",
+		Background: color.RGBA{R: 0xF4, G: 0xEC, B: 0xD8, A: 0xFF},
+		Text:       color.RGBA{R: 0x5B, G: 0x46, B: 0x36, A: 0xFF},
+		Quote:      color.RGBA{R: 0x8A, G: 0x75, B: 0x60, A: 0xFF},
+		Link:       color.RGBA{R: 0x9C, G: 0x4A, B: 0x1A, A: 0xFF},
+		Code:       color.RGBA{R: 0xEA, G: 0xE0, B: 0xC8, A: 0xFF},
+		Border:     color.RGBA{R: 0xC8, G: 0xB8, B: 0x9A, A: 0xFF},
+		RowEven:    color.RGBA{R: 0xEF, G: 0xE5, B: 0xCE, A: 0xFF},
+		RowOdd:     color.RGBA{R: 0xF4, G: 0xEC, B: 0xD8, A: 0xFF},
+		Keyword:    color.RGBA{R: 0xA3, G: 0x33, B: 0x1F, A: 0xFF},
+		String:     color.RGBA{R: 0x4E, G: 0x6B, B: 0x2E, A: 0xFF},
+		Comment:    color.RGBA{R: 0x9C, G: 0x8B, B: 0x74, A: 0xFF},
+		Number:     color.RGBA{R: 0x8A, G: 0x4B, B: 0x08, A: 0xFF},
+		Title:      color.RGBA{R: 0x6C, G: 0x3B, B: 0x8C, A: 0xFF},
+	},
+	themeHighContrast: {
+		Highlight:  "github-dark.min.css",
+		Mermaid:    "dark",
+		Background: color.RGBA{R: 0x00, G: 0x00, B: 0x00, A: 0xFF},
+		Text:       color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF},
+		Quote:      color.RGBA{R: 0xE0, G: 0xE0, B: 0xE0, A: 0xFF},
+		Link:       color.RGBA{R: 0xFF, G: 0xFF, B: 0x00, A: 0xFF},
+		Code:       color.RGBA{R: 0x1A, G: 0x1A, B: 0x1A, A: 0xFF},
+		Border:     color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF},
+		RowEven:    color.RGBA{R: 0x00, G: 0x00, B: 0x00, A: 0xFF},
+		RowOdd:     color.RGBA{R: 0x1A, G: 0x1A, B: 0x1A, A: 0xFF},
+	},
+}
+
+// renderOptions is the render preferences of user, the zero
+// value in it means use the default value in config.
+type renderOptions struct {
+	Theme    string  `json:"theme,omitempty"`
+	FontSize int     `json:"font_size,omitempty"`
+	Width    int64   `json:"width,omitempty"`
+	Scale    float64 `json:"scale,omitempty"`
+	Delivery string  `json:"delivery,omitempty"`
+	Markdown string  `json:"markdown,omitempty"`
+}
+
+func (opts *renderOptions) theme() *renderTheme {
+	theme, ok := renderThemes[opts.Theme]
+	if !ok {
+		return renderThemes[defaultRenderTheme]
+	}
+	return theme
+}
+
+// isMarkdown is used to check the text need be rendered as markdown,
+// the preference of user will override the result of classification.
+func (opts *renderOptions) isMarkdown(text string) bool {
+	switch opts.Markdown {
+	case markdownAlways:
+		return true
+	case markdownNever:
+		return false
+	default:
+		return isMarkdown(text)
+	}
+}
+
+// renderOptions is used to merge the preferences of user with the config,
+// the user can be nil for use the default options.
+func (bot *DeepBot) renderOptions(user *user) *renderOptions {
+	cfg := bot.config.Renderer
+	opts := renderOptions{
+		Theme:    strings.ToLower(cfg.Theme),
+		FontSize: cfg.FontSize,
+		Width:    cfg.Width,
+		Scale:    cfg.Scale,
+		Delivery: strings.ToLower(bot.config.Forward.Delivery),
+	}
+	if user != nil {
+		prefs := user.getRender()
+		if prefs.Theme != "" {
+			opts.Theme = prefs.Theme
+		}
+		if prefs.FontSize != 0 {
+			opts.FontSize = prefs.FontSize
+		}
+		if prefs.Width != 0 {
+			opts.Width = prefs.Width
+		}
+		if prefs.Scale != 0 {
+			opts.Scale = prefs.Scale
+		}
+		if prefs.Delivery != "" {
+			opts.Delivery = prefs.Delivery
+		}
+		opts.Markdown = prefs.Markdown
+	}
+	if _, ok := renderThemes[opts.Theme]; !ok {
+		opts.Theme = defaultRenderTheme
+	}
+	if opts.FontSize < 1 {
+		opts.FontSize = defaultRenderFontSize
+	}
+	if opts.Width < 1 {
+		opts.Width = widthPresets["normal"]
+	}
+	if opts.Scale <= 0 {
+		opts.Scale = defaultRenderScale
+	}
+	switch opts.Delivery {
+	case deliveryImage, deliveryForward, deliveryText:
+	default:
+		opts.Delivery = deliveryAuto
+	}
+	switch opts.Markdown {
+	case markdownAlways, markdownNever:
+	default:
+		opts.Markdown = markdownAuto
+	}
+	return &opts
+}
+
+// setRenderOption is used to update the preferences by the name and value.
+func setRenderOption(opts *renderOptions, name, value string) error {
+	value = strings.ToLower(value)
+	switch strings.ToLower(name) {
+	case "theme", "主题":
+		if _, ok := renderThemes[value]; !ok {
+			return fmt.Errorf("非法的主题，可选: %s", strings.Join(renderThemeNames(), ", "))
+		}
+		opts.Theme = value
+	case "font", "字号":
+		size, ok := fontSizePresets[value]
+		if !ok {
+			var err error
+			size, err = strconv.Atoi(value)
+			if err != nil || size < minRenderFontSize || size > maxRenderFontSize {
+				return fmt.Errorf("非法的字号，可选: small, medium, large 或 %d-%d", minRenderFontSize, maxRenderFontSize)
+			}
+		}
+		opts.FontSize = size
+	case "width", "宽度":
+		width, ok := widthPresets[value]
+		if !ok {
+			var err error
+			width, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || width < minRenderWidth || width > maxRenderWidth {
+				return fmt.Errorf("非法的宽度，可选: narrow, normal, wide 或 %d-%d", minRenderWidth, maxRenderWidth)
+			}
+		}
+		opts.Width = width
+	case "scale", "缩放":
+		scale, err := strconv.ParseFloat(value, 64)
+		if err != nil || scale < 1 || scale > maxRenderScale {
+			return fmt.Errorf("非法的缩放，范围为1-%d", maxRenderScale)
+		}
+		opts.Scale = scale
+	case "delivery", "发送":
+		switch value {
+		case deliveryAuto, deliveryImage, deliveryForward, deliveryText:
+		default:
+			return fmt.Errorf("非法的发送方式，可选: auto, image, forward, text")
+		}
+		opts.Delivery = value
+	case "markdown", "格式":
+		switch value {
+		case markdownAuto, markdownAlways, markdownNever:
+		default:
+			return fmt.Errorf("非法的格式，可选: auto, always, never")
+		}
+		opts.Markdown = value
+	default:
+		return fmt.Errorf("非法的设置项，可选: theme, font, width, scale, delivery, markdown")
+	}
+	return nil
+}
+
+func renderThemeNames() []string {
+	return []string{themeDark, themeLight, themeSepia, themeHighContrast}
+}
+
+func formatRenderOptions(opts *renderOptions) string {
+	return fmt.Sprintf("主题: %s\n字号: %d\n宽度: %d\n缩放: %s\n发送: %s\n格式: %s",
+		opts.Theme, opts.FontSize, opts.Width, strconv.FormatFloat(opts.Scale, 'f', -1, 64),
+		opts.Delivery, opts.Markdown,
+	)
+}
+
+func (bot *DeepBot) onGetRenderOptions(ctx *zero.Ctx) {
+	user := bot.getUser(ctx.Event.UserID)
+	opts := bot.renderOptions(user)
+	bot.sendText(ctx, formatRenderOptions(opts))
+}
+
+func (bot *DeepBot) onSetRenderOptions(ctx *zero.Ctx) {
+	user := bot.getUser(ctx.Event.UserID)
+
+	args := textToArgN(ctx.MessageString(), 3)
+	if len(args) == 2 && args[1] == "reset" {
+		err := user.setRender(renderOptions{})
+		if err != nil {
+			log.Println("failed to save render preferences:", err)
+			return
+		}
+		bot.sendText(ctx, "已恢复默认渲染设置")
+		return
+	}
+	if len(args) != 3 {
+		bot.sendText(ctx, "非法参数格式")
+		return
+	}
+	prefs := user.getRender()
+	err := setRenderOption(&prefs, args[1], args[2])
+	if err != nil {
+		bot.sendText(ctx, err.Error())
+		return
+	}
+	err = user.setRender(prefs)
+	if err != nil {
+		log.Println("failed to save render preferences:", err)
+		return
+	}
+	bot.sendText(ctx, "设置渲染成功\n"+formatRenderOptions(bot.renderOptions(user)))
+}
+
+func cssColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+func readRenderOptions(path string) renderOptions {
+	var opts renderOptions
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return opts
+	}
+	err = jsonDecode(data, &opts)
+	if err != nil {
+		log.Println("failed to decode render preferences:", err)
+	}
+	return opts
+}
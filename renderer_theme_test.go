@@ -0,0 +1,135 @@
+package deepbot
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderOptions(t *testing.T) {
+	config := new(Config)
+	config.Renderer.Width = 500
+	bot := &DeepBot{config: config}
+
+	opts := bot.renderOptions(nil)
+	expected := &renderOptions{
+		Theme:    themeDark,
+		FontSize: defaultRenderFontSize,
+		Width:    500,
+		Scale:    defaultRenderScale,
+		Delivery: deliveryAuto,
+		Markdown: markdownAuto,
+	}
+	require.Equal(t, expected, opts)
+
+	user := &user{render: renderOptions{Theme: themeSepia, Scale: 2, Delivery: deliveryForward}}
+	opts = bot.renderOptions(user)
+	expected = &renderOptions{
+		Theme:    themeSepia,
+		FontSize: defaultRenderFontSize,
+		Width:    500,
+		Scale:    2,
+		Delivery: deliveryForward,
+		Markdown: markdownAuto,
+	}
+	require.Equal(t, expected, opts)
+}
+
+func TestSetRenderOption(t *testing.T) {
+	var opts renderOptions
+	require.NoError(t, setRenderOption(&opts, "theme", "Light"))
+	require.NoError(t, setRenderOption(&opts, "字号", "large"))
+	require.NoError(t, setRenderOption(&opts, "width", "wide"))
+	require.NoError(t, setRenderOption(&opts, "scale", "1.5"))
+	require.NoError(t, setRenderOption(&opts, "发送", "Forward"))
+	require.NoError(t, setRenderOption(&opts, "markdown", "never"))
+	expected := renderOptions{
+		Theme:    themeLight,
+		FontSize: 20,
+		Width:    900,
+		Scale:    1.5,
+		Delivery: deliveryForward,
+		Markdown: markdownNever,
+	}
+	require.Equal(t, expected, opts)
+
+	require.NoError(t, setRenderOption(&opts, "font", "18"))
+	require.Equal(t, 18, opts.FontSize)
+	require.NoError(t, setRenderOption(&opts, "宽度", "720"))
+	require.Equal(t, int64(720), opts.Width)
+
+	for _, item := range [][2]string{
+		{"theme", "blue"},
+		{"font", "100"},
+		{"width", "10"},
+		{"scale", "8"},
+		{"delivery", "voice"},
+		{"markdown", "sometimes"},
+		{"unknown", "1"},
+	} {
+		err := setRenderOption(&opts, item[0], item[1])
+		require.Error(t, err, item)
+	}
+}
+
+func TestRendererTemplate(t *testing.T) {
+	render := func(theme string) string {
+		opts := &renderOptions{Theme: theme, FontSize: 18}
+		buf := bytes.NewBuffer(nil)
+		err := rendererTemplate.Execute(buf, map[string]any{
+			"Data":     "<p>content</p>",
+			"Theme":    opts.theme(),
+			"FontSize": opts.FontSize,
+		})
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	dark := render(themeDark)
+	require.Contains(t, dark, "<p>content</p>")
+	require.Contains(t, dark, "asset/github-dark.min.css")
+	require.Contains(t, dark, "DarkReader.enable")
+	require.Contains(t, dark, "font-size: 18px;")
+
+	light := render(themeLight)
+	require.NotContains(t, light, "github-dark.min.css")
+	require.NotContains(t, light, "DarkReader")
+	require.Contains(t, light, "background: #FFFFFF;")
+	require.Contains(t, light, ".hljs-keyword")
+
+	// unknown theme will use the default
+	require.Equal(t, dark, render("unknown"))
+
+	require.NotContains(t, dark, "mermaid.min.js")
+	require.Contains(t, dark, "window.rendererReady = true;")
+	buf := bytes.NewBuffer(nil)
+	err := rendererTemplate.Execute(buf, map[string]any{
+		"Data":     `<pre class="mermaid">graph TD</pre>`,
+		"Theme":    renderThemes[themeSepia],
+		"FontSize": 16,
+		"Mermaid":  true,
+	})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "asset/mermaid.min.js")
+	require.Contains(t, buf.String(), "theme: 'neutral'")
+}
+
+func TestTextRendererTheme(t *testing.T) {
+	renderer := newTextRenderer(new(Config))
+	for theme, expected := range map[string]color.RGBA{
+		themeLight: renderThemes[themeLight].Background,
+		themeSepia: renderThemes[themeSepia].Background,
+	} {
+		opts := &renderOptions{Theme: theme, FontSize: 16, Width: 300, Scale: 1}
+		output, err := renderer.Text("hello", opts)
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(output.Data))
+		require.NoError(t, err)
+		require.Equal(t, 300, img.Bounds().Dx())
+		r, g, b, _ := img.At(0, 0).RGBA()
+		require.Equal(t, expected, color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 0xFF})
+	}
+}
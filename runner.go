@@ -0,0 +1,143 @@
+package deepbot
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wdvxdr1123/ZeroBot"
+)
+
+var codeBlockRegexp = regexp.MustCompile("(?s)```([\\w+#-]*)[ \\t]*\\r?\\n(.*?)```")
+
+type codeBlock struct {
+	Lang string
+	Code string
+}
+
+// extractCodeBlocks is used to find all fenced code blocks in the text.
+func extractCodeBlocks(text string) []*codeBlock {
+	var blocks []*codeBlock
+	for _, match := range codeBlockRegexp.FindAllStringSubmatch(text, -1) {
+		code := strings.TrimSpace(match[2])
+		if code == "" {
+			continue
+		}
+		blocks = append(blocks, &codeBlock{
+			Lang: strings.ToLower(match[1]),
+			Code: code,
+		})
+	}
+	return blocks
+}
+
+// truncateText is used to cut the text to the maximum length at the rune boundary.
+func truncateText(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
+
+func (bot *DeepBot) onRunCode(ctx *zero.Ctx) {
+	cfg := bot.config
+	if !cfg.EvalGo.Enabled && !cfg.EvalJS.Enabled && !cfg.EvalLua.Enabled {
+		bot.sendText(ctx, "代码执行服务未启用")
+		return
+	}
+	// the interpreter can access the host, so only the allowed user can run code
+	if !slices.Contains(cfg.RunCode.UserID, ctx.Event.UserID) {
+		bot.sendText(ctx, "没有执行代码的权限")
+		return
+	}
+
+	// use the plain text, otherwise the "[", "]" and "&" are CQ escaped
+	blocks := extractCodeBlocks(ctx.Event.Message.ExtractPlainText())
+	// try to read code blocks from the replied message
+	if len(blocks) == 0 {
+		for _, segment := range ctx.Event.Message {
+			if segment.Type != "reply" {
+				continue
+			}
+			msg := ctx.GetMessage(segment.Data["id"])
+			blocks = extractCodeBlocks(msg.Elements.ExtractPlainText())
+			break
+		}
+	}
+	if len(blocks) == 0 {
+		bot.sendText(ctx, "未找到代码块")
+		return
+	}
+
+	var results []string
+	for _, block := range blocks {
+		results = append(results, bot.runCodeBlock(block))
+	}
+
+	if !bot.config.Renderer.Enabled {
+		sendText(ctx, strings.Join(results, "\n\n"), true)
+		return
+	}
+	builder := strings.Builder{}
+	for i, result := range results {
+		builder.WriteString(fmt.Sprintf("<h4>#%d %s</h4>", i+1, blocks[i].Lang))
+		builder.WriteString("<pre><code class=\"plaintext\">")
+		builder.WriteString(html.EscapeString(result))
+		builder.WriteString("</code></pre>")
+	}
+	images, err := bot.htmlToImages(builder.String(), bot.renderOptions(bot.getUser(ctx.Event.UserID)))
+	if err != nil {
+		log.Println("failed to render code output:", err)
+		sendText(ctx, strings.Join(results, "\n\n"), true)
+		return
+	}
+	sendImages(ctx, images)
+}
+
+func (bot *DeepBot) runCodeBlock(block *codeBlock) string {
+	var (
+		eval    evaluator
+		timeout int
+		prefix  string
+	)
+	switch block.Lang {
+	case "", "go", "golang":
+		block.Lang = "go"
+		if !bot.config.EvalGo.Enabled {
+			return "Go执行服务未启用"
+		}
+		eval = onEvalGo
+		timeout = bot.config.EvalGo.Timeout
+		prefix = "Go Error: "
+	case "js", "javascript":
+		if !bot.config.EvalJS.Enabled {
+			return "JavaScript执行服务未启用"
+		}
+		eval = onEvalJS
+		timeout = bot.config.EvalJS.Timeout
+		prefix = "JS Error: "
+	case "lua":
+		if !bot.config.EvalLua.Enabled {
+			return "Lua执行服务未启用"
+		}
+		eval = onEvalLua
+		timeout = bot.config.EvalLua.Timeout
+		prefix = "Lua Error: "
+	default:
+		return "不支持的语言: " + block.Lang
+	}
+	output := doEval(eval, time.Duration(timeout)*time.Millisecond, block.Code, prefix)
+	output = truncateText(output, maxToolCallLen)
+	if output == "" {
+		output = "(无输出)"
+	}
+	return output
+}
@@ -0,0 +1,72 @@
+package deepbot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+func TestExtractCodeBlocks(t *testing.T) {
+	text := "deep.run\n" +
+		"```go\npackage main\n\nfunc main() {}\n```\n" +
+		"some text\n" +
+		"```JS\nconsole.log(1)\n```\n" +
+		"```\n\n```\n" +
+		"```\nprintln(2)\n```"
+	blocks := extractCodeBlocks(text)
+	require.Len(t, blocks, 3)
+	require.Equal(t, "go", blocks[0].Lang)
+	require.Equal(t, "package main\n\nfunc main() {}", blocks[0].Code)
+	require.Equal(t, "js", blocks[1].Lang)
+	require.Equal(t, "console.log(1)", blocks[1].Code)
+	require.Equal(t, "", blocks[2].Lang)
+	require.Equal(t, "println(2)", blocks[2].Code)
+
+	blocks = extractCodeBlocks("deep.run without code")
+	require.Empty(t, blocks)
+}
+
+func TestTruncateText(t *testing.T) {
+	require.Equal(t, "hello", truncateText("hello", 10))
+	require.Equal(t, "he", truncateText("hello", 2))
+	// not cut the multibyte rune
+	require.Equal(t, "你", truncateText("你好", 4))
+	require.Equal(t, "", truncateText("你好", 2))
+}
+
+func TestRunCodeBlock(t *testing.T) {
+	config := new(Config)
+	config.EvalLua.Enabled = true
+	config.EvalLua.Timeout = 15000
+	bot := &DeepBot{config: config}
+
+	output := bot.runCodeBlock(&codeBlock{Lang: "lua", Code: "print(1 + 2)"})
+	require.Equal(t, "3\n", output)
+	output = bot.runCodeBlock(&codeBlock{Lang: "go", Code: "println(1)"})
+	require.Equal(t, "Go执行服务未启用", output)
+	output = bot.runCodeBlock(&codeBlock{Lang: "js", Code: "print(1)"})
+	require.Equal(t, "JavaScript执行服务未启用", output)
+	output = bot.runCodeBlock(&codeBlock{Lang: "python", Code: "print(1)"})
+	require.Equal(t, "不支持的语言: python", output)
+}
+
+func TestOnRunCode(t *testing.T) {
+	config := new(Config)
+	config.EvalJS.Enabled = true
+	config.EvalJS.Timeout = 15000
+	config.RunCode.UserID = []int64{123}
+	bot := &DeepBot{config: config}
+	caller := new(testCaller)
+
+	// the brackets and "&" must not be escaped as CQ code
+	src := "deep.run\n```js\nvar a = [1, 2];\nconsole.log(a[0] && a[1]);\n```"
+	ctx := newTestCtx(caller, &zero.Event{
+		SelfID:  10001,
+		UserID:  123,
+		Message: message.Message{message.Text(src)},
+	})
+	bot.onRunCode(ctx)
+	require.Equal(t, []string{"2\n"}, caller.texts())
+}
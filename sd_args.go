@@ -0,0 +1,290 @@
+package deepbot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxDrawSteps     = 150
+	maxDrawBatchSize = 4
+	maxDrawCFGScale  = 30
+)
+
+// drawArgs contains the arguments from picx command,
+// the zero value means use the value in SDWebUI.Config.
+type drawArgs struct {
+	Prompt    string
+	NegPrompt string
+
+	Width  int
+	Height int
+	Steps  int
+
+	Sampler   string
+	Scheduler string
+	Model     string
+	CFGScale  float64
+	BatchSize int
+
+	Seed    int64
+	HasSeed bool
+}
+
+// parseDrawArgs is used to parse the arguments of picx command, it supports
+// the flag style like "--seed 123 --neg "bad hands" girl" and the legacy
+// positional style like "1024x1536 30 girl". The returned error message
+// will be sent to the user directly.
+func parseDrawArgs(text string) (*drawArgs, error) {
+	flags, rest := textToFlags(text)
+	args := drawArgs{
+		Width:  1024,
+		Height: 1024,
+	}
+	// compatible with the positional arguments
+	params := textToArgN(rest, 3)
+	if len(params) == 3 {
+		width, height, ok := parseResolution(params[0])
+		steps, err := strconv.Atoi(params[1])
+		if ok && err == nil {
+			args.Width, args.Height, args.Steps = width, height, steps
+			rest = params[2]
+		}
+	}
+	for name, value := range flags {
+		if value == "" && name != "neg" {
+			return nil, fmt.Errorf("参数--%s缺少值", name)
+		}
+		var err error
+		switch name {
+		case "res", "size":
+			var ok bool
+			args.Width, args.Height, ok = parseResolution(value)
+			if !ok {
+				return nil, errors.New("非法的分辨率参数")
+			}
+		case "steps":
+			args.Steps, err = strconv.Atoi(value)
+			if err != nil || args.Steps < 1 || args.Steps > maxDrawSteps {
+				return nil, fmt.Errorf("非法的steps参数，范围为1-%d", maxDrawSteps)
+			}
+		case "neg":
+			args.NegPrompt = value
+		case "seed":
+			args.Seed, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || args.Seed < -1 {
+				return nil, errors.New("非法的seed参数")
+			}
+			args.HasSeed = true
+		case "cfg":
+			args.CFGScale, err = strconv.ParseFloat(value, 64)
+			if err != nil || args.CFGScale < 1 || args.CFGScale > maxDrawCFGScale {
+				return nil, fmt.Errorf("非法的cfg参数，范围为1-%d", maxDrawCFGScale)
+			}
+		case "sampler":
+			args.Sampler = value
+		case "scheduler":
+			args.Scheduler = value
+		case "model":
+			args.Model = value
+		case "n":
+			args.BatchSize, err = strconv.Atoi(value)
+			if err != nil || args.BatchSize < 1 || args.BatchSize > maxDrawBatchSize {
+				return nil, fmt.Errorf("非法的n参数，范围为1-%d", maxDrawBatchSize)
+			}
+		default:
+			return nil, fmt.Errorf("未知的参数: --%s", name)
+		}
+	}
+	if args.Steps < 0 || args.Steps > maxDrawSteps {
+		return nil, fmt.Errorf("非法的steps参数，范围为1-%d", maxDrawSteps)
+	}
+	args.Prompt = strings.TrimSpace(rest)
+	if args.Prompt == "" {
+		return nil, errors.New("非法的prompt参数")
+	}
+	return &args, nil
+}
+
+// apply is used to override the default arguments in SDWebUI.Config.
+func (args *drawArgs) apply(arg *txt2Image) {
+	arg.Prompt = args.Prompt
+	arg.Width = args.Width
+	arg.Height = args.Height
+	if args.Steps > 0 {
+		arg.Steps = args.Steps
+	}
+	if arg.Steps < 1 {
+		arg.Steps = 30
+	}
+	if args.NegPrompt != "" {
+		arg.NegPrompt = args.NegPrompt
+	}
+	if args.Sampler != "" {
+		arg.SamplerName = args.Sampler
+	}
+	if args.Scheduler != "" {
+		arg.Scheduler = args.Scheduler
+	}
+	if args.CFGScale > 0 {
+		arg.CFGScale = args.CFGScale
+	}
+	if args.BatchSize > 0 {
+		arg.BatchSize = args.BatchSize
+	}
+	if args.Model != "" {
+		arg.OverrideSettings = map[string]any{
+			"sd_model_checkpoint": args.Model,
+		}
+	}
+	arg.SendImages = true
+	arg.SaveImages = true
+	arg.Seed = -1
+	if args.HasSeed {
+		arg.Seed = args.Seed
+	}
+}
+
+// validateDrawArgs is used to check the sampler, scheduler and model are
+// supported by SD-WebUI, the name will be replaced to the canonical name.
+// The returned requirement is used to select the backend for drawing, if
+// these arguments are set, the job will only run on the validated backend,
+// because the names are different between the backends.
+func (bot *DeepBot) validateDrawArgs(args *drawArgs) (drawRequire, error) {
+	require := newDrawRequire(drawKindTxt2Img, args.Model, args.Prompt)
+	if !bot.drawBackends.Supported(require) {
+		return require, errors.New("没有支持该模型或LoRA的画图后端")
+	}
+	if args.Sampler == "" && args.Scheduler == "" && args.Model == "" {
+		return require, nil
+	}
+	node, err := bot.drawBackends.Pick(require)
+	if err != nil {
+		return require, errors.New("没有可用的画图后端")
+	}
+	require.Node = node.Name
+	// the names in ComfyUI are different, so only check SD-WebUI
+	sd, ok := node.backend.(*sdWebUI)
+	if !ok {
+		return require, nil
+	}
+	ctx, cancel := bot.sdContext()
+	defer cancel()
+	if args.Sampler != "" {
+		var samplers []struct {
+			Name    string   `json:"name"`
+			Aliases []string `json:"aliases"`
+		}
+		err := sd.call(ctx, http.MethodGet, "/sdapi/v1/samplers", nil, &samplers)
+		if err != nil {
+			return require, err
+		}
+		var names []string
+		found := false
+		for _, sampler := range samplers {
+			names = append(names, sampler.Name)
+			for _, name := range append([]string{sampler.Name}, sampler.Aliases...) {
+				if strings.EqualFold(name, args.Sampler) {
+					args.Sampler = sampler.Name
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return require, fmt.Errorf("不支持的采样器，可选: %s", strings.Join(names, ", "))
+		}
+	}
+	if args.Scheduler != "" {
+		var schedulers []struct {
+			Name  string `json:"name"`
+			Label string `json:"label"`
+		}
+		err := sd.call(ctx, http.MethodGet, "/sdapi/v1/schedulers", nil, &schedulers)
+		if err != nil {
+			return require, err
+		}
+		var labels []string
+		found := false
+		for _, scheduler := range schedulers {
+			labels = append(labels, scheduler.Label)
+			if strings.EqualFold(scheduler.Name, args.Scheduler) ||
+				strings.EqualFold(scheduler.Label, args.Scheduler) {
+				args.Scheduler = scheduler.Label
+				found = true
+				break
+			}
+		}
+		if !found {
+			return require, fmt.Errorf("不支持的调度器，可选: %s", strings.Join(labels, ", "))
+		}
+	}
+	if args.Model != "" {
+		var models []struct {
+			Title     string `json:"title"`
+			ModelName string `json:"model_name"`
+		}
+		err := sd.call(ctx, http.MethodGet, "/sdapi/v1/sd-models", nil, &models)
+		if err != nil {
+			return require, err
+		}
+		var names []string
+		found := false
+		for _, model := range models {
+			names = append(names, model.ModelName)
+			if strings.EqualFold(model.Title, args.Model) ||
+				strings.EqualFold(model.ModelName, args.Model) {
+				args.Model = model.Title
+				found = true
+				break
+			}
+		}
+		if !found {
+			return require, fmt.Errorf("不支持的模型，可选: %s", strings.Join(names, ", "))
+		}
+	}
+	return require, nil
+}
+
+// formatDrawArgs is used to build the picx command that can reproduce the image.
+func formatDrawArgs(arg *txt2Image, seed int64, model string) string {
+	builder := strings.Builder{}
+	builder.WriteString("picx")
+	builder.WriteString(fmt.Sprintf(" --res %dx%d", arg.Width, arg.Height))
+	builder.WriteString(fmt.Sprintf(" --steps %d", arg.Steps))
+	builder.WriteString(fmt.Sprintf(" --seed %d", seed))
+	if arg.BatchSize > 1 {
+		builder.WriteString(fmt.Sprintf(" --n %d", arg.BatchSize))
+	}
+	if arg.CFGScale > 0 {
+		builder.WriteString(" --cfg " + strconv.FormatFloat(arg.CFGScale, 'f', -1, 64))
+	}
+	if arg.SamplerName != "" {
+		builder.WriteString(" --sampler " + quoteFlagValue(arg.SamplerName))
+	}
+	if arg.Scheduler != "" {
+		builder.WriteString(" --scheduler " + quoteFlagValue(arg.Scheduler))
+	}
+	if model != "" {
+		builder.WriteString(" --model " + quoteFlagValue(model))
+	}
+	if arg.NegPrompt != "" {
+		builder.WriteString(" --neg " + quoteFlagValue(arg.NegPrompt))
+	}
+	builder.WriteString(" ")
+	builder.WriteString(arg.Prompt)
+	return builder.String()
+}
+
+func quoteFlagValue(value string) string {
+	if !strings.ContainsAny(value, " \t\"") {
+		return value
+	}
+	return "\"" + strings.ReplaceAll(value, "\"", "\\\"") + "\""
+}
@@ -0,0 +1,203 @@
+package deepbot
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDrawArgs(t *testing.T) {
+	t.Run("flags", func(t *testing.T) {
+		text := "--res 768x1024 --steps 20 --neg \"bad hands\" --seed 42 --cfg 5.5 --sampler euler --n 2 girl, blue"
+		args, err := parseDrawArgs(text)
+		require.NoError(t, err)
+		require.Equal(t, &drawArgs{
+			Prompt:    "girl, blue",
+			NegPrompt: "bad hands",
+			Width:     768,
+			Height:    1024,
+			Steps:     20,
+			Sampler:   "euler",
+			CFGScale:  5.5,
+			BatchSize: 2,
+			Seed:      42,
+			HasSeed:   true,
+		}, args)
+	})
+
+	t.Run("positional", func(t *testing.T) {
+		args, err := parseDrawArgs("1024x1536 30 girl, blue")
+		require.NoError(t, err)
+		require.Equal(t, 1024, args.Width)
+		require.Equal(t, 1536, args.Height)
+		require.Equal(t, 30, args.Steps)
+		require.Equal(t, "girl, blue", args.Prompt)
+	})
+
+	t.Run("prompt only", func(t *testing.T) {
+		args, err := parseDrawArgs("girl, blue 2 cats")
+		require.NoError(t, err)
+		require.Equal(t, 1024, args.Width)
+		require.Zero(t, args.Steps)
+		require.False(t, args.HasSeed)
+		require.Equal(t, "girl, blue 2 cats", args.Prompt)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, text := range []string{
+			"--res 1024 girl",
+			"--steps 0 girl",
+			"--seed abc girl",
+			"--cfg 100 girl",
+			"--n 5 girl",
+			"--unknown 1 girl",
+			"--seed",
+			"--seed 1",
+			"1024x1024 999 girl",
+		} {
+			_, err := parseDrawArgs(text)
+			require.Error(t, err, text)
+		}
+	})
+}
+
+func TestDrawArgsApply(t *testing.T) {
+	arg := &txt2Image{
+		NegPrompt:   "default",
+		SamplerName: "Euler a",
+		CFGScale:    7,
+		BatchSize:   1,
+	}
+	args := &drawArgs{
+		Prompt: "girl",
+		Width:  512,
+		Height: 768,
+		Model:  "model.safetensors",
+	}
+	args.apply(arg)
+	require.Equal(t, "girl", arg.Prompt)
+	require.Equal(t, "default", arg.NegPrompt)
+	require.Equal(t, 30, arg.Steps)
+	require.Equal(t, int64(-1), arg.Seed)
+	require.Equal(t, "model.safetensors", arg.OverrideSettings["sd_model_checkpoint"])
+
+	output := formatDrawArgs(arg, 123, "model")
+	expected := "picx --res 512x768 --steps 30 --seed 123 --cfg 7 --sampler \"Euler a\" --model model --neg default girl"
+	require.Equal(t, expected, output)
+
+	// the output can be parsed again
+	parsed, err := parseDrawArgs(output[len("picx "):])
+	require.NoError(t, err)
+	require.Equal(t, "Euler a", parsed.Sampler)
+	require.Equal(t, int64(123), parsed.Seed)
+
+	arg.BatchSize = 4
+	output = formatDrawArgs(arg, 123, "")
+	expected = "picx --res 512x768 --steps 30 --seed 123 --n 4 --cfg 7 --sampler \"Euler a\" --neg default girl"
+	require.Equal(t, expected, output)
+	parsed, err = parseDrawArgs(output[len("picx "):])
+	require.NoError(t, err)
+	require.Equal(t, 4, parsed.BatchSize)
+}
+
+func TestValidateDrawArgs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the unexpected method will make the validation failed
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.URL.Path {
+		case "/sdapi/v1/samplers":
+			_, _ = w.Write([]byte(`[{"name":"Euler a","aliases":["k_euler_a"]},{"name":"DPM++ 2M","aliases":[]}]`))
+		case "/sdapi/v1/schedulers":
+			_, _ = w.Write([]byte(`[{"name":"automatic","label":"Automatic"},{"name":"karras","label":"Karras"}]`))
+		case "/sdapi/v1/sd-models":
+			_, _ = w.Write([]byte(`[{"title":"flux.safetensors [abc]","model_name":"flux"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.SDWebUI.URL = server.URL
+	config.SDWebUI.Timeout = 3000
+	bot := &DeepBot{config: config}
+	bot.drawBackends = newDrawBackends(config)
+	defer bot.drawBackends.Close()
+
+	args := &drawArgs{Sampler: "K_EULER_A", Scheduler: "karras", Model: "FLUX", Prompt: "<lora:detail:0.5>"}
+	need, err := bot.validateDrawArgs(args)
+	require.NoError(t, err)
+	require.Equal(t, "Euler a", args.Sampler)
+	require.Equal(t, "Karras", args.Scheduler)
+	require.Equal(t, "flux.safetensors [abc]", args.Model)
+	expected := drawRequire{Kind: drawKindTxt2Img, Model: "FLUX", LoRAs: []string{"detail"}, Node: "default"}
+	require.Equal(t, expected, need)
+
+	// not pin the backend if there is nothing need be validated
+	need, err = bot.validateDrawArgs(&drawArgs{Prompt: "girl"})
+	require.NoError(t, err)
+	require.Empty(t, need.Node)
+
+	_, err = bot.validateDrawArgs(&drawArgs{Sampler: "unknown"})
+	require.EqualError(t, err, "不支持的采样器，可选: Euler a, DPM++ 2M")
+	_, err = bot.validateDrawArgs(&drawArgs{Scheduler: "unknown"})
+	require.Error(t, err)
+	_, err = bot.validateDrawArgs(&drawArgs{Model: "unknown"})
+	require.EqualError(t, err, "不支持的模型，可选: flux")
+}
+
+func TestValidateDrawArgsMixedBackends(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sdapi/v1/samplers":
+			_, _ = w.Write([]byte(`[{"name":"Euler a","aliases":["k_euler_a"]}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := fmt.Sprintf(`
+[sd_webui]
+  timeout = 3000
+
+[[sd_webui.backends]]
+  name     = "comfy"
+  type     = "comfyui"
+  url      = "http://127.0.0.1:1/"
+  workflow = "workflow.json"
+
+[[sd_webui.backends]]
+  name = "sd"
+  url  = "%s"
+`, server.URL)
+	config := new(Config)
+	err := toml.Unmarshal([]byte(cfg), config)
+	require.NoError(t, err)
+	bot := &DeepBot{config: config}
+	bot.drawBackends = newDrawBackends(config)
+	defer bot.drawBackends.Close()
+
+	// the job must run on the backend that the arguments are validated
+	nodes := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		args := &drawArgs{Sampler: "k_euler_a"}
+		need, err := bot.validateDrawArgs(args)
+		require.NoError(t, err)
+		nodes[need.Node] = true
+		node := bot.drawBackends.Acquire(need)
+		require.Equal(t, need.Node, node.Name)
+		bot.drawBackends.Release(node, nil)
+		if need.Node == "sd" {
+			require.Equal(t, "Euler a", args.Sampler)
+		}
+	}
+	require.Equal(t, map[string]bool{"comfy": true, "sd": true}, nodes)
+}
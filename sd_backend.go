@@ -0,0 +1,330 @@
+package deepbot
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	drawBackendSDWebUI = "sd_webui"
+	drawBackendComfyUI = "comfyui"
+)
+
+const (
+	balanceRoundRobin = "round_robin"
+	balanceLeastBusy  = "least_busy"
+)
+
+const (
+	drawKindTxt2Img   = "txt2img"
+	drawKindVariation = "variation"
+	drawKindImg2Img   = "img2img"
+	drawKindUpscale   = "upscale"
+)
+
+const (
+	defaultHealthCheck = 30 * time.Second
+	healthCheckTimeout = 5 * time.Second
+)
+
+var (
+	errDrawNotSupported = errors.New("operation is not supported by draw backend")
+	errNoDrawBackend    = errors.New("no available draw backend")
+)
+
+// match the LoRA in prompt like <lora:name:0.8>
+var loraRegexp = regexp.MustCompile(`<lora:([^:>]+)(?::[^>]*)?>`)
+
+// drawBackend is used to generate image, like SD-WebUI and ComfyUI.
+type drawBackend interface {
+	Txt2Img(ctx context.Context, arg *txt2Image) ([][]byte, *sdImageInfo, error)
+	Img2Img(ctx context.Context, arg *img2Image) ([]byte, error)
+	Upscale(ctx context.Context, arg *upscaleImage) ([]byte, error)
+	Progress(ctx context.Context) (*sdProgress, error)
+	Interrupt(ctx context.Context) error
+	Ping(ctx context.Context) error
+}
+
+// drawRequire is the requirement about the drawing job,
+// it is used to select the backend with the capabilities.
+type drawRequire struct {
+	Kind  string
+	Model string
+	LoRAs []string
+	Node  string // the arguments are only valid on this backend
+}
+
+func newDrawRequire(kind, model, prompt string) drawRequire {
+	require := drawRequire{
+		Kind:  kind,
+		Model: model,
+	}
+	for _, match := range loraRegexp.FindAllStringSubmatch(prompt, -1) {
+		require.LoRAs = append(require.LoRAs, strings.TrimSpace(match[1]))
+	}
+	return require
+}
+
+// drawNode is a backend with the scheduling status.
+type drawNode struct {
+	Name    string
+	Type    string
+	Weight  int
+	MaxJobs int
+	Models  []string
+	LoRAs   []string
+
+	backend drawBackend
+
+	healthy bool
+	busy    int
+	current int
+}
+
+func (node *drawNode) support(require drawRequire) bool {
+	if require.Node != "" && node.Name != require.Node {
+		return false
+	}
+	if node.Type == drawBackendComfyUI && require.Kind != drawKindTxt2Img {
+		return false
+	}
+	if require.Model != "" && len(node.Models) != 0 {
+		if !slices.ContainsFunc(node.Models, func(m string) bool {
+			return strings.EqualFold(m, require.Model)
+		}) {
+			return false
+		}
+	}
+	if len(node.LoRAs) == 0 {
+		return true
+	}
+	for _, lora := range require.LoRAs {
+		if !slices.ContainsFunc(node.LoRAs, func(l string) bool {
+			return strings.EqualFold(l, lora)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// drawBackends is used to schedule the jobs to multi backends with the
+// weighted round-robin or least-busy policy, the offline backend will be
+// skipped until it pass the health check.
+type drawBackends struct {
+	nodes   []*drawNode
+	balance string
+	mu      sync.Mutex
+
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newDrawBackends(config *Config) *drawBackends {
+	cfg := config.SDWebUI
+	var nodes []*drawNode
+	for _, item := range cfg.Backends {
+		node := &drawNode{
+			Name:    item.Name,
+			Type:    strings.ToLower(item.Type),
+			Weight:  max(item.Weight, 1),
+			MaxJobs: max(item.MaxJobs, 1),
+			Models:  item.Models,
+			LoRAs:   item.LoRAs,
+			healthy: true,
+		}
+		if node.Name == "" {
+			node.Name = item.URL
+		}
+		switch node.Type {
+		case "", drawBackendSDWebUI:
+			node.Type = drawBackendSDWebUI
+			node.backend = newSDWebUI(item.URL)
+			// the progress and interrupt of SD-WebUI are about the whole
+			// backend, so the jobs of different users can not be shared
+			if node.MaxJobs > 1 {
+				log.Printf("[warning] max_jobs of SD-WebUI backend %s is limited to 1\n", node.Name)
+				node.MaxJobs = 1
+			}
+		case drawBackendComfyUI:
+			var model string
+			if len(item.Models) > 0 {
+				model = item.Models[0]
+			}
+			stall := time.Duration(cfg.Timeout) * time.Millisecond
+			node.backend = newComfyUI(item.URL, item.Workflow, model, stall)
+		default:
+			log.Println("[warning] unknown draw backend type:", item.Type)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	// compatible with the single url in old version
+	if len(cfg.Backends) == 0 && cfg.URL != "" {
+		nodes = append(nodes, &drawNode{
+			Name:    "default",
+			Type:    drawBackendSDWebUI,
+			Weight:  1,
+			MaxJobs: 1,
+			backend: newSDWebUI(cfg.URL),
+			healthy: true,
+		})
+	}
+	interval := time.Duration(cfg.HealthCheck) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultHealthCheck
+	}
+	backends := drawBackends{
+		nodes:    nodes,
+		balance:  strings.ToLower(cfg.Balance),
+		interval: interval,
+	}
+	backends.ctx, backends.cancel = context.WithCancel(context.Background())
+	if len(nodes) > 0 {
+		backends.wg.Add(1)
+		go backends.healthCheck()
+	}
+	return &backends
+}
+
+// Supported is used to check there is any backend can process the job.
+func (db *drawBackends) Supported(require drawRequire) bool {
+	for _, node := range db.nodes {
+		if node.support(require) {
+			return true
+		}
+	}
+	return false
+}
+
+// Acquire is used to select a backend that is not full for the job,
+// it will return nil if all the supported backends are busy.
+func (db *drawBackends) Acquire(require drawRequire) *drawNode {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	node := db.selectNode(require, true)
+	if node != nil {
+		node.busy++
+	}
+	return node
+}
+
+// Release is used to release the backend after the job finished, if the job
+// is failed, the backend will be checked and marked offline if it is down.
+func (db *drawBackends) Release(node *drawNode, err error) {
+	db.mu.Lock()
+	node.busy--
+	db.mu.Unlock()
+	if err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	db.check(node)
+}
+
+// IsHealthy is used to check the backend is online.
+func (db *drawBackends) IsHealthy(node *drawNode) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return node.healthy
+}
+
+// Pick is used to select a backend without the limit about the jobs,
+// it is used for the request that not in the draw queue.
+func (db *drawBackends) Pick(require drawRequire) (*drawNode, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	node := db.selectNode(require, false)
+	if node == nil {
+		return nil, errNoDrawBackend
+	}
+	return node, nil
+}
+
+func (db *drawBackends) selectNode(require drawRequire, limit bool) *drawNode {
+	var candidates []*drawNode
+	for _, node := range db.nodes {
+		if !node.healthy || !node.support(require) {
+			continue
+		}
+		if limit && node.busy >= node.MaxJobs {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	if db.balance == balanceLeastBusy {
+		selected := candidates[0]
+		for _, node := range candidates[1:] {
+			// compare busy/weight without float
+			if node.busy*selected.Weight < selected.busy*node.Weight {
+				selected = node
+			}
+		}
+		return selected
+	}
+	// smooth weighted round-robin like nginx
+	var (
+		selected *drawNode
+		total    int
+	)
+	for _, node := range candidates {
+		node.current += node.Weight
+		total += node.Weight
+		if selected == nil || node.current > selected.current {
+			selected = node
+		}
+	}
+	selected.current -= total
+	return selected
+}
+
+func (db *drawBackends) healthCheck() {
+	defer db.wg.Done()
+	ticker := time.NewTicker(db.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-db.ctx.Done():
+			return
+		}
+		for _, node := range db.nodes {
+			db.check(node)
+		}
+	}
+}
+
+func (db *drawBackends) check(node *drawNode) {
+	ctx, cancel := context.WithTimeout(db.ctx, healthCheckTimeout)
+	defer cancel()
+	err := node.backend.Ping(ctx)
+	if db.ctx.Err() != nil {
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	healthy := err == nil
+	if node.healthy != healthy {
+		if healthy {
+			log.Printf("draw backend %s is online\n", node.Name)
+		} else {
+			log.Printf("draw backend %s is offline: %s\n", node.Name, err)
+		}
+	}
+	node.healthy = healthy
+}
+
+func (db *drawBackends) Close() {
+	db.cancel()
+	db.wg.Wait()
+}
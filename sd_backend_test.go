@@ -0,0 +1,140 @@
+package deepbot
+
+import (
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func testDrawBackends(t *testing.T, cfg string) *drawBackends {
+	config := new(Config)
+	err := toml.Unmarshal([]byte(cfg), config)
+	require.NoError(t, err)
+	backends := newDrawBackends(config)
+	t.Cleanup(backends.Close)
+	return backends
+}
+
+func TestNewDrawRequire(t *testing.T) {
+	need := newDrawRequire(drawKindTxt2Img, "flux", "girl, <lora:detail:0.6>, <lora: style >, <lyco:x>")
+	require.Equal(t, drawKindTxt2Img, need.Kind)
+	require.Equal(t, "flux", need.Model)
+	require.Equal(t, []string{"detail", "style"}, need.LoRAs)
+}
+
+func TestDrawBackends(t *testing.T) {
+	t.Run("legacy", func(t *testing.T) {
+		backends := testDrawBackends(t, `
+[sd_webui]
+  url = "http://127.0.0.1:7860/"
+`)
+		require.Len(t, backends.nodes, 1)
+		node, err := backends.Pick(drawRequire{Kind: drawKindUpscale})
+		require.NoError(t, err)
+		require.Equal(t, "default", node.Name)
+	})
+
+	t.Run("SD-WebUI max jobs", func(t *testing.T) {
+		backends := testDrawBackends(t, `
+[sd_webui]
+[[sd_webui.backends]]
+  name     = "a"
+  url      = "http://127.0.0.1:1/"
+  max_jobs = 4
+`)
+		require.Equal(t, 1, backends.nodes[0].MaxJobs)
+	})
+
+	t.Run("round robin", func(t *testing.T) {
+		backends := testDrawBackends(t, `
+[sd_webui]
+[[sd_webui.backends]]
+  name     = "a"
+  type     = "comfyui"
+  url      = "http://127.0.0.1:1/"
+  weight   = 2
+  max_jobs = 10
+[[sd_webui.backends]]
+  name     = "b"
+  type     = "comfyui"
+  url      = "http://127.0.0.1:2/"
+  max_jobs = 10
+`)
+		need := drawRequire{Kind: drawKindTxt2Img}
+		var names []string
+		for i := 0; i < 6; i++ {
+			names = append(names, backends.Acquire(need).Name)
+		}
+		require.Equal(t, []string{"a", "b", "a", "a", "b", "a"}, names)
+	})
+
+	t.Run("least busy", func(t *testing.T) {
+		backends := testDrawBackends(t, `
+[sd_webui]
+  balance = "least_busy"
+[[sd_webui.backends]]
+  name     = "a"
+  type     = "comfyui"
+  url      = "http://127.0.0.1:1/"
+  max_jobs = 2
+[[sd_webui.backends]]
+  name = "b"
+  type = "comfyui"
+  url  = "http://127.0.0.1:2/"
+`)
+		need := drawRequire{Kind: drawKindTxt2Img}
+		a := backends.Acquire(need)
+		require.Equal(t, "a", a.Name)
+		require.Equal(t, "b", backends.Acquire(need).Name)
+		require.Equal(t, "a", backends.Acquire(need).Name)
+		require.Nil(t, backends.Acquire(need))
+
+		backends.Release(a, nil)
+		require.Equal(t, "a", backends.Acquire(need).Name)
+	})
+
+	t.Run("capabilities", func(t *testing.T) {
+		backends := testDrawBackends(t, `
+[sd_webui]
+[[sd_webui.backends]]
+  name   = "sd"
+  url    = "http://127.0.0.1:1/"
+  models = ["sdxl"]
+  loras  = ["detail"]
+[[sd_webui.backends]]
+  name     = "comfy"
+  type     = "comfyui"
+  url      = "http://127.0.0.1:2/"
+  models   = ["flux"]
+  workflow = "workflow.json"
+[[sd_webui.backends]]
+  name = "unknown"
+  type = "unknown"
+`)
+		require.Len(t, backends.nodes, 2)
+
+		node, err := backends.Pick(newDrawRequire(drawKindTxt2Img, "FLUX", ""))
+		require.NoError(t, err)
+		require.Equal(t, "comfy", node.Name)
+		node, err = backends.Pick(newDrawRequire(drawKindTxt2Img, "", "<lora:detail:1>"))
+		require.NoError(t, err)
+		require.Equal(t, "sd", node.Name)
+
+		require.False(t, backends.Supported(newDrawRequire(drawKindImg2Img, "flux", "")))
+		require.False(t, backends.Supported(newDrawRequire(drawKindTxt2Img, "sdxl", "<lora:other:1>")))
+		require.True(t, backends.Supported(drawRequire{Kind: drawKindUpscale}))
+
+		// the job is pinned to the backend that validated the arguments
+		need := drawRequire{Kind: drawKindTxt2Img, Node: "sd"}
+		require.Equal(t, "sd", backends.Acquire(need).Name)
+		// the other backend is not used even if it is idle
+		require.Nil(t, backends.Acquire(need))
+		require.False(t, backends.Supported(drawRequire{Kind: drawKindTxt2Img, Node: "other"}))
+
+		// the offline backend will be skipped
+		backends.nodes[0].healthy = false
+		_, err = backends.Pick(drawRequire{Kind: drawKindUpscale})
+		require.ErrorIs(t, err, errNoDrawBackend)
+	})
+}
@@ -0,0 +1,437 @@
+package deepbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wdvxdr1123/ZeroBot"
+)
+
+const (
+	defaultGallerySize    = 100
+	defaultGalleryList    = 10
+	defaultVariation      = 0.3
+	maxGalleryList        = 30
+	maxGalleryPromptRunes = 30
+)
+
+var errGalleryNotFound = errors.New("image is not found in gallery")
+
+// galleryImage is the record about the generated image, the seed
+// in arguments is the real seed, so it can be reproduced later.
+type galleryImage struct {
+	ID      int        `json:"id"`
+	Command string     `json:"command"`
+	Args    *txt2Image `json:"args"`
+	Model   string     `json:"model"`      // requested model for select backend
+	Actual  string     `json:"model_name"` // model name in the generation information
+	Time    time.Time  `json:"time"`
+}
+
+// gallery is used to store the generated images with the arguments
+// under the data directory of user, the oldest image will be deleted
+// when the number of images is greater than the size.
+type gallery struct {
+	dir  string
+	size int
+	mu   sync.Mutex
+}
+
+func newGallery(dir string, size int) *gallery {
+	if size < 1 {
+		size = defaultGallerySize
+	}
+	return &gallery{dir: dir, size: size}
+}
+
+// Save is used to save the image to the gallery and return the id.
+func (g *gallery) Save(userID int64, image []byte, record *galleryImage) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	dir := g.userDir(userID)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := g.listIDs(dir)
+	if err != nil {
+		return 0, err
+	}
+	id := 1
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+	record.ID = id
+	data, err := jsonEncode(record)
+	if err != nil {
+		return 0, err
+	}
+	err = os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.png", id)), image, 0600)
+	if err != nil {
+		return 0, err
+	}
+	err = os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.json", id)), data, 0600)
+	if err != nil {
+		return 0, err
+	}
+	// delete the oldest images
+	ids = append(ids, id)
+	for _, old := range ids[:max(len(ids)-g.size, 0)] {
+		_ = os.Remove(filepath.Join(dir, fmt.Sprintf("%d.png", old)))
+		_ = os.Remove(filepath.Join(dir, fmt.Sprintf("%d.json", old)))
+	}
+	return id, nil
+}
+
+// List is used to read the latest n records, the newest is the first.
+func (g *gallery) List(userID int64, n int) ([]*galleryImage, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	dir := g.userDir(userID)
+	ids, err := g.listIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var records []*galleryImage
+	for i := len(ids) - 1; i >= 0 && len(records) < n; i-- {
+		record, err := g.readRecord(dir, ids[i])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Load is used to read the record and image, if the id is zero,
+// it will load the latest image of user.
+func (g *gallery) Load(userID int64, id int) (*galleryImage, []byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	dir := g.userDir(userID)
+	if id == 0 {
+		ids, err := g.listIDs(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(ids) == 0 {
+			return nil, nil, errGalleryNotFound
+		}
+		id = ids[len(ids)-1]
+	}
+	record, err := g.readRecord(dir, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	image, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.png", id)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return record, image, nil
+}
+
+func (g *gallery) userDir(userID int64) string {
+	return filepath.Join(g.dir, strconv.FormatInt(userID, 10))
+}
+
+// listIDs is used to read the sorted image id in the directory.
+func (g *gallery) listIDs(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []int
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (g *gallery) readRecord(dir string, id int) (*galleryImage, error) {
+	data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.json", id)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errGalleryNotFound
+		}
+		return nil, err
+	}
+	record := new(galleryImage)
+	err = jsonDecode(data, record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// saveToGallery is used to save the generated images with the real seed,
+// the returned id list is empty if failed to save. If the backend not
+// return the seed of each image like ComfyUI, the images in the batch
+// except the first one can not be reproduced, so only save the first.
+func (bot *DeepBot) saveToGallery(ctx *zero.Ctx, command string, arg *txt2Image, info *sdImageInfo, model string, images [][]byte) []int {
+	if len(images) > 1 && len(info.AllSeeds) != len(images) {
+		images = images[:1]
+	}
+	var ids []int
+	for i, image := range images {
+		args := *arg
+		args.Seed = info.Seed
+		if len(info.AllSeeds) == len(images) {
+			args.Seed = info.AllSeeds[i]
+		}
+		if args.Subseed != 0 {
+			args.Subseed = info.Subseed
+			if len(info.AllSubseeds) == len(images) {
+				args.Subseed = info.AllSubseeds[i]
+			}
+		}
+		args.BatchSize = 1
+		args.BatchCount = 1
+		record := galleryImage{
+			Command: command,
+			Args:    &args,
+			Model:   model,
+			Actual:  info.ModelName,
+			Time:    time.Now(),
+		}
+		id, err := bot.gallery.Save(ctx.Event.UserID, image, &record)
+		if err != nil {
+			log.Println("failed to save image to gallery:", err)
+			return nil
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// submitGalleryDraw is used to draw with the arguments from the gallery.
+func (bot *DeepBot) submitGalleryDraw(ctx *zero.Ctx, name string, require drawRequire, arg *txt2Image, model string) {
+	bot.submitDrawJob(ctx, name, require, func(c context.Context, backend drawBackend) error {
+		images, info, err := backend.Txt2Img(c, arg)
+		if err != nil {
+			return err
+		}
+		for _, img := range images {
+			bot.sendImage(ctx, img)
+		}
+		ids := bot.saveToGallery(ctx, name, arg, info, model, images)
+		sendText(ctx, formatGalleryArgs(arg, info, model, ids), true)
+		return nil
+	})
+}
+
+// loadGalleryImage is used to read the record by the id in argument,
+// it will send the error message to user if failed.
+func (bot *DeepBot) loadGalleryImage(ctx *zero.Ctx, arg string) (*galleryImage, []byte, bool) {
+	var id int
+	if arg != "" {
+		var err error
+		id, err = strconv.Atoi(arg)
+		if err != nil || id < 1 {
+			bot.sendText(ctx, "非法的图片编号")
+			return nil, nil, false
+		}
+	}
+	record, image, err := bot.gallery.Load(ctx.Event.UserID, id)
+	if err != nil {
+		if errors.Is(err, errGalleryNotFound) {
+			bot.sendText(ctx, "图库中没有该图片")
+		} else {
+			log.Println("failed to load image from gallery:", err)
+		}
+		return nil, nil, false
+	}
+	return record, image, true
+}
+
+func (bot *DeepBot) onListGallery(ctx *zero.Ctx) {
+	if !bot.config.SDWebUI.Enabled {
+		bot.sendText(ctx, "画图服务未启用")
+		return
+	}
+
+	n := defaultGalleryList
+	args := textToArgN(ctx.MessageString(), 2)
+	if len(args) == 2 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > maxGalleryList {
+			bot.sendText(ctx, fmt.Sprintf("非法的数量参数，范围为1-%d", maxGalleryList))
+			return
+		}
+	}
+	records, err := bot.gallery.List(ctx.Event.UserID, n)
+	if err != nil {
+		log.Println("failed to list gallery:", err)
+		return
+	}
+	if len(records) == 0 {
+		bot.sendText(ctx, "图库为空")
+		return
+	}
+	bot.sendText(ctx, formatGalleryList(records))
+}
+
+func (bot *DeepBot) onShowGallery(ctx *zero.Ctx) {
+	if !bot.config.SDWebUI.Enabled {
+		bot.sendText(ctx, "画图服务未启用")
+		return
+	}
+
+	args := textToArgN(ctx.MessageString(), 2)
+	if len(args) != 2 {
+		bot.sendText(ctx, "非法参数格式")
+		return
+	}
+	record, image, ok := bot.loadGalleryImage(ctx, args[1])
+	if !ok {
+		return
+	}
+	bot.sendImage(ctx, image)
+	bot.sendText(ctx, formatDrawArgs(record.Args, record.Args.Seed, record.Actual))
+}
+
+// onRerollImage is used to draw again with the same arguments and random seed.
+func (bot *DeepBot) onRerollImage(ctx *zero.Ctx) {
+	if !bot.config.SDWebUI.Enabled {
+		bot.sendText(ctx, "画图服务未启用")
+		return
+	}
+
+	var id string
+	args := textToArgN(ctx.MessageString(), 2)
+	if len(args) == 2 {
+		id = args[1]
+	}
+	record, _, ok := bot.loadGalleryImage(ctx, id)
+	if !ok {
+		return
+	}
+	arg := record.Args
+	arg.Seed = -1
+	arg.Subseed = 0
+	arg.SubseedStrength = 0
+
+	require := newDrawRequire(drawKindTxt2Img, record.Model, arg.Prompt)
+	bot.submitGalleryDraw(ctx, "reroll", require, arg, record.Model)
+}
+
+// onVaryImage is used to draw the similar image with the variation seed.
+func (bot *DeepBot) onVaryImage(ctx *zero.Ctx) {
+	if !bot.config.SDWebUI.Enabled {
+		bot.sendText(ctx, "画图服务未启用")
+		return
+	}
+
+	var id string
+	strength := defaultVariation
+	args := textToArgN(ctx.MessageString(), 3)
+	if len(args) > 1 {
+		id = args[1]
+	}
+	if len(args) > 2 {
+		var err error
+		strength, err = strconv.ParseFloat(args[2], 64)
+		if err != nil || strength <= 0 || strength > 1 {
+			bot.sendText(ctx, "非法的变化幅度参数，范围为0-1")
+			return
+		}
+	}
+	record, _, ok := bot.loadGalleryImage(ctx, id)
+	if !ok {
+		return
+	}
+	arg := record.Args
+	arg.Subseed = -1
+	arg.SubseedStrength = strength
+
+	require := newDrawRequire(drawKindVariation, record.Model, arg.Prompt)
+	bot.submitGalleryDraw(ctx, "vary", require, arg, record.Model)
+}
+
+// onRedrawResolution is used to draw again with the same seed and new resolution.
+func (bot *DeepBot) onRedrawResolution(ctx *zero.Ctx) {
+	if !bot.config.SDWebUI.Enabled {
+		bot.sendText(ctx, "画图服务未启用")
+		return
+	}
+
+	args := textToArgN(ctx.MessageString(), 3)
+	if len(args) < 2 {
+		bot.sendText(ctx, "非法参数格式")
+		return
+	}
+	width, height, ok := parseResolution(args[1])
+	if !ok {
+		bot.sendText(ctx, "非法的分辨率参数")
+		return
+	}
+	var id string
+	if len(args) > 2 {
+		id = args[2]
+	}
+	record, _, ok := bot.loadGalleryImage(ctx, id)
+	if !ok {
+		return
+	}
+	arg := record.Args
+	arg.Width = width
+	arg.Height = height
+
+	require := newDrawRequire(drawKindTxt2Img, record.Model, arg.Prompt)
+	bot.submitGalleryDraw(ctx, "redraw", require, arg, record.Model)
+}
+
+func formatGalleryList(records []*galleryImage) string {
+	builder := strings.Builder{}
+	builder.WriteString("图库最近的图片:")
+	for _, record := range records {
+		prompt := []rune(record.Args.Prompt)
+		if len(prompt) > maxGalleryPromptRunes {
+			prompt = append(prompt[:maxGalleryPromptRunes], []rune("...")...)
+		}
+		builder.WriteString(fmt.Sprintf("\n[%d] %s %dx%d seed:%d %s",
+			record.ID, record.Time.Format(time.DateTime),
+			record.Args.Width, record.Args.Height, record.Args.Seed, string(prompt),
+		))
+	}
+	return builder.String()
+}
+
+func formatGalleryArgs(arg *txt2Image, info *sdImageInfo, model string, ids []int) string {
+	if model == "" {
+		model = info.ModelName
+	}
+	text := formatDrawArgs(arg, info.Seed, model)
+	if arg.Subseed != 0 {
+		text += fmt.Sprintf("\n变化幅度: %s", strconv.FormatFloat(arg.SubseedStrength, 'f', -1, 64))
+	}
+	if len(ids) == 0 {
+		return text
+	}
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = strconv.Itoa(id)
+	}
+	return text + "\n图库编号: " + strings.Join(list, ", ")
+}
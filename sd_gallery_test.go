@@ -0,0 +1,97 @@
+package deepbot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGallery(t *testing.T) {
+	dir := t.TempDir()
+	gallery := newGallery(dir, 3)
+
+	_, _, err := gallery.Load(1, 0)
+	require.ErrorIs(t, err, errGalleryNotFound)
+	records, err := gallery.List(1, 10)
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	for i := 1; i <= 4; i++ {
+		record := galleryImage{
+			Command: "pic",
+			Args:    &txt2Image{Prompt: "girl", Seed: int64(i)},
+			Time:    time.Now(),
+		}
+		id, err := gallery.Save(1, []byte{byte(i)}, &record)
+		require.NoError(t, err)
+		require.Equal(t, i, id)
+	}
+
+	// the oldest image is deleted
+	_, err = os.Stat(filepath.Join(dir, "1", "1.png"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, _, err = gallery.Load(1, 1)
+	require.ErrorIs(t, err, errGalleryNotFound)
+
+	records, err = gallery.List(1, 2)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, 4, records[0].ID)
+	require.Equal(t, 3, records[1].ID)
+
+	record, image, err := gallery.Load(1, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), record.Args.Seed)
+	require.Equal(t, []byte{4}, image)
+
+	record, image, err = gallery.Load(1, 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), record.Args.Seed)
+	require.Equal(t, []byte{2}, image)
+
+	// each user has independent gallery
+	_, _, err = gallery.Load(2, 0)
+	require.ErrorIs(t, err, errGalleryNotFound)
+}
+
+func TestFormatGallery(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 30, 0, 0, time.Local)
+	records := []*galleryImage{
+		{ID: 2, Args: &txt2Image{Prompt: "girl, blue", Width: 512, Height: 768, Seed: 42}, Time: now},
+	}
+	expected := "图库最近的图片:\n[2] 2025-03-01 12:30:00 512x768 seed:42 girl, blue"
+	require.Equal(t, expected, formatGalleryList(records))
+
+	arg := &txt2Image{Prompt: "girl", Width: 512, Height: 512, Steps: 20, Subseed: 7, SubseedStrength: 0.3}
+	info := &sdImageInfo{Seed: 42, ModelName: "flux"}
+	output := formatGalleryArgs(arg, info, "", []int{5, 6})
+	expected = "picx --res 512x512 --steps 20 --seed 42 --model flux girl\n变化幅度: 0.3\n图库编号: 5, 6"
+	require.Equal(t, expected, output)
+}
+
+func TestSaveToGallery(t *testing.T) {
+	bot := &DeepBot{gallery: newGallery(t.TempDir(), 10)}
+	arg := &txt2Image{Prompt: "girl", Seed: -1, BatchSize: 2}
+	images := [][]byte{{1}, {2}}
+
+	// SD-WebUI returns the seed of each image
+	info := &sdImageInfo{Seed: 42, AllSeeds: []int64{42, 43}}
+	ids := bot.saveToGallery(testUserCtx(1), "picx", arg, info, "", images)
+	require.Equal(t, []int{1, 2}, ids)
+	record, _, err := bot.gallery.Load(1, 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(43), record.Args.Seed)
+	require.Equal(t, 1, record.Args.BatchSize)
+
+	// ComfyUI only returns the seed of the batch
+	info = &sdImageInfo{Seed: 100}
+	ids = bot.saveToGallery(testUserCtx(1), "picx", arg, info, "", images)
+	require.Equal(t, []int{3}, ids)
+	record, image, err := bot.gallery.Load(1, 3)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), record.Args.Seed)
+	require.Equal(t, []byte{1}, image)
+}
@@ -0,0 +1,72 @@
+package deepbot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+const promptEnhanceDrawing = `
+你是一名Stable Diffusion提示词专家，请将用户对图片的描述翻译并扩写为英文的画图prompt。
+要求如下:
+1. prompt由英文tag组成，以", "隔开每一个tag，注意不要超过40个tag。
+2. 先写画质相关的tag，再写主体、外貌、动作、服装、场景、光照、构图等tag。
+3. 对于描述中最重要的主体，使用(tag:1.2)这样的格式提升权重，权重范围为0.5-1.5。
+4. 同时生成适合的negative prompt，用于排除低画质、畸形肢体等问题。
+5. 不要添加用户描述中不存在的敏感内容。
+请严格按以下两行格式输出，不要输出其他无关的内容:
+prompt: <英文prompt>
+negative: <英文negative prompt>
+
+用户的描述是:
+`
+
+// enhanceDrawPrompt is used to translate and expand the description from
+// user to the weighted tags and negative prompt with the chat model.
+func (bot *DeepBot) enhanceDrawPrompt(description string) (string, string, error) {
+	req := &ChatRequest{
+		Model:       deepseek.DeepSeekChat,
+		Temperature: 0.7,
+		TopP:        1,
+		MaxTokens:   1024,
+	}
+	// use an empty user for skip the character and history
+	resp, err := bot.seek(req, new(user), promptEnhanceDrawing+description)
+	if err != nil {
+		return "", "", err
+	}
+	return parseEnhancedPrompt(resp.Answer)
+}
+
+func parseEnhancedPrompt(answer string) (string, string, error) {
+	var prompt, negative string
+	for _, line := range strings.Split(answer, "\n") {
+		line = strings.Trim(strings.TrimSpace(line), "`*")
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.Trim(strings.TrimSpace(name), "*")) {
+		case "prompt":
+			prompt = value
+		case "negative", "negative prompt", "negative_prompt":
+			negative = value
+		}
+	}
+	if prompt == "" {
+		return "", "", errors.New("prompt not found in answer")
+	}
+	return prompt, negative, nil
+}
+
+// formatEnhancedPrompt is used to show the final prompt to user.
+func formatEnhancedPrompt(prompt, negative string) string {
+	text := fmt.Sprintf("prompt: %s", prompt)
+	if negative != "" {
+		text += fmt.Sprintf("\nnegative: %s", negative)
+	}
+	return text
+}
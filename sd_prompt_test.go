@@ -0,0 +1,41 @@
+package deepbot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnhancedPrompt(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		answer := "prompt: masterpiece, best quality, (cat:1.2), sitting\nnegative: lowres, bad anatomy"
+		prompt, negative, err := parseEnhancedPrompt(answer)
+		require.NoError(t, err)
+		require.Equal(t, "masterpiece, best quality, (cat:1.2), sitting", prompt)
+		require.Equal(t, "lowres, bad anatomy", negative)
+
+		output := formatEnhancedPrompt(prompt, negative)
+		require.Equal(t, answer, output)
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		answer := "```\n**Prompt**: (dog:1.3), grass\n**Negative Prompt**: blurry\n```"
+		prompt, negative, err := parseEnhancedPrompt(answer)
+		require.NoError(t, err)
+		require.Equal(t, "(dog:1.3), grass", prompt)
+		require.Equal(t, "blurry", negative)
+	})
+
+	t.Run("without negative", func(t *testing.T) {
+		prompt, negative, err := parseEnhancedPrompt("prompt: cat")
+		require.NoError(t, err)
+		require.Equal(t, "cat", prompt)
+		require.Empty(t, negative)
+		require.Equal(t, "prompt: cat", formatEnhancedPrompt(prompt, negative))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, _, err := parseEnhancedPrompt("一只猫")
+		require.Error(t, err)
+	})
+}
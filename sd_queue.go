@@ -0,0 +1,487 @@
+package deepbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wdvxdr1123/ZeroBot"
+)
+
+const (
+	defaultDrawQueueSize = 16
+	maxUserDrawJobs      = 3
+	maxDrawJobRetries    = 2
+
+	drawProgressPeriod   = 2 * time.Second
+	drawDispatchPeriod   = 3 * time.Second
+	defaultDrawReport    = 20 * time.Second
+	defaultDrawStallTime = 120 * time.Second
+	defaultDrawWaitTime  = 300 * time.Second
+)
+
+var (
+	errDrawQueueFull  = errors.New("draw queue is full")
+	errUserJobsLimit  = errors.New("too many draw jobs about user")
+	errDrawJobStalled = errors.New("draw job is stalled")
+	errDrawJobAborted = errors.New("draw job is cancelled")
+)
+
+// drawJob is a drawing task in queue, the run function will send the
+// result to the user, so the queue only need to care about the progress.
+type drawJob struct {
+	ID      uint64
+	UserID  int64
+	Name    string
+	Require drawRequire
+
+	ctx *zero.Ctx
+	run func(ctx context.Context, backend drawBackend) error
+
+	// done is used to receive the result if the caller wait the job,
+	// the failure will be returned instead of sending to the user.
+	done chan error
+
+	// about the running job
+	node      *drawNode
+	cancel    context.CancelFunc
+	cancelled bool
+	retries   int
+	progress  float64
+	eta       float64
+}
+
+// sdProgress is the response about /sdapi/v1/progress.
+type sdProgress struct {
+	Progress    float64 `json:"progress"`
+	ETARelative float64 `json:"eta_relative"`
+	State       struct {
+		Interrupted   bool `json:"interrupted"`
+		SamplingStep  int  `json:"sampling_step"`
+		SamplingSteps int  `json:"sampling_steps"`
+	} `json:"state"`
+}
+
+// drawQueue is used to dispatch the drawing jobs to the backends, because
+// each backend will serialize them internally, and the blocking request
+// may reach the timeout when there are many jobs.
+type drawQueue struct {
+	bot *DeepBot
+
+	jobs    []*drawJob
+	running []*drawJob
+	counter uint64
+	mu      sync.Mutex
+
+	signal chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newDrawQueue(bot *DeepBot) *drawQueue {
+	queue := drawQueue{
+		bot:    bot,
+		signal: make(chan struct{}, 1),
+	}
+	queue.ctx, queue.cancel = context.WithCancel(context.Background())
+	queue.wg.Add(1)
+	go queue.worker()
+	return &queue
+}
+
+// Submit is used to add a job to the queue, it will return
+// the number of jobs that before the new job.
+func (q *drawQueue) Submit(ctx *zero.Ctx, name string, require drawRequire, run func(ctx context.Context, backend drawBackend) error) (int, error) {
+	job := drawJob{
+		Name:    name,
+		Require: require,
+		ctx:     ctx,
+		run:     run,
+	}
+	return q.submit(&job)
+}
+
+// Wait is used to add a job to the queue and wait it is finished, the job
+// will be cancelled if the context is done before it is finished.
+func (q *drawQueue) Wait(ctx context.Context, zc *zero.Ctx, name string, require drawRequire, run func(ctx context.Context, backend drawBackend) error) error {
+	job := &drawJob{
+		Name:    name,
+		Require: require,
+		ctx:     zc,
+		run:     run,
+		done:    make(chan error, 1),
+	}
+	_, err := q.submit(job)
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-job.done:
+		return err
+	case <-ctx.Done():
+		q.cancelJobs(func(j *drawJob) bool { return j == job })
+		return ctx.Err()
+	}
+}
+
+func (q *drawQueue) submit(job *drawJob) (int, error) {
+	if !q.bot.drawBackends.Supported(job.Require) {
+		return 0, errNoDrawBackend
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	size := q.bot.config.SDWebUI.QueueSize
+	if size < 1 {
+		size = defaultDrawQueueSize
+	}
+	if len(q.jobs) >= size {
+		return 0, errDrawQueueFull
+	}
+	userID := job.ctx.Event.UserID
+	n := 0
+	for _, job := range append(q.running, q.jobs...) {
+		if job.UserID == userID {
+			n++
+		}
+	}
+	if n >= maxUserDrawJobs {
+		return 0, errUserJobsLimit
+	}
+	q.counter++
+	job.ID = q.counter
+	job.UserID = userID
+	q.jobs = append(q.jobs, job)
+	q.notify()
+	return len(q.running) + len(q.jobs) - 1, nil
+}
+
+// Status is used to get the status about the jobs of user.
+func (q *drawQueue) Status(userID int64) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var lines []string
+	for _, job := range q.running {
+		if job.UserID != userID {
+			continue
+		}
+		line := fmt.Sprintf("%s: 正在绘制，进度%.0f%%", job.Name, job.progress*100)
+		if job.eta > 0 {
+			line += fmt.Sprintf("，预计剩余%.0f秒", job.eta)
+		}
+		lines = append(lines, line)
+	}
+	for i, job := range q.jobs {
+		if job.UserID != userID {
+			continue
+		}
+		position := len(q.running) + i
+		lines = append(lines, fmt.Sprintf("%s: 排队中，前面还有%d个任务", job.Name, position))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Cancel is used to cancel all the jobs about user, it will
+// interrupt the backend if the job of user is running.
+func (q *drawQueue) Cancel(userID int64) int {
+	return q.cancelJobs(func(job *drawJob) bool {
+		return job.UserID == userID
+	})
+}
+
+// cancelJobs is used to cancel the waiting and running jobs that matched.
+func (q *drawQueue) cancelJobs(match func(job *drawJob) bool) int {
+	q.mu.Lock()
+	var (
+		jobs    []*drawJob
+		started []*drawJob
+		n       int
+	)
+	for _, job := range q.jobs {
+		if match(job) {
+			job.finish(errDrawJobAborted)
+			n++
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	q.jobs = jobs
+	for _, job := range q.running {
+		if !match(job) || job.cancelled {
+			continue
+		}
+		job.cancelled = true
+		n++
+		// the job is not started if cancel is nil
+		if job.cancel != nil {
+			started = append(started, job)
+		}
+	}
+	q.mu.Unlock()
+	// interrupt the backend without lock because it is a blocking request,
+	// the node and cancel of the cancelled job will not be changed
+	for _, job := range started {
+		q.interrupt(job)
+	}
+	return n
+}
+
+// interrupt must be called without lock, the job must be started.
+func (q *drawQueue) interrupt(job *drawJob) {
+	ctx, cancel := q.bot.sdContext()
+	defer cancel()
+	err := job.node.backend.Interrupt(ctx)
+	if err != nil {
+		log.Printf("failed to interrupt draw backend %s: %s\n", job.node.Name, err)
+	}
+	job.cancel()
+}
+
+func (q *drawQueue) Close() {
+	q.cancel()
+	q.wg.Wait()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.jobs {
+		job.finish(errDrawJobAborted)
+	}
+	q.jobs = nil
+}
+
+func (q *drawQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *drawQueue) worker() {
+	defer q.wg.Done()
+	// retry dispatch for the backend become online
+	ticker := time.NewTicker(drawDispatchPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.signal:
+		case <-ticker.C:
+		case <-q.ctx.Done():
+			return
+		}
+		q.dispatch()
+	}
+}
+
+// dispatch is used to start the waiting jobs in order if there is
+// any available backend that support it.
+func (q *drawQueue) dispatch() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.ctx.Err() != nil {
+		return
+	}
+	var jobs []*drawJob
+	for _, job := range q.jobs {
+		node := q.bot.drawBackends.Acquire(job.Require)
+		if node == nil {
+			jobs = append(jobs, job)
+			continue
+		}
+		job.node = node
+		q.running = append(q.running, job)
+		q.wg.Add(1)
+		go q.execute(job)
+	}
+	q.jobs = jobs
+}
+
+func (q *drawQueue) execute(job *drawJob) {
+	defer q.wg.Done()
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	q.mu.Lock()
+	job.cancel = cancel
+	cancelled := job.cancelled
+	q.mu.Unlock()
+
+	var err error
+	stalled := make(chan struct{})
+	if !cancelled {
+		monitorDone := make(chan struct{})
+		go func() {
+			defer close(monitorDone)
+			q.monitor(ctx, job, stalled)
+		}()
+		err = job.run(ctx, job.node.backend)
+		cancel()
+		<-monitorDone
+	}
+	q.bot.drawBackends.Release(job.node, err)
+	if !q.finish(job, err, stalled) {
+		return
+	}
+	q.bot.sendText(job.ctx, "画图失败")
+}
+
+// finish is used to remove the job from the running list, if the job is
+// failed because the backend is offline, it will be added to the queue
+// again. It will return true if the failure need be sent to the user.
+func (q *drawQueue) finish(job *drawJob, err error, stalled <-chan struct{}) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, j := range q.running {
+		if j == job {
+			q.running = append(q.running[:i], q.running[i+1:]...)
+			break
+		}
+	}
+	q.notify()
+	if job.cancelled || q.ctx.Err() != nil {
+		job.finish(errDrawJobAborted)
+		return false
+	}
+	if err == nil {
+		job.finish(nil)
+		return false
+	}
+	select {
+	case <-stalled:
+		err = errDrawJobStalled
+	default:
+		// retry with other backend if current backend is offline
+		if !q.bot.drawBackends.IsHealthy(job.node) && job.retries < maxDrawJobRetries {
+			log.Printf("retry draw job %s because backend %s is offline\n", job.Name, job.node.Name)
+			job.retries++
+			job.node = nil
+			job.cancel = nil
+			q.jobs = append([]*drawJob{job}, q.jobs...)
+			return false
+		}
+	}
+	log.Printf("failed to execute draw job %s with backend %s: %s\n", job.Name, job.node.Name, err)
+	return !job.finish(err)
+}
+
+// finish is used to send the result to the waiter, it will return
+// false if the job is not waited.
+func (job *drawJob) finish(err error) bool {
+	if job.done == nil {
+		return false
+	}
+	job.done <- err
+	return true
+}
+
+// monitor is used to poll the progress of backend, it will report the
+// progress to the user and interrupt the job if the progress is stalled.
+func (q *drawQueue) monitor(ctx context.Context, job *drawJob, stalled chan<- struct{}) {
+	cfg := q.bot.config.SDWebUI
+	report := time.Duration(cfg.ProgressReport) * time.Millisecond
+	if report <= 0 {
+		report = defaultDrawReport
+	}
+	stall := time.Duration(cfg.Timeout) * time.Millisecond
+	if stall <= 0 {
+		stall = defaultDrawStallTime
+	}
+	ticker := time.NewTicker(drawProgressPeriod)
+	defer ticker.Stop()
+	var (
+		lastProgress float64
+		lastChange   = time.Now()
+		lastReport   = time.Now()
+	)
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		progress, err := q.drawProgress(ctx, job.node.backend)
+		if errors.Is(err, errDrawNotSupported) {
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("failed to get draw progress:", err)
+			}
+			continue
+		}
+		q.mu.Lock()
+		job.progress = progress.Progress
+		job.eta = progress.ETARelative
+		q.mu.Unlock()
+
+		now := time.Now()
+		if progress.Progress != lastProgress {
+			lastProgress = progress.Progress
+			lastChange = now
+		}
+		if now.Sub(lastChange) > stall {
+			close(stalled)
+			q.interrupt(job)
+			return
+		}
+		if now.Sub(lastReport) >= report && progress.Progress > 0 && progress.Progress < 1 {
+			lastReport = now
+			msg := fmt.Sprintf("画图进度%.0f%%，预计剩余%.0f秒", progress.Progress*100, progress.ETARelative)
+			q.bot.sendText(job.ctx, msg)
+		}
+	}
+}
+
+func (q *drawQueue) drawProgress(ctx context.Context, backend drawBackend) (*sdProgress, error) {
+	ctx, cancel := context.WithTimeout(ctx, drawProgressPeriod*2)
+	defer cancel()
+	return backend.Progress(ctx)
+}
+
+// submitDrawJob is used to add a drawing job and send the queue position.
+func (bot *DeepBot) submitDrawJob(ctx *zero.Ctx, name string, require drawRequire, run func(ctx context.Context, backend drawBackend) error) {
+	position, err := bot.drawQueue.Submit(ctx, name, require, run)
+	switch {
+	case errors.Is(err, errNoDrawBackend):
+		bot.sendText(ctx, "没有支持该任务的画图后端")
+		return
+	case errors.Is(err, errDrawQueueFull):
+		bot.sendText(ctx, "画图队列已满，请稍后再试")
+		return
+	case errors.Is(err, errUserJobsLimit):
+		bot.sendText(ctx, fmt.Sprintf("最多同时提交%d个画图任务", maxUserDrawJobs))
+		return
+	}
+	if position == 0 {
+		bot.sendRandomWait(ctx)
+		return
+	}
+	bot.sendText(ctx, fmt.Sprintf("已加入画图队列，前面还有%d个任务", position))
+}
+
+func (bot *DeepBot) onDrawStatus(ctx *zero.Ctx) {
+	if !bot.config.SDWebUI.Enabled {
+		bot.sendText(ctx, "画图服务未启用")
+		return
+	}
+	status := bot.drawQueue.Status(ctx.Event.UserID)
+	if status == "" {
+		bot.sendText(ctx, "当前没有画图任务")
+		return
+	}
+	bot.sendText(ctx, status)
+}
+
+func (bot *DeepBot) onCancelDraw(ctx *zero.Ctx) {
+	if !bot.config.SDWebUI.Enabled {
+		bot.sendText(ctx, "画图服务未启用")
+		return
+	}
+	n := bot.drawQueue.Cancel(ctx.Event.UserID)
+	if n == 0 {
+		bot.sendText(ctx, "当前没有画图任务")
+		return
+	}
+	bot.sendText(ctx, fmt.Sprintf("已取消%d个画图任务", n))
+}
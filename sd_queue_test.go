@@ -0,0 +1,265 @@
+package deepbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/wdvxdr1123/ZeroBot"
+)
+
+func testUserCtx(uid int64) *zero.Ctx {
+	return &zero.Ctx{Event: &zero.Event{UserID: uid}}
+}
+
+func TestDrawQueue(t *testing.T) {
+	var interrupted atomic.Bool
+	interrupting := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sdapi/v1/interrupt":
+			// the unexpected method will not interrupt the job
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			close(interrupting)
+			// the slow interrupt must not block the queue
+			time.Sleep(500 * time.Millisecond)
+			interrupted.Store(true)
+			_, _ = w.Write([]byte("{}"))
+		case "/sdapi/v1/progress":
+			_, _ = w.Write([]byte(`{"progress":0.5,"eta_relative":10}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.SDWebUI.URL = server.URL
+	config.SDWebUI.Timeout = 3000
+	bot := &DeepBot{config: config}
+	bot.drawBackends = newDrawBackends(config)
+	defer bot.drawBackends.Close()
+	queue := newDrawQueue(bot)
+	defer queue.Close()
+
+	need := drawRequire{Kind: drawKindTxt2Img}
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	position, err := queue.Submit(testUserCtx(1), "pic", need, func(ctx context.Context, _ drawBackend) error {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+	require.NoError(t, err)
+	require.Zero(t, position)
+	<-started
+
+	noop := func(context.Context, drawBackend) error {
+		t.Error("cancelled job is executed")
+		return nil
+	}
+	position, err = queue.Submit(testUserCtx(1), "picx", need, noop)
+	require.NoError(t, err)
+	require.Equal(t, 1, position)
+
+	finished := make(chan struct{})
+	position, err = queue.Submit(testUserCtx(2), "pic", need, func(context.Context, drawBackend) error {
+		close(finished)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, position)
+
+	position, err = queue.Submit(testUserCtx(1), "upscale", need, noop)
+	require.NoError(t, err)
+	require.Equal(t, 3, position)
+	_, err = queue.Submit(testUserCtx(1), "pic", need, noop)
+	require.ErrorIs(t, err, errUserJobsLimit)
+
+	status := queue.Status(1)
+	expected := "pic: 正在绘制，进度0%\npicx: 排队中，前面还有1个任务\nupscale: 排队中，前面还有3个任务"
+	require.Equal(t, expected, status)
+	require.Empty(t, queue.Status(3))
+
+	n := make(chan int, 1)
+	go func() {
+		n <- queue.Cancel(1)
+	}()
+	<-interrupting
+	require.NotContains(t, queue.Status(1), "排队中")
+	require.False(t, interrupted.Load())
+	require.Equal(t, 3, <-n)
+	require.Zero(t, queue.Cancel(1))
+	require.True(t, interrupted.Load())
+
+	select {
+	case <-cancelled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("running job is not cancelled")
+	}
+	select {
+	case <-finished:
+	case <-time.After(3 * time.Second):
+		t.Fatal("next job is not executed")
+	}
+}
+
+func TestDrawQueueFull(t *testing.T) {
+	config := new(Config)
+	config.SDWebUI.URL = "http://127.0.0.1:1/"
+	config.SDWebUI.QueueSize = 1
+	bot := &DeepBot{config: config}
+	bot.drawBackends = newDrawBackends(config)
+	defer bot.drawBackends.Close()
+	queue := &drawQueue{
+		bot:    bot,
+		signal: make(chan struct{}, 1),
+	}
+	queue.ctx, queue.cancel = context.WithCancel(context.Background())
+	defer queue.cancel()
+
+	need := drawRequire{Kind: drawKindTxt2Img}
+	noop := func(context.Context, drawBackend) error { return nil }
+	_, err := queue.Submit(testUserCtx(1), "pic", need, noop)
+	require.NoError(t, err)
+	_, err = queue.Submit(testUserCtx(2), "pic", need, noop)
+	require.ErrorIs(t, err, errDrawQueueFull)
+}
+
+func TestDrawQueueFailover(t *testing.T) {
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+	online := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sdapi/v1/txt2img":
+			_, _ = w.Write([]byte(`{"images":["b2s="],"info":"{\"seed\":7}"}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer online.Close()
+
+	cfg := fmt.Sprintf(`
+[sd_webui]
+  timeout = 3000
+
+[[sd_webui.backends]]
+  name = "offline"
+  url  = "%s"
+
+[[sd_webui.backends]]
+  name = "online"
+  url  = "%s"
+`, offline.URL, online.URL)
+	config := new(Config)
+	err := toml.Unmarshal([]byte(cfg), config)
+	require.NoError(t, err)
+	bot := &DeepBot{config: config}
+	bot.drawBackends = newDrawBackends(config)
+	defer bot.drawBackends.Close()
+	queue := newDrawQueue(bot)
+	defer queue.Close()
+
+	type drawResult struct {
+		image []byte
+		seed  int64
+	}
+	result := make(chan drawResult, 1)
+	need := drawRequire{Kind: drawKindTxt2Img}
+	_, err = queue.Submit(testUserCtx(1), "pic", need, func(ctx context.Context, backend drawBackend) error {
+		images, info, err := backend.Txt2Img(ctx, &txt2Image{Prompt: "girl"})
+		if err != nil {
+			return err
+		}
+		result <- drawResult{image: images[0], seed: info.Seed}
+		return nil
+	})
+	require.NoError(t, err)
+
+	select {
+	case r := <-result:
+		require.Equal(t, []byte("ok"), r.image)
+		require.Equal(t, int64(7), r.seed)
+	case <-time.After(10 * time.Second):
+		t.Fatal("job is not retried with other backend")
+	}
+	require.False(t, bot.drawBackends.IsHealthy(bot.drawBackends.nodes[0]))
+}
+
+func TestDrawQueueWait(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.SDWebUI.URL = server.URL
+	config.SDWebUI.Timeout = 3000
+	bot := &DeepBot{config: config}
+	bot.drawBackends = newDrawBackends(config)
+	defer bot.drawBackends.Close()
+	need := drawRequire{Kind: drawKindTxt2Img}
+
+	t.Run("common", func(t *testing.T) {
+		queue := newDrawQueue(bot)
+		defer queue.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var executed bool
+		err := queue.Wait(ctx, testUserCtx(1), "emoticon", need, func(context.Context, drawBackend) error {
+			executed = true
+			return nil
+		})
+		require.NoError(t, err)
+		require.True(t, executed)
+
+		// the failure is returned instead of sending to the user
+		testErr := errors.New("test error")
+		err = queue.Wait(ctx, testUserCtx(1), "emoticon", need, func(context.Context, drawBackend) error {
+			return testErr
+		})
+		require.ErrorIs(t, err, testErr)
+	})
+
+	t.Run("cancel waiting job", func(t *testing.T) {
+		// the queue without worker will not dispatch the job
+		queue := &drawQueue{
+			bot:    bot,
+			signal: make(chan struct{}, 1),
+		}
+		queue.ctx, queue.cancel = context.WithCancel(context.Background())
+
+		noop := func(context.Context, drawBackend) error {
+			t.Error("cancelled job is executed")
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err := queue.Wait(ctx, testUserCtx(1), "emoticon", need, noop)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Empty(t, queue.Status(1))
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- queue.Wait(context.Background(), testUserCtx(1), "emoticon", need, noop)
+		}()
+		require.Eventually(t, func() bool {
+			return queue.Status(1) != ""
+		}, 3*time.Second, 10*time.Millisecond)
+		require.Equal(t, 1, queue.Cancel(1))
+		require.ErrorIs(t, <-errCh, errDrawJobAborted)
+	})
+}
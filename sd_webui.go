@@ -2,8 +2,11 @@ package deepbot
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"math/rand/v2"
@@ -37,8 +40,37 @@ type txt2Image struct {
 	SaveImages bool `json:"save_images"`
 
 	Seed int64 `json:"seed"`
+
+	Subseed         int64   `json:"subseed,omitempty"`
+	SubseedStrength float64 `json:"subseed_strength,omitempty"`
+
+	OverrideSettings map[string]any `json:"override_settings,omitempty"`
 }
 
+type img2Image struct {
+	txt2Image
+
+	InitImages        []string `json:"init_images"`
+	DenoisingStrength float64  `json:"denoising_strength"`
+	ResizeMode        int      `json:"resize_mode"`
+
+	Mask           string `json:"mask,omitempty"`
+	MaskBlur       int    `json:"mask_blur,omitempty"`
+	InpaintingFill int    `json:"inpainting_fill,omitempty"`
+	InpaintFullRes bool   `json:"inpaint_full_res,omitempty"`
+}
+
+type upscaleImage struct {
+	Image           string  `json:"image"`
+	UpscalingResize float64 `json:"upscaling_resize"`
+	Upscaler1       string  `json:"upscaler_1"`
+}
+
+const (
+	maxAutoResolution = 1536
+	defaultUpscaler   = "R-ESRGAN 4x+"
+)
+
 func (bot *DeepBot) onDrawImage(ctx *zero.Ctx) {
 	if !bot.config.SDWebUI.Enabled {
 		bot.sendText(ctx, "画图服务未启用")
@@ -50,19 +82,49 @@ func (bot *DeepBot) onDrawImage(ctx *zero.Ctx) {
 		bot.sendText(ctx, "非法参数格式")
 		return
 	}
-	prompt := args[1]
-	if prompt == " " || prompt == "" {
+	flags, prompt := textToFlags(args[1], "raw")
+	if len(flags) > 1 || (len(flags) == 1 && flags["raw"] == "") {
+		bot.sendText(ctx, "非法参数格式")
+		return
+	}
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
 		bot.sendText(ctx, "非法参数格式")
 		return
 	}
 
-	bot.sendRandomWait(ctx)
-	img, err := bot.drawImage(prompt, 30, 1024, 1024)
+	arg, err := bot.loadDrawArgs()
 	if err != nil {
-		log.Println("failed to draw image:", err)
+		log.Println("failed to load draw arguments:", err)
 		return
 	}
-	sendImage(ctx, img)
+	draw := drawArgs{Prompt: prompt, Width: 1024, Height: 1024}
+	if bot.config.SDWebUI.EnhancePrompt && flags["raw"] == "" {
+		prompt, negative, err := bot.enhanceDrawPrompt(prompt)
+		if err == nil {
+			draw.Prompt = prompt
+			draw.NegPrompt = negative
+			// the enhanced prompt is from model, so it need be moderated
+			text, ok := bot.moderateText(ctx, formatEnhancedPrompt(prompt, negative))
+			if ok {
+				bot.sendText(ctx, text)
+			}
+		} else {
+			log.Println("failed to enhance draw prompt:", err)
+		}
+	}
+	draw.apply(arg)
+
+	require := newDrawRequire(drawKindTxt2Img, "", arg.Prompt)
+	bot.submitDrawJob(ctx, "pic", require, func(c context.Context, backend drawBackend) error {
+		images, info, err := backend.Txt2Img(c, arg)
+		if err != nil {
+			return err
+		}
+		bot.sendImage(ctx, images[0])
+		bot.saveToGallery(ctx, "pic", arg, info, "", images[:1])
+		return nil
+	})
 }
 
 func (bot *DeepBot) onDrawImageWithArgs(ctx *zero.Ctx) {
@@ -71,20 +133,70 @@ func (bot *DeepBot) onDrawImageWithArgs(ctx *zero.Ctx) {
 		return
 	}
 
-	args := textToArgN(ctx.MessageString(), 4)
-	if len(args) != 4 {
+	args := textToArgN(ctx.MessageString(), 2)
+	if len(args) != 2 {
 		bot.sendText(ctx, "非法参数格式")
 		return
 	}
+	picxArgs, err := parseDrawArgs(args[1])
+	if err != nil {
+		bot.sendText(ctx, err.Error())
+		return
+	}
+	require, err := bot.validateDrawArgs(picxArgs)
+	if err != nil {
+		bot.sendText(ctx, err.Error())
+		return
+	}
+	arg, err := bot.loadDrawArgs()
+	if err != nil {
+		log.Println("failed to load draw arguments:", err)
+		return
+	}
+	picxArgs.apply(arg)
+
+	bot.submitDrawJob(ctx, "picx", require, func(c context.Context, backend drawBackend) error {
+		images, info, err := backend.Txt2Img(c, arg)
+		if err != nil {
+			return err
+		}
+		for _, img := range images {
+			bot.sendImage(ctx, img)
+		}
+		ids := bot.saveToGallery(ctx, "picx", arg, info, require.Model, images)
+		sendText(ctx, formatGalleryArgs(arg, info, picxArgs.Model, ids), true)
+		return nil
+	})
+}
+
+func (bot *DeepBot) onImageToImage(ctx *zero.Ctx) {
+	bot.onRedrawImage(ctx, false)
+}
 
+func (bot *DeepBot) onInpaintImage(ctx *zero.Ctx) {
+	bot.onRedrawImage(ctx, true)
+}
+
+func (bot *DeepBot) onRedrawImage(ctx *zero.Ctx, inpaint bool) {
+	if !bot.config.SDWebUI.Enabled {
+		bot.sendText(ctx, "画图服务未启用")
+		return
+	}
+
+	args := textToArgN(ctx.Event.Message.ExtractPlainText(), 4)
+	if len(args) != 4 {
+		bot.sendText(ctx, "非法参数格式")
+		return
+	}
 	width, height, ok := parseResolution(args[1])
-	if !ok {
+	auto := strings.ToLower(args[1]) == "auto"
+	if !ok && !auto {
 		bot.sendText(ctx, "非法的分辨率参数")
 		return
 	}
-	steps, err := strconv.Atoi(args[2])
-	if err != nil || steps < 1 {
-		bot.sendText(ctx, "非法的steps参数")
+	strength, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || strength < 0 || strength > 1 {
+		bot.sendText(ctx, "非法的重绘幅度参数")
 		return
 	}
 	prompt := args[3]
@@ -93,13 +205,98 @@ func (bot *DeepBot) onDrawImageWithArgs(ctx *zero.Ctx) {
 		return
 	}
 
-	bot.sendRandomWait(ctx)
-	img, err := bot.drawImage(prompt, steps, width, height)
-	if err != nil {
-		log.Println("failed to draw image:", err)
+	n := 1
+	if inpaint {
+		n = 2
+	}
+	images := bot.readMessageImages(ctx, n)
+	if images == nil {
+		if inpaint {
+			bot.sendText(ctx, "请发送或回复原图与蒙版两张图片")
+		} else {
+			bot.sendText(ctx, "请发送或回复一张图片")
+		}
 		return
 	}
-	sendImage(ctx, img)
+	if auto {
+		w, h, err := imageSize(images[0])
+		if err != nil {
+			bot.sendText(ctx, "无法读取图片尺寸")
+			return
+		}
+		width, height = autoResolution(w, h)
+	}
+
+	var mask []byte
+	name := "img2img"
+	if inpaint {
+		mask = images[1]
+		name = "inpaint"
+	}
+	require := newDrawRequire(drawKindImg2Img, "", prompt)
+	bot.submitDrawJob(ctx, name, require, func(c context.Context, backend drawBackend) error {
+		img, err := bot.redrawImage(c, backend, images[0], mask, prompt, strength, width, height)
+		if err != nil {
+			return err
+		}
+		bot.sendImage(ctx, img)
+		return nil
+	})
+}
+
+func (bot *DeepBot) onUpscaleImage(ctx *zero.Ctx) {
+	if !bot.config.SDWebUI.Enabled {
+		bot.sendText(ctx, "画图服务未启用")
+		return
+	}
+
+	scale := 2.0
+	args := textToArgN(ctx.Event.Message.ExtractPlainText(), 2)
+	if len(args) == 2 {
+		var err error
+		scale, err = strconv.ParseFloat(args[1], 64)
+		if err != nil || scale < 1 || scale > 4 {
+			bot.sendText(ctx, "非法的倍数参数")
+			return
+		}
+	}
+	images := bot.readMessageImages(ctx, 1)
+	if images == nil {
+		bot.sendText(ctx, "请发送或回复一张图片")
+		return
+	}
+
+	require := drawRequire{Kind: drawKindUpscale}
+	bot.submitDrawJob(ctx, "upscale", require, func(c context.Context, backend drawBackend) error {
+		img, err := bot.upscaleImage(c, backend, images[0], scale)
+		if err != nil {
+			return err
+		}
+		bot.sendImage(ctx, img)
+		return nil
+	})
+}
+
+// readMessageImages is used to download the first n images in the message
+// or the replied message, it will return nil if the images are not enough.
+func (bot *DeepBot) readMessageImages(ctx *zero.Ctx, n int) [][]byte {
+	segments := messageImages(ctx, true)
+	if len(segments) < n {
+		return nil
+	}
+	timeout := time.Duration(bot.config.SDWebUI.Timeout) * time.Millisecond
+	c, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	images := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		img, err := fetchImage(c, ctx, segments[i])
+		if err != nil {
+			log.Println("failed to fetch image:", err)
+			return nil
+		}
+		images[i] = img
+	}
+	return images
 }
 
 func (bot *DeepBot) sendRandomWait(ctx *zero.Ctx) {
@@ -113,42 +310,13 @@ func (bot *DeepBot) sendRandomWait(ctx *zero.Ctx) {
 	}
 }
 
-func (bot *DeepBot) drawImage(prompt string, steps, width, height int) ([]byte, error) {
-	cfg := bot.config.SDWebUI
-
-	timeout := time.Duration(cfg.Timeout) * time.Millisecond
-	tr := http.Transport{}
-	client := http.Client{
-		Transport: &tr,
-		Timeout:   timeout,
-	}
-	defer client.CloseIdleConnections()
-
-	URL, err := url.JoinPath(cfg.URL, "/sdapi/v1/txt2img")
+// drawImage is used to draw the image in queue and wait the result, the
+// image will be saved to the gallery about the user in context.
+func (bot *DeepBot) drawImage(ctx *zero.Ctx, prompt string, steps, width, height int) ([]byte, error) {
+	arg, err := bot.loadDrawArgs()
 	if err != nil {
 		return nil, err
 	}
-	var arg txt2Image
-	if cfg.Config != "" {
-		data, err := os.ReadFile(cfg.Config)
-		if err != nil {
-			return nil, err
-		}
-		err = json.Unmarshal(data, &arg)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		arg = txt2Image{
-			NegPrompt:   "",
-			SamplerName: "Euler a",
-			Scheduler:   "Normal",
-			BatchSize:   1,
-			BatchCount:  1,
-			DisCFGScale: 3.5,
-			CFGScale:    7,
-		}
-	}
 	arg.Prompt = prompt
 	arg.Steps = steps
 	arg.Width = width
@@ -157,40 +325,256 @@ func (bot *DeepBot) drawImage(prompt string, steps, width, height int) ([]byte,
 	arg.SaveImages = true
 	arg.Seed = -1
 
-	data, err := jsonEncode(arg)
+	var image []byte
+	run := func(c context.Context, backend drawBackend) error {
+		images, info, err := backend.Txt2Img(c, arg)
+		if err != nil {
+			return err
+		}
+		bot.saveToGallery(ctx, "emoticon", arg, info, "", images[:1])
+		image = images[0]
+		return nil
+	}
+	c, cancel := context.WithTimeout(context.Background(), defaultDrawWaitTime)
+	defer cancel()
+	require := newDrawRequire(drawKindTxt2Img, "", prompt)
+	err = bot.drawQueue.Wait(c, ctx, "emoticon", require, run)
 	if err != nil {
 		return nil, err
 	}
+	return image, nil
+}
+
+// sdImageInfo is the generation information in the response,
+// it is used to get the real seed when the seed is random.
+type sdImageInfo struct {
+	Seed        int64   `json:"seed"`
+	AllSeeds    []int64 `json:"all_seeds"`
+	Subseed     int64   `json:"subseed"`
+	AllSubseeds []int64 `json:"all_subseeds"`
+	ModelName   string  `json:"sd_model_name"`
+}
 
-	req, err := http.NewRequest(http.MethodPost, URL, bytes.NewReader(data))
+func (bot *DeepBot) redrawImage(ctx context.Context, backend drawBackend, image, mask []byte, prompt string, strength float64, width, height int) ([]byte, error) {
+	base, err := bot.loadDrawArgs()
 	if err != nil {
 		return nil, err
 	}
-	resp, err := client.Do(req)
+	arg := img2Image{txt2Image: *base}
+	arg.Prompt = prompt
+	if arg.Steps < 1 {
+		arg.Steps = 30
+	}
+	arg.Width = width
+	arg.Height = height
+	arg.SendImages = true
+	arg.SaveImages = true
+	arg.Seed = -1
+	arg.InitImages = []string{base64.StdEncoding.EncodeToString(image)}
+	arg.DenoisingStrength = strength
+	if mask != nil {
+		arg.Mask = base64.StdEncoding.EncodeToString(mask)
+		arg.MaskBlur = 4
+		arg.InpaintingFill = 1 // original
+		arg.InpaintFullRes = true
+	}
+
+	return backend.Img2Img(ctx, &arg)
+}
+
+func (bot *DeepBot) upscaleImage(ctx context.Context, backend drawBackend, image []byte, scale float64) ([]byte, error) {
+	upscaler := bot.config.SDWebUI.Upscaler
+	if upscaler == "" {
+		upscaler = defaultUpscaler
+	}
+	arg := upscaleImage{
+		Image:           base64.StdEncoding.EncodeToString(image),
+		UpscalingResize: scale,
+		Upscaler1:       upscaler,
+	}
+	return backend.Upscale(ctx, &arg)
+}
+
+// loadDrawArgs is used to load the default arguments from the config file.
+func (bot *DeepBot) loadDrawArgs() (*txt2Image, error) {
+	cfg := bot.config.SDWebUI
+	if cfg.Config == "" {
+		arg := txt2Image{
+			NegPrompt:   "",
+			SamplerName: "Euler a",
+			Scheduler:   "Normal",
+			BatchSize:   1,
+			BatchCount:  1,
+			DisCFGScale: 3.5,
+			CFGScale:    7,
+		}
+		return &arg, nil
+	}
+	data, err := os.ReadFile(cfg.Config)
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = resp.Body.Close() }()
-	data, err = io.ReadAll(resp.Body)
+	var arg txt2Image
+	err = json.Unmarshal(data, &arg)
 	if err != nil {
 		return nil, err
 	}
+	return &arg, nil
+}
 
-	type result struct {
+// sdContext is used to create the context with the timeout in config.
+func (bot *DeepBot) sdContext() (context.Context, context.CancelFunc) {
+	timeout := time.Duration(bot.config.SDWebUI.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// sdWebUI is the draw backend about the SD-WebUI API.
+type sdWebUI struct {
+	URL string
+}
+
+func newSDWebUI(URL string) *sdWebUI {
+	return &sdWebUI{URL: URL}
+}
+
+func (sd *sdWebUI) Txt2Img(ctx context.Context, arg *txt2Image) ([][]byte, *sdImageInfo, error) {
+	var result struct {
 		Images     []string `json:"images"`
 		Parameters any      `json:"parameters"`
 		Info       string   `json:"info"`
 	}
-	var results result
-	err = jsonDecode(data, &results)
+	err := sd.call(ctx, http.MethodPost, "/sdapi/v1/txt2img", arg, &result)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(result.Images) == 0 {
+		return nil, nil, errors.New("receive empty image")
+	}
+	var images [][]byte
+	for _, image := range result.Images {
+		img, err := base64.StdEncoding.DecodeString(image)
+		if err != nil {
+			return nil, nil, err
+		}
+		images = append(images, img)
+	}
+	// skip the grid image at the front when generate multi images
+	total := max(arg.BatchSize, 1) * max(arg.BatchCount, 1)
+	if len(images) > total {
+		images = images[len(images)-total:]
+	}
+	info := sdImageInfo{Seed: arg.Seed}
+	if result.Info != "" {
+		err = json.Unmarshal([]byte(result.Info), &info)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return images, &info, nil
+}
+
+func (sd *sdWebUI) Img2Img(ctx context.Context, arg *img2Image) ([]byte, error) {
+	var result struct {
+		Images []string `json:"images"`
+		Info   string   `json:"info"`
+	}
+	err := sd.call(ctx, http.MethodPost, "/sdapi/v1/img2img", arg, &result)
 	if err != nil {
 		return nil, err
 	}
-	img, err := base64.StdEncoding.DecodeString(results.Images[0])
+	return decodeSDImage(result.Images)
+}
+
+func (sd *sdWebUI) Upscale(ctx context.Context, arg *upscaleImage) ([]byte, error) {
+	var result struct {
+		Image string `json:"image"`
+	}
+	err := sd.call(ctx, http.MethodPost, "/sdapi/v1/extra-single-image", arg, &result)
 	if err != nil {
 		return nil, err
 	}
-	return img, nil
+	return decodeSDImage([]string{result.Image})
+}
+
+func (sd *sdWebUI) Progress(ctx context.Context) (*sdProgress, error) {
+	var progress sdProgress
+	path := "/sdapi/v1/progress?skip_current_image=true"
+	err := sd.call(ctx, http.MethodGet, path, nil, &progress)
+	if err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+func (sd *sdWebUI) Interrupt(ctx context.Context) error {
+	var result any
+	return sd.call(ctx, http.MethodPost, "/sdapi/v1/interrupt", nil, &result)
+}
+
+func (sd *sdWebUI) Ping(ctx context.Context) error {
+	var result any
+	return sd.call(ctx, http.MethodGet, "/sdapi/v1/progress?skip_current_image=true", nil, &result)
+}
+
+func (sd *sdWebUI) call(ctx context.Context, method, path string, arg, result any) error {
+	tr := http.Transport{}
+	client := http.Client{
+		Transport: &tr,
+	}
+	defer client.CloseIdleConnections()
+
+	path, query, _ := strings.Cut(path, "?")
+	URL, err := url.JoinPath(sd.URL, path)
+	if err != nil {
+		return err
+	}
+	if query != "" {
+		URL += "?" + query
+	}
+	var body io.Reader
+	if arg != nil {
+		data, err := jsonEncode(arg)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, URL, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(data) > 256 {
+			data = data[:256]
+		}
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, data)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return jsonDecode(data, result)
+}
+
+func decodeSDImage(images []string) ([]byte, error) {
+	if len(images) == 0 || images[0] == "" {
+		return nil, errors.New("receive empty image")
+	}
+	return base64.StdEncoding.DecodeString(images[0])
 }
 
 func parseResolution(s string) (int, int, bool) {
@@ -219,3 +603,16 @@ func parseResolution(s string) (int, int, bool) {
 	}
 	return width, height, true
 }
+
+// autoResolution is used to keep the aspect ratio of the source image,
+// the longest side will be limited and the size must be a multiple of 8.
+func autoResolution(width, height int) (int, int) {
+	longest := max(width, height)
+	if longest > maxAutoResolution {
+		width = width * maxAutoResolution / longest
+		height = height * maxAutoResolution / longest
+	}
+	width = max(width/8*8, 8)
+	height = max(height/8*8, 8)
+	return width, height
+}
@@ -1 +1,136 @@
 package deepbot
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoResolution(t *testing.T) {
+	for _, item := range []struct {
+		width, height int
+		expectW       int
+		expectH       int
+	}{
+		{512, 768, 512, 768},
+		{3072, 2048, 1536, 1024},
+		{1000, 3000, 512, 1536},
+		{1023, 517, 1016, 512},
+		{4, 4, 8, 8},
+	} {
+		width, height := autoResolution(item.width, item.height)
+		require.Equal(t, item.expectW, width)
+		require.Equal(t, item.expectH, height)
+	}
+}
+
+func TestRedrawImage(t *testing.T) {
+	img := testPNGImage(t)
+	output := []byte("output")
+	// record the requests and check them in the test goroutine
+	var (
+		paths    []string
+		requests []*img2Image
+		mu       sync.Mutex
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := new(img2Image)
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		requests = append(requests, req)
+		mu.Unlock()
+
+		resp := map[string]any{
+			"images": []string{base64.StdEncoding.EncodeToString(output)},
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.SDWebUI.URL = server.URL
+	config.SDWebUI.Timeout = 3000
+	bot := &DeepBot{config: config}
+
+	data, err := bot.redrawImage(context.Background(), newSDWebUI(server.URL), img, nil, "girl", 0.6, 768, 512)
+	require.NoError(t, err)
+	require.Equal(t, output, data)
+
+	data, err = bot.redrawImage(context.Background(), newSDWebUI(server.URL), img, img, "girl", 0.6, 768, 512)
+	require.NoError(t, err)
+	require.Equal(t, output, data)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"/sdapi/v1/img2img", "/sdapi/v1/img2img"}, paths)
+	require.Len(t, requests, 2)
+	for _, req := range requests {
+		require.Equal(t, "girl", req.Prompt)
+		require.Equal(t, 768, req.Width)
+		require.Equal(t, 0.6, req.DenoisingStrength)
+		require.Equal(t, base64.StdEncoding.EncodeToString(img), req.InitImages[0])
+		require.Equal(t, "Euler a", req.SamplerName)
+	}
+	require.Empty(t, requests[0].Mask)
+	require.NotEmpty(t, requests[1].Mask)
+	require.True(t, requests[1].InpaintFullRes)
+}
+
+func TestUpscaleImage(t *testing.T) {
+	img := testPNGImage(t)
+	var (
+		paths    []string
+		requests []*upscaleImage
+		mu       sync.Mutex
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := new(upscaleImage)
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		requests = append(requests, req)
+		mu.Unlock()
+
+		if req.Image == "" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"image": req.Image})
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.SDWebUI.URL = server.URL
+	config.SDWebUI.Timeout = 3000
+	bot := &DeepBot{config: config}
+
+	data, err := bot.upscaleImage(context.Background(), newSDWebUI(server.URL), img, 2)
+	require.NoError(t, err)
+	require.Equal(t, img, data)
+
+	_, err = bot.upscaleImage(context.Background(), newSDWebUI(server.URL), nil, 2)
+	require.Error(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"/sdapi/v1/extra-single-image", "/sdapi/v1/extra-single-image"}, paths)
+	for _, req := range requests {
+		require.Equal(t, 2.0, req.UpscalingResize)
+		require.Equal(t, defaultUpscaler, req.Upscaler1)
+	}
+}
@@ -0,0 +1,579 @@
+package deepbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	providerGoogle     = "google"
+	providerSearXNG    = "searxng"
+	providerBing       = "bing"
+	providerBrave      = "brave"
+	providerDuckDuckGo = "duckduckgo"
+)
+
+const (
+	defaultSearchLanguage = "zh-cn"
+	defaultSearchCount    = 10
+	maxSearchResponseSize = 4 * 1024 * 1024
+)
+
+var errImageNotSupported = errors.New("image search is not supported")
+
+// searchResult is the normalized result about all search providers,
+// for image search, the link is the URL about the image.
+type searchResult struct {
+	Title   string `json:"title"`
+	Link    string `json:"link"`
+	Snippet string `json:"snippet"`
+}
+
+type searchQuery struct {
+	Keyword  string
+	Image    bool
+	Size     string // only for image
+	Language string // like zh-cn
+	NoCache  bool   // skip the cached results
+}
+
+// searchProvider is the interface about the search engine backend.
+type searchProvider interface {
+	Name() string
+	Search(ctx context.Context, client *http.Client, query *searchQuery) ([]*searchResult, error)
+}
+
+// searcher is used to search with the providers in order, if one of
+// them is failed, the next provider will be used.
+type searcher struct {
+	providers []searchProvider
+	language  string
+	client    *http.Client
+	cache     *searchCache
+}
+
+func newSearcher(config *Config) *searcher {
+	cfg := config.SearchAPI
+	names := cfg.Providers
+	if len(names) == 0 {
+		names = []string{providerGoogle}
+	}
+	var providers []searchProvider
+	for _, name := range names {
+		var provider searchProvider
+		switch strings.ToLower(name) {
+		case providerGoogle:
+			gs := &googleSearch{
+				EngineID: cfg.Google.EngineID,
+				APIKey:   cfg.Google.APIKey,
+			}
+			// compatible with the top level config in old version
+			if gs.EngineID == "" && gs.APIKey == "" {
+				gs.EngineID = cfg.EngineID
+				gs.APIKey = cfg.APIKey
+			}
+			provider = gs
+		case providerSearXNG:
+			provider = &searxngSearch{
+				URL: cfg.SearXNG.URL,
+			}
+		case providerBing:
+			provider = &bingSearch{
+				Endpoint: cfg.Bing.Endpoint,
+				APIKey:   cfg.Bing.APIKey,
+			}
+		case providerBrave:
+			provider = &braveSearch{
+				APIKey: cfg.Brave.APIKey,
+			}
+		case providerDuckDuckGo:
+			provider = &duckduckgoSearch{}
+		default:
+			log.Println("[warning] unknown search provider:", name)
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	language := cfg.Language
+	if language == "" {
+		language = defaultSearchLanguage
+	}
+	tr := http.Transport{}
+	proxyURL := cfg.ProxyURL
+	if proxyURL != "" {
+		tr.Proxy = func(*http.Request) (*url.URL, error) {
+			return url.Parse(proxyURL)
+		}
+	}
+	client := http.Client{
+		Transport: &tr,
+	}
+	s := searcher{
+		providers: providers,
+		language:  strings.ToLower(language),
+		client:    &client,
+	}
+	if cfg.CacheTTL > 0 {
+		ttl := time.Duration(cfg.CacheTTL) * time.Second
+		s.cache = newSearchCache(defaultSearchCachePath, ttl, cfg.CacheSize)
+	}
+	return &s
+}
+
+// Search is used to search with the cache, the results are shared by all users.
+func (s *searcher) Search(ctx context.Context, query *searchQuery) ([]*searchResult, error) {
+	if query.Language == "" {
+		query.Language = s.language
+	}
+	if s.cache == nil {
+		return s.search(ctx, query)
+	}
+	return s.cache.Do(ctx, searchCacheKey(query), query.NoCache, func() ([]*searchResult, error) {
+		return s.search(ctx, query)
+	})
+}
+
+// search is used to search with providers in order until one is succeeded.
+func (s *searcher) search(ctx context.Context, query *searchQuery) ([]*searchResult, error) {
+	var errs []error
+	for _, provider := range s.providers {
+		results, err := provider.Search(ctx, s.client, query)
+		if err == nil {
+			return dedupResults(results), nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !errors.Is(err, errImageNotSupported) {
+			log.Printf("failed to search with %s: %s\n", provider.Name(), err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no available search provider")
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (s *searcher) Close() {
+	s.client.CloseIdleConnections()
+}
+
+// splitLanguage is used to split "zh-cn" to "zh" and "cn".
+func splitLanguage(language string) (string, string) {
+	lang, region, _ := strings.Cut(language, "-")
+	return lang, region
+}
+
+func doSearchRequest(client *http.Client, req *http.Request, result any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSearchResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(data) > 256 {
+			data = data[:256]
+		}
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, data)
+	}
+	if result == nil {
+		return nil
+	}
+	return jsonDecode(data, result)
+}
+
+// https://developers.google.com/custom-search/v1/reference/rest/v1/cse/list
+type googleSearch struct {
+	EngineID string
+	APIKey   string
+	Endpoint string // for test
+}
+
+func (gs *googleSearch) Name() string {
+	return providerGoogle
+}
+
+func (gs *googleSearch) Search(ctx context.Context, client *http.Client, query *searchQuery) ([]*searchResult, error) {
+	endpoint := gs.Endpoint
+	if endpoint == "" {
+		endpoint = "https://customsearch.googleapis.com/customsearch/v1"
+	}
+	values := url.Values{}
+	values.Set("cx", gs.EngineID)
+	values.Set("key", gs.APIKey)
+	values.Set("q", query.Keyword)
+	values.Set("safe", "active")
+	values.Set("hl", query.Language)
+	if query.Image {
+		values.Set("searchType", "image")
+		if query.Size != "" {
+			values.Set("imgSize", query.Size)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Items []*searchResult `json:"items"`
+	}
+	err = doSearchRequest(client, req, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Items, nil
+}
+
+// https://docs.searxng.org/dev/search_api.html
+type searxngSearch struct {
+	URL string
+}
+
+func (ss *searxngSearch) Name() string {
+	return providerSearXNG
+}
+
+func (ss *searxngSearch) Search(ctx context.Context, client *http.Client, query *searchQuery) ([]*searchResult, error) {
+	if ss.URL == "" {
+		return nil, errors.New("searxng url is empty")
+	}
+	lang, region := splitLanguage(query.Language)
+	if region != "" {
+		lang += "-" + strings.ToUpper(region)
+	}
+	values := url.Values{}
+	values.Set("q", query.Keyword)
+	values.Set("format", "json")
+	values.Set("language", lang)
+	values.Set("safesearch", "2")
+	if query.Image {
+		values.Set("categories", "images")
+	} else {
+		values.Set("categories", "general")
+	}
+	URL := strings.TrimSuffix(ss.URL, "/") + "/search?" + values.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+			ImgSrc  string `json:"img_src"`
+		} `json:"results"`
+	}
+	err = doSearchRequest(client, req, &result)
+	if err != nil {
+		return nil, err
+	}
+	var results []*searchResult
+	for _, item := range result.Results {
+		link := item.URL
+		if query.Image {
+			if item.ImgSrc == "" {
+				continue
+			}
+			link = item.ImgSrc
+		}
+		results = append(results, &searchResult{
+			Title:   item.Title,
+			Link:    link,
+			Snippet: item.Content,
+		})
+		if len(results) >= defaultSearchCount {
+			break
+		}
+	}
+	return results, nil
+}
+
+// https://learn.microsoft.com/en-us/bing/search-apis/bing-web-search/reference/endpoints
+type bingSearch struct {
+	Endpoint string
+	APIKey   string
+}
+
+func (bs *bingSearch) Name() string {
+	return providerBing
+}
+
+func (bs *bingSearch) Search(ctx context.Context, client *http.Client, query *searchQuery) ([]*searchResult, error) {
+	endpoint := bs.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.bing.microsoft.com"
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if query.Image {
+		endpoint += "/v7.0/images/search"
+	} else {
+		endpoint += "/v7.0/search"
+	}
+	lang, region := splitLanguage(query.Language)
+	values := url.Values{}
+	values.Set("q", query.Keyword)
+	values.Set("count", strconv.Itoa(defaultSearchCount))
+	values.Set("safeSearch", "Strict")
+	if region != "" {
+		values.Set("mkt", lang+"-"+strings.ToUpper(region))
+	}
+	if query.Image {
+		switch query.Size {
+		case "icon", "small":
+			values.Set("size", "Small")
+		case "medium":
+			values.Set("size", "Medium")
+		case "large", "xlarge":
+			values.Set("size", "Large")
+		case "xxlarge", "huge":
+			values.Set("size", "Wallpaper")
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Ocp-Apim-Subscription-Key", bs.APIKey)
+	var results []*searchResult
+	if query.Image {
+		var result struct {
+			Value []struct {
+				Name        string `json:"name"`
+				ContentURL  string `json:"contentUrl"`
+				HostPageURL string `json:"hostPageUrl"`
+			} `json:"value"`
+		}
+		err = doSearchRequest(client, req, &result)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Value {
+			results = append(results, &searchResult{
+				Title:   item.Name,
+				Link:    item.ContentURL,
+				Snippet: item.HostPageURL,
+			})
+		}
+		return results, nil
+	}
+	var result struct {
+		WebPages struct {
+			Value []struct {
+				Name    string `json:"name"`
+				URL     string `json:"url"`
+				Snippet string `json:"snippet"`
+			} `json:"value"`
+		} `json:"webPages"`
+	}
+	err = doSearchRequest(client, req, &result)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range result.WebPages.Value {
+		results = append(results, &searchResult{
+			Title:   item.Name,
+			Link:    item.URL,
+			Snippet: item.Snippet,
+		})
+	}
+	return results, nil
+}
+
+// https://api-dashboard.search.brave.com/app/documentation/web-search/get-started
+type braveSearch struct {
+	APIKey   string
+	Endpoint string // for test
+}
+
+func (bs *braveSearch) Name() string {
+	return providerBrave
+}
+
+func (bs *braveSearch) Search(ctx context.Context, client *http.Client, query *searchQuery) ([]*searchResult, error) {
+	endpoint := bs.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.search.brave.com"
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	values := url.Values{}
+	values.Set("q", query.Keyword)
+	values.Set("count", strconv.Itoa(defaultSearchCount))
+	values.Set("safesearch", "strict")
+	lang, region := splitLanguage(query.Language)
+	if lang == "zh" {
+		// brave only support the script subtag for chinese
+		if region == "tw" || region == "hk" {
+			lang = "zh-hant"
+		} else {
+			lang = "zh-hans"
+		}
+	}
+	values.Set("search_lang", lang)
+	if region != "" {
+		values.Set("country", strings.ToUpper(region))
+	}
+	if query.Image {
+		endpoint += "/res/v1/images/search"
+	} else {
+		endpoint += "/res/v1/web/search"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Subscription-Token", bs.APIKey)
+	var results []*searchResult
+	if query.Image {
+		var result struct {
+			Results []struct {
+				Title      string `json:"title"`
+				URL        string `json:"url"`
+				Properties struct {
+					URL string `json:"url"`
+				} `json:"properties"`
+			} `json:"results"`
+		}
+		err = doSearchRequest(client, req, &result)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Results {
+			results = append(results, &searchResult{
+				Title:   item.Title,
+				Link:    item.Properties.URL,
+				Snippet: item.URL,
+			})
+		}
+		return results, nil
+	}
+	var result struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	err = doSearchRequest(client, req, &result)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range result.Web.Results {
+		results = append(results, &searchResult{
+			Title:   item.Title,
+			Link:    item.URL,
+			Snippet: stripTags(item.Description),
+		})
+	}
+	return results, nil
+}
+
+// duckduckgoSearch is a scraper about the HTML version of DuckDuckGo,
+// it does not need any API key, but only support the web search.
+type duckduckgoSearch struct {
+	Endpoint string // for test
+}
+
+func (ds *duckduckgoSearch) Name() string {
+	return providerDuckDuckGo
+}
+
+func (ds *duckduckgoSearch) Search(ctx context.Context, client *http.Client, query *searchQuery) ([]*searchResult, error) {
+	if query.Image {
+		return nil, errImageNotSupported
+	}
+	endpoint := ds.Endpoint
+	if endpoint == "" {
+		endpoint = "https://html.duckduckgo.com/html/"
+	}
+	// the region format is like "cn-zh"
+	region := "wt-wt"
+	lang, country := splitLanguage(query.Language)
+	if country != "" {
+		region = country + "-" + lang
+	}
+	values := url.Values{}
+	values.Set("q", query.Keyword)
+	values.Set("kl", region)
+	values.Set("kp", "1")
+	body := strings.NewReader(values.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(resp.Body, maxSearchResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	var results []*searchResult
+	doc.Find(".result").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if s.HasClass("result--ad") {
+			return true
+		}
+		a := s.Find("a.result__a").First()
+		href, ok := a.Attr("href")
+		if !ok {
+			return true
+		}
+		results = append(results, &searchResult{
+			Title:   strings.TrimSpace(a.Text()),
+			Link:    resolveDuckDuckGoLink(href),
+			Snippet: strings.TrimSpace(s.Find(".result__snippet").First().Text()),
+		})
+		return len(results) < defaultSearchCount
+	})
+	if len(results) == 0 && doc.Find(".no-results").Length() == 0 {
+		return nil, errors.New("failed to parse search result, maybe blocked")
+	}
+	return results, nil
+}
+
+// resolveDuckDuckGoLink is used to get the real link from redirect link
+// like "//duckduckgo.com/l/?uddg=https%3A%2F%2Fgo.dev%2F&rut=...".
+func resolveDuckDuckGoLink(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	target := u.Query().Get("uddg")
+	if target == "" {
+		return href
+	}
+	return target
+}
+
+func stripTags(s string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s))
+	if err != nil {
+		return s
+	}
+	return strings.TrimSpace(doc.Text())
+}
@@ -0,0 +1,225 @@
+package deepbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSearchCachePath = "data/search/cache.json"
+	defaultSearchCacheSize = 1000
+)
+
+type searchCacheEntry struct {
+	Results []*searchResult `json:"results"`
+	Expire  time.Time       `json:"expire"`
+}
+
+// searchCall is an in-flight search, the same queries at the
+// same time will wait it instead of send request again.
+type searchCall struct {
+	done    chan struct{}
+	results []*searchResult
+	err     error
+}
+
+// searchCache is a TTL cache about search results that shared by all users,
+// it will be saved to the disk after updated and loaded when start.
+type searchCache struct {
+	path string
+	ttl  time.Duration
+	size int
+
+	entries map[string]*searchCacheEntry
+	calls   map[string]*searchCall
+	mu      sync.Mutex
+	saveMu  sync.Mutex
+}
+
+func newSearchCache(path string, ttl time.Duration, size int) *searchCache {
+	if size < 1 {
+		size = defaultSearchCacheSize
+	}
+	cache := searchCache{
+		path:    path,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]*searchCacheEntry),
+		calls:   make(map[string]*searchCall),
+	}
+	err := cache.load()
+	if err != nil {
+		log.Println("[warning] failed to load search cache:", err)
+	}
+	return &cache
+}
+
+// searchCacheKey is used to normalize the query for share the same
+// cache, the keyword is case-insensitive and ignore extra spaces.
+func searchCacheKey(query *searchQuery) string {
+	keyword := strings.Join(strings.Fields(strings.ToLower(query.Keyword)), " ")
+	typ := "web"
+	size := ""
+	if query.Image {
+		typ = "image"
+		size = strings.ToLower(query.Size)
+	}
+	return typ + "|" + size + "|" + query.Language + "|" + keyword
+}
+
+// Do is used to get the results from cache, if it is not exist or bypass
+// is true, the search function will be called and update the cache. The
+// bypass query will not wait the in-flight call that may be started before.
+func (c *searchCache) Do(ctx context.Context, key string, bypass bool, search func() ([]*searchResult, error)) ([]*searchResult, error) {
+	c.mu.Lock()
+	if !bypass {
+		entry, ok := c.entries[key]
+		if ok && time.Now().Before(entry.Expire) {
+			c.mu.Unlock()
+			return entry.Results, nil
+		}
+		call, ok := c.calls[key]
+		if ok {
+			c.mu.Unlock()
+			return c.wait(ctx, call, search)
+		}
+	}
+	call := &searchCall{
+		done: make(chan struct{}),
+	}
+	if !bypass {
+		c.calls[key] = call
+	}
+	c.mu.Unlock()
+
+	call.results, call.err = search()
+
+	c.mu.Lock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	if call.err == nil {
+		c.entries[key] = &searchCacheEntry{
+			Results: call.results,
+			Expire:  time.Now().Add(c.ttl),
+		}
+	}
+	c.mu.Unlock()
+	close(call.done)
+	if call.err == nil {
+		err := c.save()
+		if err != nil {
+			log.Println("[warning] failed to save search cache:", err)
+		}
+	}
+	return call.results, call.err
+}
+
+// wait is used to wait the in-flight call with the context of current
+// query, if the call is canceled by its caller, it will search again.
+func (c *searchCache) wait(ctx context.Context, call *searchCall, search func() ([]*searchResult, error)) ([]*searchResult, error) {
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	canceled := errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded)
+	if canceled && ctx.Err() == nil {
+		return search()
+	}
+	return call.results, call.err
+}
+
+func (c *searchCache) load() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	entries := make(map[string]*searchCacheEntry)
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return err
+	}
+	c.entries = entries
+	c.prune()
+	return nil
+}
+
+// save is used to write the snapshot of entries to the file, the
+// saving is serialized for prevent the old snapshot overwrite new.
+func (c *searchCache) save() error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+	c.mu.Lock()
+	c.prune()
+	entries := maps.Clone(c.entries)
+	c.mu.Unlock()
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(c.path), 0755)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file for prevent break the cache file
+	tmp := c.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// prune is used to remove the expired entries and the entries that
+// will be expired soon if the cache is full, it must be called with lock.
+func (c *searchCache) prune() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.Expire) {
+			delete(c.entries, key)
+		}
+	}
+	n := len(c.entries) - c.size
+	if n <= 0 {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].Expire.Before(c.entries[keys[j]].Expire)
+	})
+	for _, key := range keys[:n] {
+		delete(c.entries, key)
+	}
+}
+
+// dedupResults is used to remove the results with the same link,
+// some providers will return the same page with different tracking.
+func dedupResults(results []*searchResult) []*searchResult {
+	seen := make(map[string]bool, len(results))
+	deduped := make([]*searchResult, 0, len(results))
+	for _, result := range results {
+		link := strings.TrimSuffix(strings.TrimSpace(result.Link), "/")
+		if link == "" || seen[link] {
+			continue
+		}
+		seen[link] = true
+		deduped = append(deduped, result)
+	}
+	return deduped
+}
@@ -0,0 +1,166 @@
+package deepbot
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchCacheKey(t *testing.T) {
+	a := searchCacheKey(&searchQuery{Keyword: " Golang   Tutorial ", Language: "zh-cn"})
+	b := searchCacheKey(&searchQuery{Keyword: "golang tutorial", Language: "zh-cn"})
+	require.Equal(t, a, b)
+
+	c := searchCacheKey(&searchQuery{Keyword: "golang tutorial", Language: "zh-cn", Image: true})
+	require.NotEqual(t, a, c)
+	d := searchCacheKey(&searchQuery{Keyword: "golang tutorial", Language: "zh-cn", Image: true, Size: "large"})
+	require.NotEqual(t, c, d)
+	// size is ignored for web search
+	e := searchCacheKey(&searchQuery{Keyword: "golang tutorial", Language: "zh-cn", Size: "large"})
+	require.Equal(t, a, e)
+}
+
+func TestSearchCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search", "cache.json")
+	results := []*searchResult{{Title: "Go", Link: "https://go.dev/"}}
+
+	ctx := context.Background()
+	var calls int32
+	search := func() ([]*searchResult, error) {
+		atomic.AddInt32(&calls, 1)
+		return results, nil
+	}
+
+	t.Run("hit", func(t *testing.T) {
+		cache := newSearchCache(path, time.Hour, 0)
+		output, err := cache.Do(ctx, "key", false, search)
+		require.NoError(t, err)
+		require.Equal(t, results, output)
+		output, err = cache.Do(ctx, "key", false, search)
+		require.NoError(t, err)
+		require.Equal(t, results, output)
+		require.Equal(t, int32(1), calls)
+
+		// bypass the cache but still update it
+		_, err = cache.Do(ctx, "key", true, search)
+		require.NoError(t, err)
+		require.Equal(t, int32(2), calls)
+	})
+
+	t.Run("persist", func(t *testing.T) {
+		cache := newSearchCache(path, time.Hour, 0)
+		output, err := cache.Do(ctx, "key", false, search)
+		require.NoError(t, err)
+		require.Equal(t, results, output)
+		require.Equal(t, int32(2), calls)
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		cache := newSearchCache(path, time.Millisecond, 0)
+		cache.entries["key"].Expire = time.Now().Add(-time.Second)
+		_, err := cache.Do(ctx, "key", false, search)
+		require.NoError(t, err)
+		require.Equal(t, int32(3), calls)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		cache := newSearchCache(path, time.Hour, 0)
+		failed := func() ([]*searchResult, error) {
+			return nil, errors.New("failed")
+		}
+		_, err := cache.Do(ctx, "failed", false, failed)
+		require.Error(t, err)
+		require.NotContains(t, cache.entries, "failed")
+	})
+
+	t.Run("in-flight", func(t *testing.T) {
+		cache := newSearchCache(path, time.Hour, 0)
+		var n int32
+		slow := func() ([]*searchResult, error) {
+			atomic.AddInt32(&n, 1)
+			time.Sleep(100 * time.Millisecond)
+			return results, nil
+		}
+		outputs := make([][]*searchResult, 8)
+		errs := make([]error, 8)
+		wg := sync.WaitGroup{}
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				outputs[i], errs[i] = cache.Do(ctx, "slow", false, slow)
+			}()
+		}
+		wg.Wait()
+		for i := 0; i < 8; i++ {
+			require.NoError(t, errs[i])
+			require.Equal(t, results, outputs[i])
+		}
+		require.Equal(t, int32(1), n)
+	})
+
+	t.Run("waiter context", func(t *testing.T) {
+		cache := newSearchCache(path, time.Hour, 0)
+		release := make(chan struct{})
+		blocked := func() ([]*searchResult, error) {
+			<-release
+			return results, nil
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = cache.Do(ctx, "blocked", false, blocked)
+		}()
+		// wait the first call is in-flight
+		require.Eventually(t, func() bool {
+			cache.mu.Lock()
+			defer cache.mu.Unlock()
+			return cache.calls["blocked"] != nil
+		}, time.Second, time.Millisecond)
+
+		c, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err := cache.Do(c, "blocked", false, blocked)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// the bypass query not wait the in-flight call
+		before := atomic.LoadInt32(&calls)
+		output, err := cache.Do(ctx, "blocked", true, search)
+		require.NoError(t, err)
+		require.Equal(t, results, output)
+		require.Equal(t, before+1, atomic.LoadInt32(&calls))
+
+		close(release)
+		<-done
+	})
+
+	t.Run("size", func(t *testing.T) {
+		cache := newSearchCache(filepath.Join(t.TempDir(), "cache.json"), time.Hour, 2)
+		for _, key := range []string{"a", "b", "c"} {
+			_, err := cache.Do(ctx, key, false, search)
+			require.NoError(t, err)
+			time.Sleep(time.Millisecond)
+		}
+		require.Len(t, cache.entries, 2)
+		require.NotContains(t, cache.entries, "a")
+	})
+}
+
+func TestDedupResults(t *testing.T) {
+	results := dedupResults([]*searchResult{
+		{Title: "a", Link: "https://go.dev/"},
+		{Title: "b", Link: "https://go.dev"},
+		{Title: "c", Link: ""},
+		{Title: "d", Link: "https://pkg.go.dev/"},
+	})
+	require.Equal(t, []*searchResult{
+		{Title: "a", Link: "https://go.dev/"},
+		{Title: "d", Link: "https://pkg.go.dev/"},
+	}, results)
+}
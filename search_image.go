@@ -0,0 +1,261 @@
+package deepbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+const (
+	defaultSearchImages = 4
+	searchImageTimeout  = 15 * time.Second
+)
+
+// markdownImage is the image like ![alt](link "title") in the answer,
+// the Start and End are the offset of the source text.
+type markdownImage struct {
+	Start int
+	End   int
+	Alt   string
+	Link  string
+}
+
+// findMarkdownImages is used to find the markdown images in the text, the
+// source of each image is located by the brackets and parentheses, then it
+// is parsed by the markdown parser for get the link like "a_(b).png".
+func findMarkdownImages(text string) []*markdownImage {
+	var images []*markdownImage
+	for i := 0; i < len(text); i++ {
+		if !strings.HasPrefix(text[i:], "![") {
+			continue
+		}
+		end := markdownImageEnd(text, i)
+		if end == -1 {
+			continue
+		}
+		image := parseMarkdownImage(text[i:end])
+		if image == nil {
+			continue
+		}
+		image.Start, image.End = i, end
+		images = append(images, image)
+		i = end - 1
+	}
+	return images
+}
+
+// markdownImageEnd is used to find the end of image source that start at
+// the offset, it returns -1 if the source is not a complete image.
+func markdownImageEnd(text string, start int) int {
+	i := start + 2
+	depth := 1
+	for ; i < len(text) && depth > 0; i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '\n':
+			return -1
+		}
+	}
+	if depth != 0 || i >= len(text) || text[i] != '(' {
+		return -1
+	}
+	depth = 0
+	var quote byte
+	for ; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\n':
+			return -1
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+func parseMarkdownImage(src string) *markdownImage {
+	doc := parser.New().Parse([]byte(src))
+	var image *ast.Image
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		img, ok := node.(*ast.Image)
+		if ok && entering && image == nil {
+			image = img
+			return ast.Terminate
+		}
+		return ast.GoToNext
+	})
+	if image == nil {
+		return nil
+	}
+	var alt strings.Builder
+	ast.WalkFunc(image, func(node ast.Node, entering bool) ast.WalkStatus {
+		leaf := node.AsLeaf()
+		if leaf != nil && entering {
+			alt.Write(leaf.Literal)
+		}
+		return ast.GoToNext
+	})
+	return &markdownImage{
+		Alt:  strings.TrimSpace(alt.String()),
+		Link: string(image.Destination),
+	}
+}
+
+func addSearchImages(user *user, results []*searchResult) {
+	images, _ := user.getContext("SearchImages").([]*searchResult)
+	user.setContext("SearchImages", append(images, results...))
+}
+
+func resetSearchImages(user *user) {
+	user.setContext("SearchImages", nil)
+}
+
+func takeSearchImages(user *user) []*searchResult {
+	images, _ := user.getContext("SearchImages").([]*searchResult)
+	resetSearchImages(user)
+	return images
+}
+
+// attachSearchImages is used to download the images from SearchImage that
+// referenced in the answer, then they will be sent with the reply. The
+// markdown image in answer will be replaced to link if download failed.
+func (bot *DeepBot) attachSearchImages(user *user, msg string) string {
+	if user == nil {
+		return msg
+	}
+	results := takeSearchImages(user)
+	if len(results) == 0 {
+		return msg
+	}
+	maxImages := bot.config.SearchAPI.MaxImages
+	if maxImages < 1 {
+		maxImages = defaultSearchImages
+	}
+	var links []string
+	seen := make(map[string]bool)
+	for _, result := range results {
+		link := result.Link
+		if link == "" || seen[link] || !strings.Contains(msg, link) {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+		if len(links) >= maxImages {
+			break
+		}
+	}
+	if len(links) == 0 {
+		return msg
+	}
+
+	client := bot.policy.HTTPClient(bot.config.SearchAPI.ProxyURL, searchImageTimeout)
+	defer client.CloseIdleConnections()
+	images := make([][]byte, len(links))
+	wg := sync.WaitGroup{}
+	for i, link := range links {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), searchImageTimeout)
+			defer cancel()
+			img, err := bot.downloadImage(ctx, client, link)
+			if err != nil {
+				log.Printf("failed to download image %s: %s\n", link, err)
+				return
+			}
+			images[i] = img
+		}()
+	}
+	wg.Wait()
+
+	downloaded := make(map[string]bool)
+	for i, img := range images {
+		if img == nil {
+			continue
+		}
+		downloaded[links[i]] = true
+		user.addImage(img)
+	}
+	builder := strings.Builder{}
+	var last int
+	for _, image := range findMarkdownImages(msg) {
+		if !seen[image.Link] {
+			continue
+		}
+		builder.WriteString(msg[last:image.Start])
+		last = image.End
+		if downloaded[image.Link] {
+			continue
+		}
+		// fallback to the link
+		if image.Alt != "" {
+			builder.WriteString(image.Alt + ": ")
+		}
+		builder.WriteString(image.Link)
+	}
+	builder.WriteString(msg[last:])
+	return builder.String()
+}
+
+// downloadImage is used to download image with the URL policy.
+func (bot *DeepBot) downloadImage(ctx context.Context, client *http.Client, link string) ([]byte, error) {
+	err := bot.policy.Check(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/*")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("invalid content type: %s", contentType)
+	}
+	if resp.ContentLength > bot.policy.maxSize {
+		return nil, fmt.Errorf("image size is larger than %d bytes", bot.policy.maxSize)
+	}
+	img, err := bot.policy.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// check the real content type, the svg is not supported
+	if !strings.HasPrefix(http.DetectContentType(img), "image/") {
+		return nil, errors.New("invalid image data")
+	}
+	return img, nil
+}
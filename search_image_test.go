@@ -0,0 +1,124 @@
+package deepbot
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachSearchImages(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	require.NoError(t, err)
+	pngData := buf.Bytes()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a.png", "/b.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(pngData)
+		case "/fake.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("<html></html>"))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.URLPolicy.AllowPrivate = true
+	bot := &DeepBot{
+		config: config,
+		policy: newURLPolicy(config),
+	}
+	user := &user{ctx: make(map[string]any)}
+
+	t.Run("common", func(t *testing.T) {
+		config.SearchAPI.MaxImages = 5
+		defer func() { config.SearchAPI.MaxImages = 0 }()
+
+		var results []*searchResult
+		for _, name := range []string{"a.png", "b.png", "c.png", "fake.png", "page.html", "unused.png"} {
+			results = append(results, &searchResult{Link: server.URL + "/" + name})
+		}
+		addSearchImages(user, results[:3])
+		addSearchImages(user, results[3:])
+
+		msg := "图片1 ![Go](" + server.URL + "/a.png)\n" +
+			"图片2 " + server.URL + "/b.png\n" +
+			"图片3 ![](" + server.URL + "/c.png)\n" +
+			"图片4 ![伪装](" + server.URL + "/fake.png \"title\")\n" +
+			"图片5 ![网页](" + server.URL + "/page.html)\n" +
+			"其他 ![other](https://example.com/other.png)"
+		output := bot.attachSearchImages(user, msg)
+		expected := "图片1 \n" +
+			"图片2 " + server.URL + "/b.png\n" +
+			"图片3 " + server.URL + "/c.png\n" +
+			"图片4 伪装: " + server.URL + "/fake.png\n" +
+			"图片5 网页: " + server.URL + "/page.html\n" +
+			"其他 ![other](https://example.com/other.png)"
+		require.Equal(t, expected, output)
+
+		images := user.takeImages()
+		require.Len(t, images, 2)
+		require.Nil(t, user.getContext("SearchImages"))
+	})
+
+	t.Run("max images", func(t *testing.T) {
+		config.SearchAPI.MaxImages = 1
+		defer func() { config.SearchAPI.MaxImages = 0 }()
+
+		addSearchImages(user, []*searchResult{
+			{Link: server.URL + "/a.png"},
+			{Link: server.URL + "/b.png"},
+		})
+		msg := "![a](" + server.URL + "/a.png) ![b](" + server.URL + "/b.png)"
+		output := bot.attachSearchImages(user, msg)
+		require.Equal(t, " ![b]("+server.URL+"/b.png)", output)
+		require.Len(t, user.takeImages(), 1)
+	})
+
+	t.Run("blocked", func(t *testing.T) {
+		config := new(Config)
+		bot := &DeepBot{
+			config: config,
+			policy: newURLPolicy(config),
+		}
+		addSearchImages(user, []*searchResult{{Link: server.URL + "/a.png"}})
+		output := bot.attachSearchImages(user, "![a]("+server.URL+"/a.png)")
+		require.Equal(t, "a: "+server.URL+"/a.png", output)
+		require.Empty(t, user.takeImages())
+	})
+
+	t.Run("no results", func(t *testing.T) {
+		msg := "![a](" + server.URL + "/a.png)"
+		require.Equal(t, msg, bot.attachSearchImages(user, msg))
+		require.Equal(t, msg, bot.attachSearchImages(nil, msg))
+	})
+}
+
+func TestFindMarkdownImages(t *testing.T) {
+	text := "a ![Go](https://go.dev/a_(1).png) b " +
+		"![](<https://go.dev/b c.png> \"title (x)\") " +
+		"![not closed](https://go.dev/c.png " +
+		"[link](https://go.dev/)"
+	images := findMarkdownImages(text)
+	require.Len(t, images, 2)
+
+	require.Equal(t, "Go", images[0].Alt)
+	require.Equal(t, "https://go.dev/a_(1).png", images[0].Link)
+	require.Equal(t, "![Go](https://go.dev/a_(1).png)", text[images[0].Start:images[0].End])
+
+	require.Equal(t, "", images[1].Alt)
+	require.Equal(t, "https://go.dev/b c.png", images[1].Link)
+	require.Equal(t, `![](<https://go.dev/b c.png> "title (x)")`, text[images[1].Start:images[1].End])
+}
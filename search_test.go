@@ -0,0 +1,221 @@
+package deepbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testSearchProvider(t *testing.T, provider searchProvider, query *searchQuery) []*searchResult {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if query.Language == "" {
+		query.Language = defaultSearchLanguage
+	}
+	results, err := provider.Search(ctx, http.DefaultClient, query)
+	require.NoError(t, err)
+	return results
+}
+
+func TestGoogleSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		require.Equal(t, "id", query.Get("cx"))
+		require.Equal(t, "key", query.Get("key"))
+		require.Equal(t, "golang", query.Get("q"))
+		require.Equal(t, "image", query.Get("searchType"))
+		require.Equal(t, "medium", query.Get("imgSize"))
+		_, _ = w.Write([]byte(`{"items":[{"title":"Go","link":"https://go.dev/logo.png","snippet":"logo","mime":"image/png"}]}`))
+	}))
+	defer server.Close()
+
+	provider := &googleSearch{
+		EngineID: "id",
+		APIKey:   "key",
+		Endpoint: server.URL,
+	}
+	query := &searchQuery{Keyword: "golang", Image: true, Size: "medium"}
+	results := testSearchProvider(t, provider, query)
+	require.Equal(t, []*searchResult{
+		{Title: "Go", Link: "https://go.dev/logo.png", Snippet: "logo"},
+	}, results)
+}
+
+func TestSearXNGSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/search", r.URL.Path)
+		query := r.URL.Query()
+		require.Equal(t, "json", query.Get("format"))
+		require.Equal(t, "zh-CN", query.Get("language"))
+		if query.Get("categories") == "images" {
+			_, _ = w.Write([]byte(`{"results":[{"title":"Go","url":"https://go.dev/","img_src":"https://go.dev/logo.png"},{"title":"none"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"results":[{"title":"Go","url":"https://go.dev/","content":"The Go Programming Language"}]}`))
+	}))
+	defer server.Close()
+
+	provider := &searxngSearch{URL: server.URL + "/"}
+	results := testSearchProvider(t, provider, &searchQuery{Keyword: "golang"})
+	require.Equal(t, []*searchResult{
+		{Title: "Go", Link: "https://go.dev/", Snippet: "The Go Programming Language"},
+	}, results)
+
+	results = testSearchProvider(t, provider, &searchQuery{Keyword: "golang", Image: true})
+	require.Equal(t, []*searchResult{
+		{Title: "Go", Link: "https://go.dev/logo.png"},
+	}, results)
+}
+
+func TestBingSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "key", r.Header.Get("Ocp-Apim-Subscription-Key"))
+		require.Equal(t, "zh-CN", r.URL.Query().Get("mkt"))
+		switch r.URL.Path {
+		case "/v7.0/search":
+			_, _ = w.Write([]byte(`{"webPages":{"value":[{"name":"Go","url":"https://go.dev/","snippet":"Go"}]}}`))
+		case "/v7.0/images/search":
+			require.Equal(t, "Large", r.URL.Query().Get("size"))
+			_, _ = w.Write([]byte(`{"value":[{"name":"Go","contentUrl":"https://go.dev/logo.png","hostPageUrl":"https://go.dev/"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider := &bingSearch{Endpoint: server.URL, APIKey: "key"}
+	results := testSearchProvider(t, provider, &searchQuery{Keyword: "golang"})
+	require.Equal(t, []*searchResult{
+		{Title: "Go", Link: "https://go.dev/", Snippet: "Go"},
+	}, results)
+
+	results = testSearchProvider(t, provider, &searchQuery{Keyword: "golang", Image: true, Size: "xlarge"})
+	require.Equal(t, []*searchResult{
+		{Title: "Go", Link: "https://go.dev/logo.png", Snippet: "https://go.dev/"},
+	}, results)
+}
+
+func TestBraveSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "key", r.Header.Get("X-Subscription-Token"))
+		require.Equal(t, "zh-hans", r.URL.Query().Get("search_lang"))
+		switch r.URL.Path {
+		case "/res/v1/web/search":
+			_, _ = w.Write([]byte(`{"web":{"results":[{"title":"Go","url":"https://go.dev/","description":"The <strong>Go</strong> Language"}]}}`))
+		case "/res/v1/images/search":
+			_, _ = w.Write([]byte(`{"results":[{"title":"Go","url":"https://go.dev/","properties":{"url":"https://go.dev/logo.png"}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider := &braveSearch{APIKey: "key", Endpoint: server.URL}
+	results := testSearchProvider(t, provider, &searchQuery{Keyword: "golang"})
+	require.Equal(t, []*searchResult{
+		{Title: "Go", Link: "https://go.dev/", Snippet: "The Go Language"},
+	}, results)
+
+	results = testSearchProvider(t, provider, &searchQuery{Keyword: "golang", Image: true})
+	require.Equal(t, []*searchResult{
+		{Title: "Go", Link: "https://go.dev/logo.png", Snippet: "https://go.dev/"},
+	}, results)
+}
+
+func TestDuckDuckGoSearch(t *testing.T) {
+	const page = `
+<html><body>
+<div class="result result--ad">
+  <a class="result__a" href="https://ad.example.com/">Ad</a>
+</div>
+<div class="result">
+  <h2><a class="result__a" href="//duckduckgo.com/l/?uddg=https%3A%2F%2Fgo.dev%2F&amp;rut=abc">The Go Programming Language</a></h2>
+  <a class="result__snippet">Go is an open source programming language.</a>
+</div>
+<div class="result">
+  <h2><a class="result__a" href="https://pkg.go.dev/">Go Packages</a></h2>
+</div>
+</body></html>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "golang", r.FormValue("q"))
+		require.Equal(t, "cn-zh", r.FormValue("kl"))
+		_, _ = w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	provider := &duckduckgoSearch{Endpoint: server.URL}
+	results := testSearchProvider(t, provider, &searchQuery{Keyword: "golang"})
+	require.Equal(t, []*searchResult{
+		{
+			Title:   "The Go Programming Language",
+			Link:    "https://go.dev/",
+			Snippet: "Go is an open source programming language.",
+		},
+		{Title: "Go Packages", Link: "https://pkg.go.dev/"},
+	}, results)
+
+	_, err := provider.Search(context.Background(), http.DefaultClient, &searchQuery{Image: true})
+	require.ErrorIs(t, err, errImageNotSupported)
+}
+
+func TestSearcher(t *testing.T) {
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer failed.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"results":[{"title":"Go","url":"https://go.dev/","img_src":"https://go.dev/logo.png"}]}`))
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.SearchAPI.Providers = []string{"unknown", "duckduckgo", "searxng"}
+	config.SearchAPI.SearXNG.URL = server.URL
+	s := newSearcher(config)
+	defer s.Close()
+	require.Len(t, s.providers, 2)
+	s.providers[0] = &duckduckgoSearch{Endpoint: failed.URL}
+
+	ctx := context.Background()
+
+	t.Run("failover", func(t *testing.T) {
+		results, err := s.Search(ctx, &searchQuery{Keyword: "golang"})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Equal(t, "https://go.dev/", results[0].Link)
+
+		results, err = s.Search(ctx, &searchQuery{Keyword: "golang", Image: true})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Equal(t, "https://go.dev/logo.png", results[0].Link)
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		s.providers[1] = &searxngSearch{URL: failed.URL}
+
+		_, err := s.Search(ctx, &searchQuery{Keyword: "golang"})
+		require.ErrorContains(t, err, "duckduckgo")
+		require.ErrorContains(t, err, "searxng")
+	})
+}
+
+func TestNewSearcherLegacyConfig(t *testing.T) {
+	config := new(Config)
+	config.SearchAPI.EngineID = "engine"
+	config.SearchAPI.APIKey = "key"
+	s := newSearcher(config)
+	defer s.Close()
+	require.Equal(t, &googleSearch{EngineID: "engine", APIKey: "key"}, s.providers[0])
+
+	config.SearchAPI.Google.EngineID = "new engine"
+	config.SearchAPI.Google.APIKey = "new key"
+	s = newSearcher(config)
+	defer s.Close()
+	require.Equal(t, &googleSearch{EngineID: "new engine", APIKey: "new key"}, s.providers[0])
+}
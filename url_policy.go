@@ -0,0 +1,285 @@
+package deepbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+const defaultMaxResponseSize = 16 * 1024 * 1024
+
+var errTabBlocked = errors.New("tab is blocked because it accessed internal address")
+
+// urlPolicy is used to check the URL that access on behalf of the model,
+// it prevents the model access the loopback, LAN and other internal service.
+type urlPolicy struct {
+	schemes      map[string]bool
+	blockPrivate bool
+	allowDomains []string
+	denyDomains  []string
+	maxSize      int64
+
+	// the remote address of browser is the proxy server if it is used
+	browserProxy bool
+}
+
+func newURLPolicy(config *Config) *urlPolicy {
+	cfg := config.URLPolicy
+	schemes := make(map[string]bool)
+	for _, scheme := range cfg.Schemes {
+		schemes[strings.ToLower(scheme)] = true
+	}
+	if len(schemes) == 0 {
+		schemes["http"] = true
+		schemes["https"] = true
+	}
+	maxSize := cfg.MaxSize
+	if maxSize < 1 {
+		maxSize = defaultMaxResponseSize
+	}
+	policy := urlPolicy{
+		schemes:      schemes,
+		blockPrivate: !cfg.AllowPrivate,
+		maxSize:      maxSize,
+		browserProxy: config.Chromedp.ProxyURL != "",
+	}
+	for _, domain := range cfg.AllowDomains {
+		policy.allowDomains = append(policy.allowDomains, normalizeDomain(domain))
+	}
+	for _, domain := range cfg.DenyDomains {
+		policy.denyDomains = append(policy.denyDomains, normalizeDomain(domain))
+	}
+	// the internal services used by bot are always denied
+	for _, service := range []string{
+		config.SDWebUI.URL,
+		config.OneBot.WSClient.URL,
+		config.OneBot.WSServer.URL,
+	} {
+		u, err := url.Parse(service)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		policy.denyDomains = append(policy.denyDomains, normalizeDomain(u.Hostname()))
+	}
+	return &policy
+}
+
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "*.")
+	return strings.TrimSuffix(domain, ".")
+}
+
+func matchDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check is used to check the URL before access it, the host
+// will be resolved for check the IP address is not private.
+func (p *urlPolicy) Check(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %s", err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if !p.schemes[scheme] {
+		return fmt.Errorf("url scheme \"%s\" is not allowed", scheme)
+	}
+	host := normalizeDomain(u.Hostname())
+	if host == "" {
+		return errors.New("url host is empty")
+	}
+	if matchDomain(host, p.denyDomains) {
+		return fmt.Errorf("domain \"%s\" is denied", host)
+	}
+	if len(p.allowDomains) > 0 && !matchDomain(host, p.allowDomains) {
+		return fmt.Errorf("domain \"%s\" is not in allow list", host)
+	}
+	if !p.blockPrivate {
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("access localhost is not allowed")
+	}
+	ip := net.ParseIP(host)
+	if ip != nil {
+		return p.checkIP(ip)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve host: %s", err)
+	}
+	for _, addr := range addrs {
+		err = p.checkIP(addr.IP)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var cgnatNet = &net.IPNet{
+	IP:   net.IPv4(100, 64, 0, 0),
+	Mask: net.CIDRMask(10, 32),
+}
+
+func (p *urlPolicy) checkIP(ip net.IP) error {
+	if !p.blockPrivate {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || cgnatNet.Contains(ip) {
+		return fmt.Errorf("access internal address %s is not allowed", ip)
+	}
+	return nil
+}
+
+// HTTPClient is used to create a client that check the address when dial,
+// so that the DNS rebinding and redirect to internal address are blocked.
+// If the proxy is used, the address will be resolved by proxy server, so
+// only the URL about the request and redirect will be checked.
+func (p *urlPolicy) HTTPClient(proxyURL string, timeout time.Duration) *http.Client {
+	dialer := net.Dialer{
+		Timeout: 30 * time.Second,
+	}
+	tr := http.Transport{
+		TLSHandshakeTimeout: 30 * time.Second,
+	}
+	if proxyURL != "" {
+		tr.Proxy = func(*http.Request) (*url.URL, error) {
+			return url.Parse(proxyURL)
+		}
+	} else {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("invalid address: %s", address)
+			}
+			return p.checkIP(ip)
+		}
+	}
+	tr.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: &tr,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return p.Check(req.Context(), req.URL.String())
+		},
+	}
+}
+
+// ReadAll is used to read the response body with the size limit.
+func (p *urlPolicy) ReadAll(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, p.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > p.maxSize {
+		return nil, fmt.Errorf("response size is larger than %d bytes", p.maxSize)
+	}
+	return data, nil
+}
+
+// Guard is used to intercept the requests in the tab, include the redirect,
+// frame and sub resource, the request that not allowed will be blocked.
+//
+// The browser will resolve the host again after the check, so the DNS
+// rebinding can make the request reach the internal address. For close
+// this window, the remote address of each response is checked again, if
+// it is internal, the tab will stop loading and navigate to blank page,
+// and all the subsequent requests in the tab will be blocked. The remote
+// address is the proxy server if the browser use proxy, so it is skipped.
+func (p *urlPolicy) Guard(ctx context.Context) error {
+	c := chromedp.FromContext(ctx)
+	// cache the result about host for reduce DNS query
+	results := make(map[string]error)
+	resultsMu := sync.Mutex{}
+	check := func(ctx context.Context, rawURL string) error {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "data", "blob", "about":
+			return nil
+		}
+		key := u.Scheme + "://" + u.Host
+		resultsMu.Lock()
+		err, ok := results[key]
+		resultsMu.Unlock()
+		if ok {
+			return err
+		}
+		// not hold the lock when resolve the host
+		err = p.Check(ctx, rawURL)
+		resultsMu.Lock()
+		results[key] = err
+		resultsMu.Unlock()
+		return err
+	}
+	var blocked atomic.Bool
+	chromedp.ListenTarget(ctx, func(ev any) {
+		switch ev := ev.(type) {
+		case *fetch.EventRequestPaused:
+			go func() {
+				executor := cdp.WithExecutor(ctx, c.Target)
+				err := check(executor, ev.Request.URL)
+				if err == nil && blocked.Load() {
+					err = errTabBlocked
+				}
+				if err != nil {
+					log.Println("[warning] block browser request:", ev.Request.URL, err)
+					_ = fetch.FailRequest(ev.RequestID, network.ErrorReasonBlockedByClient).Do(executor)
+					return
+				}
+				_ = fetch.ContinueRequest(ev.RequestID).Do(executor)
+			}()
+		case *network.EventResponseReceived:
+			if p.browserProxy || ev.Response == nil || blocked.Load() {
+				return
+			}
+			ip := net.ParseIP(strings.Trim(ev.Response.RemoteIPAddress, "[]"))
+			if ip == nil || p.checkIP(ip) == nil {
+				return
+			}
+			blocked.Store(true)
+			log.Println("[warning] block browser tab, response from internal address:", ev.Response.URL, ip)
+			go func() {
+				executor := cdp.WithExecutor(ctx, c.Target)
+				_ = page.StopLoading().Do(executor)
+				_, _, _, _ = page.Navigate("about:blank").Do(executor)
+			}()
+		}
+	})
+	patterns := []*fetch.RequestPattern{{URLPattern: "*"}}
+	return chromedp.Run(ctx, fetch.Enable().WithPatterns(patterns))
+}
@@ -0,0 +1,103 @@
+package deepbot
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLPolicy(t *testing.T) {
+	config := new(Config)
+	config.URLPolicy.DenyDomains = []string{"*.example.org"}
+	config.URLPolicy.MaxSize = 16
+	config.SDWebUI.URL = "http://sd-webui.lan:7860"
+	policy := newURLPolicy(config)
+
+	ctx := context.Background()
+
+	t.Run("scheme", func(t *testing.T) {
+		for _, URL := range []string{
+			"file:///etc/passwd",
+			"ftp://1.1.1.1/",
+			"javascript:alert(1)",
+			"chrome://settings",
+		} {
+			require.Error(t, policy.Check(ctx, URL), URL)
+		}
+	})
+
+	t.Run("internal address", func(t *testing.T) {
+		for _, URL := range []string{
+			"http://127.0.0.1/",
+			"http://localhost:8080/",
+			"http://api.localhost/",
+			"http://0.0.0.0/",
+			"http://10.0.0.1/",
+			"http://172.16.1.1/",
+			"http://192.168.1.1/admin",
+			"http://169.254.169.254/latest/meta-data/",
+			"http://100.64.0.1/",
+			"http://[::1]/",
+			"http://[fe80::1]/",
+			"http://[fd00::1]/",
+			"http://[::ffff:127.0.0.1]/",
+		} {
+			require.Error(t, policy.Check(ctx, URL), URL)
+		}
+		require.NoError(t, policy.Check(ctx, "https://1.1.1.1/"))
+	})
+
+	t.Run("domain", func(t *testing.T) {
+		require.Error(t, policy.Check(ctx, "https://example.org/"))
+		require.Error(t, policy.Check(ctx, "https://www.Example.org./"))
+		require.Error(t, policy.Check(ctx, "http://sd-webui.lan:7860/sdapi/v1/txt2img"))
+
+		config := new(Config)
+		config.URLPolicy.AllowDomains = []string{"example.com"}
+		policy := newURLPolicy(config)
+		require.Error(t, policy.Check(ctx, "https://1.1.1.1/"))
+		require.Error(t, policy.Check(ctx, "https://notexample.com/"))
+	})
+
+	t.Run("allow private", func(t *testing.T) {
+		config := new(Config)
+		config.URLPolicy.AllowPrivate = true
+		config.URLPolicy.Schemes = []string{"HTTP"}
+		policy := newURLPolicy(config)
+		require.NoError(t, policy.Check(ctx, "http://127.0.0.1/"))
+		require.Error(t, policy.Check(ctx, "https://127.0.0.1/"))
+	})
+
+	t.Run("max size", func(t *testing.T) {
+		data, err := policy.ReadAll(bytes.NewReader(make([]byte, 16)))
+		require.NoError(t, err)
+		require.Len(t, data, 16)
+
+		_, err = policy.ReadAll(bytes.NewReader(make([]byte, 17)))
+		require.Error(t, err)
+	})
+
+	t.Run("http client", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		// dial to loopback address is blocked even skip the check
+		client := policy.HTTPClient("", 3*time.Second)
+		_, err := client.Get(server.URL)
+		require.Error(t, err)
+
+		config := new(Config)
+		config.URLPolicy.AllowPrivate = true
+		client = newURLPolicy(config).HTTPClient("", 3*time.Second)
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	})
+}
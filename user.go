@@ -41,6 +41,12 @@ type user struct {
 	// store data for tool call
 	ctx map[string]any
 
+	// images from tool call that need send with reply
+	images [][]byte
+
+	// render preferences
+	render renderOptions
+
 	rwm sync.RWMutex
 }
 
@@ -57,6 +63,7 @@ func newUser(id int64) *user {
 	}
 	user.readCharacter()
 	user.readConversation()
+	user.render = readRenderOptions(user.renderPath())
 	return user
 }
 
@@ -65,6 +72,7 @@ func (user *user) initDir() error {
 		fmt.Sprintf("data/characters/%d", user.id),
 		fmt.Sprintf("data/conversation/%d", user.id),
 		fmt.Sprintf("data/memory/private/%d", user.id),
+		fmt.Sprintf("data/preference/%d", user.id),
 	} {
 		err := os.MkdirAll(path, 0755)
 		if err != nil {
@@ -210,3 +218,42 @@ func (user *user) setContext(key string, data any) {
 	defer user.rwm.Unlock()
 	user.ctx[key] = data
 }
+
+func (user *user) addImage(img []byte) {
+	user.rwm.Lock()
+	defer user.rwm.Unlock()
+	user.images = append(user.images, img)
+}
+
+func (user *user) takeImages() [][]byte {
+	user.rwm.Lock()
+	defer user.rwm.Unlock()
+	images := user.images
+	user.images = nil
+	return images
+}
+
+func (user *user) getRender() renderOptions {
+	user.rwm.RLock()
+	defer user.rwm.RUnlock()
+	return user.render
+}
+
+func (user *user) setRender(opts renderOptions) error {
+	user.rwm.Lock()
+	defer user.rwm.Unlock()
+	data, err := jsonEncode(&opts)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(user.renderPath(), data, 0600)
+	if err != nil {
+		return err
+	}
+	user.render = opts
+	return nil
+}
+
+func (user *user) renderPath() string {
+	return fmt.Sprintf("data/preference/%d/render.json", user.id)
+}
@@ -0,0 +1,247 @@
+package deepbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const (
+	visionProviderOpenAI = "openai"
+	visionProviderOllama = "ollama"
+)
+
+const (
+	defaultVisionImages  = 3
+	defaultVisionTimeout = 60 * time.Second
+)
+
+const defaultVisionPrompt = "" +
+	"请详细地描述这张图片的内容，包括主体、场景、颜色、人物的动作与表情等细节。" +
+	"如果图片中有文字，请完整准确地识别出来。如果是截图、图表或者代码，请提取其中的关键信息。" +
+	"只需要输出描述内容，不要输出其他无关的内容。"
+
+var cqImageRegexp = regexp.MustCompile(`\[CQ:image,[^\]]*\]`)
+
+// visionProvider is used to convert the image to the text description,
+// because the chat model can not see the image directly.
+type visionProvider interface {
+	Describe(ctx context.Context, image []byte, prompt string) (string, error)
+}
+
+func newVisionProvider(config *Config) visionProvider {
+	cfg := config.Vision
+	client := &http.Client{}
+	switch strings.ToLower(cfg.Provider) {
+	case "", visionProviderOpenAI:
+		return &openaiVision{
+			BaseURL: cfg.BaseURL,
+			APIKey:  cfg.APIKey,
+			Model:   cfg.Model,
+			client:  client,
+		}
+	case visionProviderOllama:
+		return &ollamaVision{
+			BaseURL: cfg.BaseURL,
+			Model:   cfg.Model,
+			client:  client,
+		}
+	default:
+		log.Println("[warning] unknown vision provider:", cfg.Provider)
+		return nil
+	}
+}
+
+// describeImages is used to download the images in the message, then replace
+// the CQ code in message with the description from the vision provider.
+func (bot *DeepBot) describeImages(ctx *zero.Ctx, msg string) string {
+	if !bot.config.Vision.Enabled || bot.vision == nil {
+		return msg
+	}
+	images := messageImages(ctx, false)
+	if len(images) == 0 {
+		return msg
+	}
+	maxImages := bot.config.Vision.MaxImages
+	if maxImages < 1 {
+		maxImages = defaultVisionImages
+	}
+	if len(images) > maxImages {
+		images = images[:maxImages]
+	}
+	question := strings.TrimSpace(cqImageRegexp.ReplaceAllString(msg, ""))
+
+	prompt := bot.config.Vision.Prompt
+	if prompt == "" {
+		prompt = defaultVisionPrompt
+	}
+	if question != "" {
+		prompt += "\n用户关于图片的问题是: " + question
+	}
+	timeout := defaultVisionTimeout
+	if bot.config.Vision.Timeout > 0 {
+		timeout = time.Duration(bot.config.Vision.Timeout) * time.Millisecond
+	}
+	var descriptions []string
+	for _, segment := range images {
+		c, cancel := context.WithTimeout(context.Background(), timeout)
+		description, err := bot.describeImage(c, ctx, segment, prompt)
+		cancel()
+		if err != nil {
+			log.Println("failed to describe image:", err)
+			description = "(无法识别图片内容)"
+		}
+		descriptions = append(descriptions, description)
+	}
+	return buildImageQuestion(question, descriptions)
+}
+
+func (bot *DeepBot) describeImage(c context.Context, ctx *zero.Ctx, segment message.Segment, prompt string) (string, error) {
+	img, err := fetchImage(c, ctx, segment)
+	if err != nil {
+		return "", err
+	}
+	return bot.vision.Describe(c, img, prompt)
+}
+
+// buildImageQuestion is used to inject the image descriptions to question.
+func buildImageQuestion(question string, descriptions []string) string {
+	builder := strings.Builder{}
+	builder.WriteString(question)
+	if question != "" {
+		builder.WriteString("\n\n")
+	}
+	builder.WriteString("[用户发送了图片，以下是图片内容的描述]\n")
+	for i, description := range descriptions {
+		builder.WriteString(fmt.Sprintf("图片%d: %s\n", i+1, strings.TrimSpace(description)))
+	}
+	return strings.TrimSpace(builder.String())
+}
+
+func doVisionRequest(client *http.Client, req *http.Request, result any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(data) > 256 {
+			data = data[:256]
+		}
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, data)
+	}
+	return jsonDecode(data, result)
+}
+
+// openaiVision is used for the OpenAI compatible API that support image input.
+type openaiVision struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+
+	client *http.Client
+}
+
+func (ov *openaiVision) Describe(ctx context.Context, image []byte, prompt string) (string, error) {
+	dataURL := "data:" + http.DetectContentType(image) + ";base64," +
+		base64.StdEncoding.EncodeToString(image)
+	type content struct {
+		Type     string            `json:"type"`
+		Text     string            `json:"text,omitempty"`
+		ImageURL map[string]string `json:"image_url,omitempty"`
+	}
+	body := map[string]any{
+		"model": ov.Model,
+		"messages": []map[string]any{
+			{
+				"role": "user",
+				"content": []*content{
+					{Type: "text", Text: prompt},
+					{Type: "image_url", ImageURL: map[string]string{"url": dataURL}},
+				},
+			},
+		},
+		"max_tokens": 2048,
+	}
+	data, err := jsonEncode(body)
+	if err != nil {
+		return "", err
+	}
+	URL := strings.TrimSuffix(ov.BaseURL, "/") + "/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, URL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ov.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+ov.APIKey)
+	}
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	err = doVisionRequest(ov.client, req, &result)
+	if err != nil {
+		return "", err
+	}
+	if len(result.Choices) == 0 || result.Choices[0].Message.Content == "" {
+		return "", errors.New("receive empty description")
+	}
+	return result.Choices[0].Message.Content, nil
+}
+
+// ollamaVision is used for the local multimodal model like llava and minicpm-v.
+type ollamaVision struct {
+	BaseURL string
+	Model   string
+
+	client *http.Client
+}
+
+func (ov *ollamaVision) Describe(ctx context.Context, image []byte, prompt string) (string, error) {
+	body := map[string]any{
+		"model":  ov.Model,
+		"prompt": prompt,
+		"images": []string{base64.StdEncoding.EncodeToString(image)},
+		"stream": false,
+	}
+	data, err := jsonEncode(body)
+	if err != nil {
+		return "", err
+	}
+	URL := strings.TrimSuffix(ov.BaseURL, "/") + "/api/generate"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, URL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var result struct {
+		Response string `json:"response"`
+	}
+	err = doVisionRequest(ov.client, req, &result)
+	if err != nil {
+		return "", err
+	}
+	if result.Response == "" {
+		return "", errors.New("receive empty description")
+	}
+	return result.Response, nil
+}
@@ -0,0 +1,109 @@
+package deepbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testPNGImage(t *testing.T) []byte {
+	buf := bytes.NewBuffer(nil)
+	err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestBuildImageQuestion(t *testing.T) {
+	output := buildImageQuestion("这是什么", []string{" 一只猫 ", "一只狗"})
+	expected := "这是什么\n\n[用户发送了图片，以下是图片内容的描述]\n图片1: 一只猫\n图片2: 一只狗"
+	require.Equal(t, expected, output)
+
+	output = buildImageQuestion("", []string{"一只猫"})
+	expected = "[用户发送了图片，以下是图片内容的描述]\n图片1: 一只猫"
+	require.Equal(t, expected, output)
+
+	msg := "[CQ:image,file=abc.image,url=https://example.com/a?b=1&amp;c=2]这是什么"
+	require.Equal(t, "这是什么", cqImageRegexp.ReplaceAllString(msg, ""))
+}
+
+func TestOpenAIVision(t *testing.T) {
+	img := testPNGImage(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/chat/completions", r.URL.Path)
+		require.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+
+		var req struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Content []struct {
+					Type     string            `json:"type"`
+					Text     string            `json:"text"`
+					ImageURL map[string]string `json:"image_url"`
+				} `json:"content"`
+			} `json:"messages"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		require.NoError(t, err)
+		require.Equal(t, "model", req.Model)
+		content := req.Messages[0].Content
+		require.Equal(t, "prompt", content[0].Text)
+		require.True(t, strings.HasPrefix(content[1].ImageURL["url"], "data:image/png;base64,"))
+
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"一张空白图片"}}]}`))
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.Vision.BaseURL = server.URL + "/v1/"
+	config.Vision.APIKey = "key"
+	config.Vision.Model = "model"
+	vision := newVisionProvider(config)
+
+	output, err := vision.Describe(context.Background(), img, "prompt")
+	require.NoError(t, err)
+	require.Equal(t, "一张空白图片", output)
+}
+
+func TestOllamaVision(t *testing.T) {
+	img := testPNGImage(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/generate", r.URL.Path)
+
+		var req struct {
+			Model  string   `json:"model"`
+			Prompt string   `json:"prompt"`
+			Images [][]byte `json:"images"`
+			Stream bool     `json:"stream"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		require.NoError(t, err)
+		require.Equal(t, "llava", req.Model)
+		require.Equal(t, "prompt", req.Prompt)
+		require.Equal(t, img, req.Images[0])
+		require.False(t, req.Stream)
+
+		_, _ = w.Write([]byte(`{"response":"一张空白图片","done":true}`))
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.Vision.Provider = "ollama"
+	config.Vision.BaseURL = server.URL
+	config.Vision.Model = "llava"
+	vision := newVisionProvider(config)
+
+	output, err := vision.Describe(context.Background(), img, "prompt")
+	require.NoError(t, err)
+	require.Equal(t, "一张空白图片", output)
+
+	config.Vision.Provider = "unknown"
+	require.Nil(t, newVisionProvider(config))
+}
@@ -0,0 +1,406 @@
+package deepbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const (
+	sttProviderOpenAI  = "openai"
+	sttProviderWhisper = "whisper.cpp"
+
+	ttsProviderOpenAI = "openai"
+	ttsProviderHTTP   = "http"
+)
+
+const (
+	ttsModeVoice  = "voice"  // only reply voice message with voice
+	ttsModeAlways = "always" // reply all messages with voice
+)
+
+const (
+	defaultVoiceTimeout  = 60 * time.Second
+	defaultTTSMaxLength  = 300
+	maxVoiceResponseSize = 32 * 1024 * 1024
+	recordFilename       = "voice.mp3"
+)
+
+// sttProvider is used to transcribe the voice message to text.
+type sttProvider interface {
+	Transcribe(ctx context.Context, audio []byte, filename string) (string, error)
+}
+
+// ttsProvider is used to synthesize the voice clip from text.
+type ttsProvider interface {
+	Synthesize(ctx context.Context, text, voice string) ([]byte, error)
+}
+
+func newSTTProvider(config *Config) sttProvider {
+	cfg := config.STT
+	switch strings.ToLower(cfg.Provider) {
+	case "", sttProviderOpenAI:
+		return &openaiSTT{
+			URL:      cfg.URL,
+			APIKey:   cfg.APIKey,
+			Model:    cfg.Model,
+			Language: cfg.Language,
+		}
+	case sttProviderWhisper:
+		return &whisperSTT{
+			URL:      cfg.URL,
+			Language: cfg.Language,
+		}
+	default:
+		log.Println("[warning] unknown stt provider:", cfg.Provider)
+		return nil
+	}
+}
+
+func newTTSProvider(config *Config) ttsProvider {
+	cfg := config.TTS
+	switch strings.ToLower(cfg.Provider) {
+	case "", ttsProviderOpenAI:
+		return &openaiTTS{
+			URL:    cfg.URL,
+			APIKey: cfg.APIKey,
+			Model:  cfg.Model,
+		}
+	case ttsProviderHTTP:
+		return &httpTTS{
+			URL: cfg.URL,
+		}
+	default:
+		log.Println("[warning] unknown tts provider:", cfg.Provider)
+		return nil
+	}
+}
+
+// transcribeVoice is used to convert the voice message to text, if the
+// message is not a voice message, it will return the original message.
+// The returned bool is true if the message is converted from voice.
+func (bot *DeepBot) transcribeVoice(ctx *zero.Ctx, msg string) (string, bool) {
+	if !bot.config.STT.Enabled || bot.stt == nil {
+		return msg, false
+	}
+	var record *message.Segment
+	for _, segment := range ctx.Event.Message {
+		if segment.Type == "record" {
+			record = &segment
+			break
+		}
+	}
+	if record == nil {
+		return msg, false
+	}
+	c, cancel := context.WithTimeout(context.Background(), bot.voiceTimeout(bot.config.STT.Timeout))
+	defer cancel()
+	audio, filename, err := readRecord(c, ctx, record)
+	if err != nil {
+		log.Println("failed to read voice message:", err)
+		return "", true
+	}
+	text, err := bot.stt.Transcribe(c, audio, filename)
+	if err != nil {
+		log.Println("failed to transcribe voice message:", err)
+		return "", true
+	}
+	text = strings.TrimSpace(text)
+
+	fmt.Println("==============Voice Message=============")
+	fmt.Println(text)
+	fmt.Println("========================================")
+
+	return text, true
+}
+
+// readRecord is used to read the voice data, the original format about
+// QQ voice is silk, so it need be converted by OneBot implementation.
+func readRecord(c context.Context, ctx *zero.Ctx, record *message.Segment) ([]byte, string, error) {
+	resp := ctx.CallAction("get_record", zero.Params{
+		"file":       record.Data["file"],
+		"out_format": "mp3",
+	}).Data
+	if b64 := resp.Get("base64").String(); b64 != "" {
+		audio, err := base64.StdEncoding.DecodeString(b64)
+		if err == nil {
+			return audio, recordFilename, nil
+		}
+	}
+	// the OneBot implementation is running on the same host
+	if path := resp.Get("file").String(); path != "" {
+		audio, err := os.ReadFile(path)
+		if err == nil {
+			return audio, recordFilename, nil
+		}
+	}
+	URL := record.Data["url"]
+	if URL == "" {
+		return nil, "", errors.New("failed to get voice data")
+	}
+	req, err := http.NewRequestWithContext(c, http.MethodGet, URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	audio, err := doVoiceRequest(http.DefaultClient, req)
+	if err != nil {
+		return nil, "", err
+	}
+	return audio, recordFilename, nil
+}
+
+// replyVoice is used to reply the answer with a synthesized voice clip,
+// the voice is selected by the current character of user.
+func (bot *DeepBot) replyVoice(ctx *zero.Ctx, user *user, msg string, fromVoice bool) {
+	cfg := bot.config.TTS
+	if !cfg.Enabled || bot.tts == nil {
+		return
+	}
+	if cfg.Mode != ttsModeAlways && !fromVoice {
+		return
+	}
+	voice := bot.selectVoice(user.getRole())
+	if voice == "" {
+		return
+	}
+	text := speechText(msg)
+	maxLength := cfg.MaxLength
+	if maxLength < 1 {
+		maxLength = defaultTTSMaxLength
+	}
+	if text == "" || len([]rune(text)) > maxLength {
+		return
+	}
+	c, cancel := context.WithTimeout(context.Background(), bot.voiceTimeout(cfg.Timeout))
+	defer cancel()
+	audio, err := bot.tts.Synthesize(c, text, voice)
+	if err != nil {
+		log.Println("failed to synthesize voice:", err)
+		return
+	}
+	ctx.Send(message.Record("base64://" + base64.StdEncoding.EncodeToString(audio)))
+}
+
+// selectVoice is used to select the voice about character, if the
+// character is not set in config, the default voice will be used.
+func (bot *DeepBot) selectVoice(role string) string {
+	cfg := bot.config.TTS
+	if voice, ok := cfg.Voices[role]; ok {
+		return voice
+	}
+	return cfg.Voice
+}
+
+func (bot *DeepBot) voiceTimeout(timeout int) time.Duration {
+	if timeout < 1 {
+		return defaultVoiceTimeout
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+var (
+	speechCodeRegexp  = regexp.MustCompile("(?s)```.*?```")
+	speechLinkRegexp  = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+	speechURLRegexp   = regexp.MustCompile(`https?://\S+`)
+	speechMarkRegexp  = regexp.MustCompile("(?m)^\\s*(#{1,6}|>|[-*+]|\\d+\\.)\\s+|[*_`~|]")
+	speechSpaceRegexp = regexp.MustCompile(`\s+`)
+)
+
+// speechText is used to remove the content that not suitable for speech.
+func speechText(msg string) string {
+	text := speechCodeRegexp.ReplaceAllString(msg, "")
+	text = speechLinkRegexp.ReplaceAllString(text, "$1")
+	text = speechURLRegexp.ReplaceAllString(text, "")
+	text = speechMarkRegexp.ReplaceAllString(text, "")
+	text = speechSpaceRegexp.ReplaceAllString(text, " ")
+	return strings.TrimSpace(text)
+}
+
+func doVoiceRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxVoiceResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(data) > 256 {
+			data = data[:256]
+		}
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, data)
+	}
+	return data, nil
+}
+
+func newTranscribeRequest(ctx context.Context, URL string, audio []byte, filename string, fields map[string]string) (*http.Request, error) {
+	body := bytes.NewBuffer(make([]byte, 0, len(audio)+1024))
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+	_, err = part.Write(audio)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range fields {
+		if value == "" {
+			continue
+		}
+		err = writer.WriteField(key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, URL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req, nil
+}
+
+// openaiSTT is used for the OpenAI compatible transcription API,
+// like the faster-whisper-server and LocalAI.
+type openaiSTT struct {
+	URL      string
+	APIKey   string
+	Model    string
+	Language string
+}
+
+func (st *openaiSTT) Transcribe(ctx context.Context, audio []byte, filename string) (string, error) {
+	model := st.Model
+	if model == "" {
+		model = "whisper-1"
+	}
+	fields := map[string]string{
+		"model":           model,
+		"language":        st.Language,
+		"response_format": "json",
+	}
+	URL := strings.TrimSuffix(st.URL, "/") + "/audio/transcriptions"
+	req, err := newTranscribeRequest(ctx, URL, audio, filename, fields)
+	if err != nil {
+		return "", err
+	}
+	if st.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+st.APIKey)
+	}
+	return decodeTranscription(req)
+}
+
+// whisperSTT is used for the server example about whisper.cpp.
+type whisperSTT struct {
+	URL      string
+	Language string
+}
+
+func (ws *whisperSTT) Transcribe(ctx context.Context, audio []byte, filename string) (string, error) {
+	fields := map[string]string{
+		"temperature":     "0.0",
+		"language":        ws.Language,
+		"response_format": "json",
+	}
+	URL := strings.TrimSuffix(ws.URL, "/") + "/inference"
+	req, err := newTranscribeRequest(ctx, URL, audio, filename, fields)
+	if err != nil {
+		return "", err
+	}
+	return decodeTranscription(req)
+}
+
+func decodeTranscription(req *http.Request) (string, error) {
+	data, err := doVoiceRequest(http.DefaultClient, req)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	err = jsonDecode(data, &result)
+	if err != nil {
+		return "", err
+	}
+	return result.Text, nil
+}
+
+// openaiTTS is used for the OpenAI compatible speech API.
+type openaiTTS struct {
+	URL    string
+	APIKey string
+	Model  string
+}
+
+func (ot *openaiTTS) Synthesize(ctx context.Context, text, voice string) ([]byte, error) {
+	model := ot.Model
+	if model == "" {
+		model = "tts-1"
+	}
+	body := map[string]any{
+		"model":           model,
+		"input":           text,
+		"voice":           voice,
+		"response_format": "mp3",
+	}
+	data, err := jsonEncode(body)
+	if err != nil {
+		return nil, err
+	}
+	URL := strings.TrimSuffix(ot.URL, "/") + "/audio/speech"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ot.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+ot.APIKey)
+	}
+	return doVoiceRequest(http.DefaultClient, req)
+}
+
+// httpTTS is used for the TTS service that use GET request with the URL
+// template like "http://127.0.0.1:9880/?text={{text}}&speaker={{voice}}",
+// such as the GPT-SoVITS and vits-simple-api.
+type httpTTS struct {
+	URL string
+}
+
+func (ht *httpTTS) Synthesize(ctx context.Context, text, voice string) ([]byte, error) {
+	URL := strings.ReplaceAll(ht.URL, "{{text}}", url.QueryEscape(text))
+	URL = strings.ReplaceAll(URL, "{{voice}}", url.QueryEscape(voice))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	audio, err := doVoiceRequest(http.DefaultClient, req)
+	if err != nil {
+		return nil, err
+	}
+	// some services will return the error message with status code 200
+	if strings.HasPrefix(http.DetectContentType(audio), "text/") {
+		return nil, fmt.Errorf("invalid audio data: %s", audio)
+	}
+	return audio, nil
+}
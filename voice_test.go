@@ -0,0 +1,125 @@
+package deepbot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSTTProvider(t *testing.T) {
+	audio := []byte("fake audio data")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		require.NoError(t, err)
+		data, err := io.ReadAll(file)
+		require.NoError(t, err)
+		require.Equal(t, audio, data)
+		require.Equal(t, "voice.mp3", header.Filename)
+		require.Equal(t, "zh", r.FormValue("language"))
+		require.Equal(t, "json", r.FormValue("response_format"))
+
+		switch r.URL.Path {
+		case "/v1/audio/transcriptions":
+			require.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+			require.Equal(t, "whisper-1", r.FormValue("model"))
+		case "/inference":
+			require.Equal(t, "0.0", r.FormValue("temperature"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"text":" 你好 "}`))
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.STT.URL = server.URL + "/v1"
+	config.STT.APIKey = "key"
+	config.STT.Language = "zh"
+	stt := newSTTProvider(config)
+	text, err := stt.Transcribe(context.Background(), audio, recordFilename)
+	require.NoError(t, err)
+	require.Equal(t, " 你好 ", text)
+
+	config.STT.Provider = "whisper.cpp"
+	config.STT.URL = server.URL
+	stt = newSTTProvider(config)
+	text, err = stt.Transcribe(context.Background(), audio, recordFilename)
+	require.NoError(t, err)
+	require.Equal(t, " 你好 ", text)
+
+	config.STT.Provider = "unknown"
+	require.Nil(t, newSTTProvider(config))
+}
+
+func TestTTSProvider(t *testing.T) {
+	audio := []byte("ID3 fake mp3 data\x00\x01")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/audio/speech":
+			require.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+			var req map[string]string
+			err := json.NewDecoder(r.Body).Decode(&req)
+			require.NoError(t, err)
+			require.Equal(t, "tts-1", req["model"])
+			require.Equal(t, "你好", req["input"])
+			require.Equal(t, "nova", req["voice"])
+		case "/tts":
+			require.Equal(t, "你好", r.URL.Query().Get("text"))
+			require.Equal(t, "nova", r.URL.Query().Get("speaker"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(audio)
+	}))
+	defer server.Close()
+
+	config := new(Config)
+	config.TTS.URL = server.URL + "/v1/"
+	config.TTS.APIKey = "key"
+	tts := newTTSProvider(config)
+	data, err := tts.Synthesize(context.Background(), "你好", "nova")
+	require.NoError(t, err)
+	require.Equal(t, audio, data)
+
+	config.TTS.Provider = "http"
+	config.TTS.URL = server.URL + "/tts?text={{text}}&speaker={{voice}}"
+	tts = newTTSProvider(config)
+	data, err = tts.Synthesize(context.Background(), "你好", "nova")
+	require.NoError(t, err)
+	require.Equal(t, audio, data)
+
+	config.TTS.Provider = "unknown"
+	require.Nil(t, newTTSProvider(config))
+}
+
+func TestSelectVoice(t *testing.T) {
+	config := new(Config)
+	config.TTS.Voice = "alloy"
+	config.TTS.Voices = map[string]string{
+		"猫娘":  "nova",
+		"程序员": "",
+	}
+	bot := &DeepBot{config: config}
+
+	require.Equal(t, "nova", bot.selectVoice("猫娘"))
+	require.Equal(t, "", bot.selectVoice("程序员"))
+	require.Equal(t, "alloy", bot.selectVoice(""))
+	require.Equal(t, "alloy", bot.selectVoice("其他"))
+}
+
+func TestSpeechText(t *testing.T) {
+	msg := "## 标题\n" +
+		"这是**重点**内容，参考[文档](https://go.dev/doc)。\n" +
+		"- 第一项\n" +
+		"1. 第二项\n" +
+		"```go\nfmt.Println(1)\n```\n" +
+		"链接 https://go.dev/ 结束"
+	require.Equal(t, "标题 这是重点内容，参考文档。 第一项 第二项 链接 结束", speechText(msg))
+}